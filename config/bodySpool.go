@@ -0,0 +1,153 @@
+package config
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"interceptor-grpc/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrSpillBudgetExceeded is returned by ReadRequestBody when spooling the body
+// would exceed BODY_SPILL_DISK_BUDGET.
+var ErrSpillBudgetExceeded = errors.New("body spill disk budget exceeded")
+
+var spoolMutex sync.Mutex
+var spooledBytes int64
+var spooledFiles = map[string]int64{}
+
+// spillPattern é o nome dos arquivos de corpo em BODY_SPILL_DIR.
+const spillPattern = "interceptor-body-*"
+
+var spillDirCleaned sync.Once
+
+var spooledBytesGauge = metrics.NewGauge("interceptor_spooled_body_bytes",
+	"Bytes of request bodies currently spooled to disk")
+var spooledFilesGauge = metrics.NewGauge("interceptor_spooled_body_files",
+	"Request bodies currently spooled to disk")
+
+func reserveSpool(n int64) bool {
+	spoolMutex.Lock()
+	defer spoolMutex.Unlock()
+	if spooledBytes+n > GetBodySpillDiskBudget() {
+		return false
+	}
+	spooledBytes += n
+	spooledBytesGauge.Set(spooledBytes)
+	return true
+}
+
+func unreserveSpool(n int64) {
+	spoolMutex.Lock()
+	defer spoolMutex.Unlock()
+	spooledBytes -= n
+	spooledBytesGauge.Set(spooledBytes)
+}
+
+// cleanSpillDir remove os corpos que sobraram de um processo anterior (crash,
+// OOM kill): nenhuma entrada deste processo aponta pra eles e o orçamento só
+// conta os arquivos criados aqui.
+func cleanSpillDir() {
+	leftovers, _ := filepath.Glob(filepath.Join(GetBodySpillDir(), spillPattern))
+	for _, name := range leftovers {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Err(err).Str("file", name).Msg("Error removing leftover spooled request body")
+		}
+	}
+	if len(leftovers) > 0 {
+		log.Info().Int("files", len(leftovers)).Msg("Removed leftover spooled request bodies")
+	}
+}
+
+// budgetWriter reserva orçamento de disco à medida que escreve, pra que um
+// upload enorme falhe no meio em vez de lotar o volume.
+type budgetWriter struct {
+	f        *os.File
+	reserved int64
+}
+
+func (b *budgetWriter) Write(p []byte) (int, error) {
+	if !reserveSpool(int64(len(p))) {
+		return 0, ErrSpillBudgetExceeded
+	}
+	b.reserved += int64(len(p))
+	return b.f.Write(p)
+}
+
+// ReadRequestBody reads the request body into data: in memory up to
+// BODY_MEMORY_THRESHOLD, spooled to a temp file above it. The file belongs
+// to the buffered entry and is removed by ReleaseBody. Leftover files from a
+// previous process are removed before the first spill.
+func ReadRequestBody(data *RequestData, body io.Reader) error {
+	threshold := GetBodyMemoryThreshold()
+	if threshold == 0 {
+		b, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		data.Body = b
+		return nil
+	}
+
+	head, err := io.ReadAll(io.LimitReader(body, threshold+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(head)) <= threshold {
+		data.Body = head
+		return nil
+	}
+
+	spillDirCleaned.Do(cleanSpillDir)
+	f, err := os.CreateTemp(GetBodySpillDir(), spillPattern)
+	if err != nil {
+		return err
+	}
+	w := &budgetWriter{f: f}
+	_, err = w.Write(head)
+	if err == nil {
+		_, err = io.Copy(w, body)
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		unreserveSpool(w.reserved)
+		return err
+	}
+
+	spoolMutex.Lock()
+	spooledFiles[f.Name()] = w.reserved
+	spooledFilesGauge.Set(int64(len(spooledFiles)))
+	spoolMutex.Unlock()
+
+	data.BodyFile = f.Name()
+	data.BodySize = w.reserved
+	return nil
+}
+
+// ReleaseBody removes a spooled body file and returns its disk budget. Safe to
+// call more than once and on in-memory bodies.
+func ReleaseBody(data RequestData) {
+	if data.BodyFile == "" {
+		return
+	}
+	spoolMutex.Lock()
+	size, ok := spooledFiles[data.BodyFile]
+	delete(spooledFiles, data.BodyFile)
+	spooledFilesGauge.Set(int64(len(spooledFiles)))
+	spoolMutex.Unlock()
+	if !ok {
+		return
+	}
+	if err := os.Remove(data.BodyFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Err(err).Str("file", data.BodyFile).Msg("Error removing spooled request body")
+	}
+	unreserveSpool(size)
+}
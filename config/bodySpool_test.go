@@ -0,0 +1,126 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func spooled() (int64, int) {
+	spoolMutex.Lock()
+	defer spoolMutex.Unlock()
+	return spooledBytes, len(spooledFiles)
+}
+
+func spillFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, spillPattern))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestReadRequestBodySpillAndRelease(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("BODY_SPILL_DIR", dir)
+	t.Setenv("BODY_MEMORY_THRESHOLD", "16")
+
+	var small RequestData
+	if err := ReadRequestBody(&small, bytes.NewReader([]byte("sixteen bytes..."))); err != nil {
+		t.Fatal(err)
+	}
+	if small.BodyFile != "" || string(small.Body) != "sixteen bytes..." {
+		t.Errorf("body at the threshold spooled: %+v", small)
+	}
+
+	bytesBefore, filesBefore := spooled()
+	body := bytes.Repeat([]byte("x"), 100)
+	var data RequestData
+	if err := ReadRequestBody(&data, bytes.NewReader(body)); err != nil {
+		t.Fatal(err)
+	}
+	if data.BodyFile == "" || data.Body != nil || data.BodySize != 100 {
+		t.Fatalf("body not spooled: file %q, %d bytes in memory, size %d", data.BodyFile, len(data.Body), data.BodySize)
+	}
+	if got, err := os.ReadFile(data.BodyFile); err != nil || !bytes.Equal(got, body) {
+		t.Errorf("spooled file content mismatch (err %v)", err)
+	}
+	if n, files := spooled(); n != bytesBefore+100 || files != filesBefore+1 {
+		t.Errorf("budget after spill: %d bytes, %d files", n-bytesBefore, files-filesBefore)
+	}
+
+	ReleaseBody(data)
+	ReleaseBody(data)
+	if _, err := os.Stat(data.BodyFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("spooled file kept after release: %v", err)
+	}
+	if n, files := spooled(); n != bytesBefore || files != filesBefore {
+		t.Errorf("budget after release: %d bytes, %d files", n-bytesBefore, files-filesBefore)
+	}
+}
+
+func TestReadRequestBodyBudgetExceeded(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("BODY_SPILL_DIR", dir)
+	t.Setenv("BODY_MEMORY_THRESHOLD", "16")
+	bytesBefore, _ := spooled()
+	t.Setenv("BODY_SPILL_DISK_BUDGET", strconv.FormatInt(bytesBefore+64, 10))
+
+	// O head (threshold+1) cabe, a cópia do resto estoura no meio.
+	var data RequestData
+	err := ReadRequestBody(&data, bytes.NewReader(bytes.Repeat([]byte("x"), 200)))
+	if !errors.Is(err, ErrSpillBudgetExceeded) {
+		t.Fatalf("err = %v, want ErrSpillBudgetExceeded", err)
+	}
+	if data.BodyFile != "" {
+		t.Errorf("failed body kept file %q", data.BodyFile)
+	}
+	if files := spillFiles(t, dir); len(files) != 0 {
+		t.Errorf("partial spill left %v", files)
+	}
+	if n, _ := spooled(); n != bytesBefore {
+		t.Errorf("budget leaked %d bytes", n-bytesBefore)
+	}
+}
+
+func TestReadRequestBodyCleansLeftovers(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("BODY_SPILL_DIR", dir)
+	t.Setenv("BODY_MEMORY_THRESHOLD", "16")
+	spillDirCleaned = sync.Once{}
+
+	leftover := filepath.Join(dir, "interceptor-body-123")
+	other := filepath.Join(dir, "unrelated")
+	for _, name := range []string{leftover, other} {
+		if err := os.WriteFile(name, []byte("old"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var data RequestData
+	if err := ReadRequestBody(&data, bytes.NewReader(bytes.Repeat([]byte("x"), 100))); err != nil {
+		t.Fatal(err)
+	}
+	defer ReleaseBody(data)
+	if _, err := os.Stat(leftover); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("leftover body kept: %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("unrelated file removed: %v", err)
+	}
+
+	// Só no primeiro despejo: os corpos deste processo ficam.
+	var second RequestData
+	if err := ReadRequestBody(&second, bytes.NewReader(bytes.Repeat([]byte("y"), 100))); err != nil {
+		t.Fatal(err)
+	}
+	defer ReleaseBody(second)
+	if _, err := os.Stat(data.BodyFile); err != nil {
+		t.Errorf("live spooled body removed: %v", err)
+	}
+}
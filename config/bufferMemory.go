@@ -0,0 +1,259 @@
+package config
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"interceptor-grpc/metrics"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/rs/zerolog/log"
+)
+
+// Custo fixo aproximado de uma entrada (BufferedRequest + chaves dos maps),
+// pra que entradas sem corpo não contem como zero.
+const entryOverhead = 128
+
+// Teto do cache de headers internados: acima disso o cache é zerado (os
+// maps já compartilhados continuam válidos, só deixam de ser reaproveitados).
+const maxInternedHeaders = 4096
+
+// Bytes estimados de TODAS as entradas do buffer, e só das ainda não
+// cobertas por snapshot (Pending/Processed) — é essa que um snapshot reduz,
+// então é ela que o teto BUFFER_MAX_BYTES observa.
+var bufferedBytes atomic.Int64
+var unsnapshottedBytes atomic.Int64
+
+// SnapshotTrigger pede ao snapshotter um snapshot fora do intervalo. Envio
+// não bloqueante via TriggerEarlySnapshot.
+var SnapshotTrigger = make(chan string, 1)
+
+var compressedBodiesTotal = metrics.NewCounter("interceptor_buffer_compressed_bodies_total",
+	"Buffered request bodies stored compressed")
+var compressionSavedBytesTotal = metrics.NewCounter("interceptor_buffer_compression_saved_bytes_total",
+	"Bytes saved by compressing buffered request bodies")
+var internedHeaderHitsTotal = metrics.NewCounter("interceptor_buffer_interned_header_hits_total",
+	"Buffered requests that reused an already interned header map")
+
+// TriggerEarlySnapshot pede um snapshot antecipado; se já há um pedido
+// pendente, este é descartado e retorna false.
+func TriggerEarlySnapshot(reason string) bool {
+	select {
+	case SnapshotTrigger <- reason:
+		return true
+	default:
+		return false
+	}
+}
+
+// EncodeAll/DecodeAll são seguros pra uso concorrente: um encoder e um
+// decoder zstd pro processo inteiro.
+var zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+var flateWriters = sync.Pool{New: func() any {
+	w, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+	return w
+}}
+
+// compressBody comprime o corpo de uma cópia HTTP que vai pro buffer. Corpos
+// de outros protocolos são reenviados crus pelos próprios replays e ficam
+// intocados; corpos em disco também.
+func compressBody(data RequestData) RequestData {
+	encoding := GetBufferCompression()
+	if encoding == "" || data.Protocol != "" || data.BodyFile != "" || data.BodyEncoding != "" ||
+		len(data.Body) < GetBufferCompressionMinSize() {
+		return data
+	}
+
+	var compressed []byte
+	var err error
+	switch encoding {
+	case "zstd":
+		compressed = zstdEncoder.EncodeAll(data.Body, nil)
+	case "snappy":
+		compressed = s2.EncodeSnappy(nil, data.Body)
+	case "gzip":
+		var b bytes.Buffer
+		w := gzipWriters.Get().(*gzip.Writer)
+		w.Reset(&b)
+		if _, err = w.Write(data.Body); err == nil {
+			err = w.Close()
+		}
+		gzipWriters.Put(w)
+		compressed = b.Bytes()
+	case "flate":
+		var b bytes.Buffer
+		w := flateWriters.Get().(*flate.Writer)
+		w.Reset(&b)
+		if _, err = w.Write(data.Body); err == nil {
+			err = w.Close()
+		}
+		flateWriters.Put(w)
+		compressed = b.Bytes()
+	}
+	if err != nil || len(compressed) >= len(data.Body) {
+		// Incompressível (já comprimido, binário): guarda como veio.
+		return data
+	}
+
+	compressedBodiesTotal.Inc()
+	compressionSavedBytesTotal.Add(int64(len(data.Body) - len(compressed)))
+	data.BodySize = int64(len(data.Body))
+	data.Body = compressed
+	data.BodyEncoding = encoding
+	return data
+}
+
+var internMutex sync.Mutex
+var internedHeaders = map[string]http.Header{}
+var internedStrings = map[string]string{}
+
+func internString(s string) string {
+	if v, ok := internedStrings[s]; ok {
+		return v
+	}
+	internedStrings[s] = s
+	return s
+}
+
+// volatileHeaders mudam a cada request do mesmo cliente (ids de correlação e
+// trace, timestamps): ficam fora da chave de internação, senão nenhum map
+// seria reaproveitado. O header de correlação configurado também entra.
+var volatileHeaders = map[string]bool{
+	"Date":            true,
+	"Traceparent":     true,
+	"Tracestate":      true,
+	"X-Request-Id":    true,
+	"X-Request-Start": true,
+	"X-Amzn-Trace-Id": true,
+	"X-B3-Traceid":    true,
+	"X-B3-Spanid":     true,
+}
+
+func isVolatileHeader(name string) bool {
+	return volatileHeaders[name] || name == http.CanonicalHeaderKey(GetCorrelationIDHeader())
+}
+
+// internHeader devolve um map compartilhado quando outro request já trouxe
+// os mesmos headers estáveis (caso comum: o mesmo cliente/SDK repetindo
+// chamadas), e senão internaliza nomes e valores. Com headers voláteis a
+// entrada ganha um map próprio que aponta pros valores estáveis internados e
+// guarda só os voláteis. Retorna também os bytes novos que a entrada passa a
+// ocupar.
+func internHeader(h http.Header) (http.Header, int64) {
+	if h == nil {
+		return nil, 0
+	}
+	names := make([]string, 0, len(h))
+	var volatile []string
+	for name := range h {
+		if isVolatileHeader(name) {
+			volatile = append(volatile, name)
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var key strings.Builder
+	for _, name := range names {
+		key.WriteString(name)
+		for _, value := range h[name] {
+			key.WriteByte(0)
+			key.WriteString(value)
+		}
+		key.WriteByte('\n')
+	}
+
+	shared, size := internStable(h, names, key.String())
+	if len(volatile) == 0 {
+		return shared, size
+	}
+	own := make(http.Header, len(shared)+len(volatile))
+	for name, values := range shared {
+		own[name] = values
+	}
+	// Custo aproximado das entradas do map próprio.
+	size += int64(len(own)) * 16
+	for _, name := range volatile {
+		own[name] = h[name]
+		size += int64(len(name))
+		for _, value := range h[name] {
+			size += int64(len(value))
+		}
+	}
+	return own, size
+}
+
+func internStable(h http.Header, names []string, key string) (http.Header, int64) {
+	internMutex.Lock()
+	defer internMutex.Unlock()
+
+	if shared, ok := internedHeaders[key]; ok {
+		internedHeaderHitsTotal.Inc()
+		return shared, 0
+	}
+	if len(internedHeaders) >= maxInternedHeaders {
+		internedHeaders = map[string]http.Header{}
+		internedStrings = map[string]string{}
+	}
+	interned := make(http.Header, len(names))
+	for _, name := range names {
+		values := h[name]
+		internedValues := make([]string, len(values))
+		for i, value := range values {
+			internedValues[i] = internString(value)
+		}
+		interned[internString(name)] = internedValues
+	}
+	internedHeaders[key] = interned
+	return interned, int64(len(key))
+}
+
+// prepareForBuffer aplica compressão e internação à cópia que vai pro buffer
+// e devolve o custo estimado da entrada.
+func prepareForBuffer(data RequestData) (RequestData, int64) {
+	data.Deadline = time.Time{}
+	data = compressBody(data)
+	header, headerBytes := internHeader(data.Header)
+	data.Header = header
+	size := entryOverhead + int64(len(data.Body)) + headerBytes +
+		int64(len(data.Method)+len(data.Path)+len(data.RawPath)+len(data.Query)+len(data.Host)+len(data.RemoteAddr))
+	return data, size
+}
+
+func accountBuffered(size int64) {
+	bufferedBytes.Add(size)
+	unsnapshotted := unsnapshottedBytes.Add(size)
+	if maxBytes := GetBufferMaxBytes(); maxBytes > 0 && unsnapshotted > maxBytes {
+		if TriggerEarlySnapshot("buffer memory ceiling") {
+			log.Warn().Int64("unsnapshotted_bytes", unsnapshotted).Int64("max", maxBytes).
+				Msg("Buffer memory ceiling exceeded, requesting early snapshot")
+		}
+	}
+}
+
+// GetBufferedBytes returns the estimated memory held by the reprocess buffer
+// (all entries) and by entries not yet covered by a snapshot.
+func GetBufferedBytes() (total, unsnapshotted int64) {
+	return bufferedBytes.Load(), unsnapshottedBytes.Load()
+}
+
+func init() {
+	metrics.NewGaugeFunc("interceptor_buffer_bytes",
+		"Estimated memory held by the reprocess buffer",
+		func() float64 { return float64(bufferedBytes.Load()) })
+	metrics.NewGaugeFunc("interceptor_buffer_unsnapshotted_bytes",
+		"Estimated memory held by buffered requests not yet covered by a snapshot",
+		func() float64 { return float64(unsnapshottedBytes.Load()) })
+}
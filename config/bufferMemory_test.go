@@ -0,0 +1,93 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCompressBodyRoundTrip(t *testing.T) {
+	body := []byte(strings.Repeat(`{"name":"value","count":42},`, 100))
+	for _, encoding := range []string{"zstd", "snappy", "gzip", "flate"} {
+		t.Run(encoding, func(t *testing.T) {
+			t.Setenv("BUFFER_COMPRESSION", encoding)
+			data := compressBody(RequestData{Method: "PUT", Body: body})
+			if data.BodyEncoding != encoding || len(data.Body) >= len(body) {
+				t.Fatalf("encoding %q, %d -> %d bytes", data.BodyEncoding, len(body), len(data.Body))
+			}
+			if data.BodyLength() != int64(len(body)) {
+				t.Errorf("BodyLength = %d, want %d", data.BodyLength(), len(body))
+			}
+			r, err := data.BodyReader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil || !bytes.Equal(got, body) {
+				t.Errorf("round trip mismatch (err %v)", err)
+			}
+		})
+	}
+}
+
+func TestCompressBodyKeepsAsIs(t *testing.T) {
+	t.Setenv("BUFFER_COMPRESSION", "zstd")
+	t.Setenv("BUFFER_COMPRESSION_MIN_SIZE", "64")
+	cases := map[string]RequestData{
+		"small":          {Body: []byte(strings.Repeat("a", 32))},
+		"other protocol": {Protocol: "resp", Body: bytes.Repeat([]byte("a"), 4096)},
+		"spooled":        {BodyFile: "/tmp/body", Body: bytes.Repeat([]byte("a"), 4096)},
+		// Sequência sem repetição: comprimida não fica menor.
+		"incompressible": {Body: func() []byte {
+			b := make([]byte, 256)
+			for i := range b {
+				b[i] = byte(i)
+			}
+			return b
+		}()},
+	}
+	for name, data := range cases {
+		if got := compressBody(data); got.BodyEncoding != "" || !bytes.Equal(got.Body, data.Body) {
+			t.Errorf("%s: compressed to %q", name, got.BodyEncoding)
+		}
+	}
+}
+
+func TestInternHeaderSharesStableHeaders(t *testing.T) {
+	t.Setenv("CORRELATION_ID_HEADER", "X-Correlation-Id")
+	request := func(trace string) http.Header {
+		return http.Header{
+			"User-Agent":       {"sdk/1.0"},
+			"Authorization":    {"Bearer abc"},
+			"Traceparent":      {trace},
+			"X-Correlation-Id": {trace},
+		}
+	}
+	first, firstSize := internHeader(request("00-1"))
+	second, secondSize := internHeader(request("00-2"))
+
+	if first.Get("Traceparent") != "00-1" || second.Get("Traceparent") != "00-2" ||
+		second.Get("X-Correlation-Id") != "00-2" {
+		t.Fatalf("volatile headers lost: %v / %v", first, second)
+	}
+	if second.Get("Authorization") != "Bearer abc" {
+		t.Fatalf("stable headers lost: %v", second)
+	}
+	// Só os voláteis custam de novo: os estáveis já estavam internados.
+	if secondSize >= firstSize {
+		t.Errorf("second entry cost %d, first %d", secondSize, firstSize)
+	}
+
+	plain := http.Header{"User-Agent": {"sdk/1.0"}, "Authorization": {"Bearer abc"}}
+	shared, size := internHeader(plain)
+	again, _ := internHeader(http.Header{"User-Agent": {"sdk/1.0"}, "Authorization": {"Bearer abc"}})
+	if size != 0 {
+		t.Errorf("stable headers already interned cost %d bytes", size)
+	}
+	if &shared["User-Agent"][0] != &again["User-Agent"][0] {
+		t.Error("identical stable headers not shared")
+	}
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Corpo máximo lido pra extrair a chave de compactação; corpos maiores não
+// participam (a entrada é mantida).
+const maxCompactionBodyRead = 1 << 20
+
+// compactionRule extrai a chave "lógica" que uma escrita sobrescreve. ok=false
+// significa que a regra não se aplica à entrada.
+type compactionRule func(data RequestData) (key string, ok bool)
+
+func parseCompactionRule(spec string) compactionRule {
+	name, arg, _ := strings.Cut(spec, ":")
+	switch name {
+	case "path":
+		return func(data RequestData) (string, bool) {
+			return data.Path + "?" + data.Query, true
+		}
+	case "query":
+		return func(data RequestData) (string, bool) {
+			values, err := url.ParseQuery(data.Query)
+			if err != nil || !values.Has(arg) {
+				return "", false
+			}
+			return data.Path + "\x00" + values.Get(arg), true
+		}
+	case "form":
+		return func(data RequestData) (string, bool) {
+			if values, err := url.ParseQuery(data.Query); err == nil && values.Has(arg) {
+				return data.Path + "\x00" + values.Get(arg), true
+			}
+			mediaType, _, _ := mime.ParseMediaType(data.Header.Get("Content-Type"))
+			if mediaType != "application/x-www-form-urlencoded" {
+				return "", false
+			}
+			body, ok := readCompactionBody(data)
+			if !ok {
+				return "", false
+			}
+			values, err := url.ParseQuery(string(body))
+			if err != nil || !values.Has(arg) {
+				return "", false
+			}
+			return data.Path + "\x00" + values.Get(arg), true
+		}
+	case "json":
+		return func(data RequestData) (string, bool) {
+			body, ok := readCompactionBody(data)
+			if !ok {
+				return "", false
+			}
+			var doc any
+			if err := json.Unmarshal(body, &doc); err != nil {
+				return "", false
+			}
+			for _, field := range strings.Split(arg, ".") {
+				object, isObject := doc.(map[string]any)
+				if !isObject {
+					return "", false
+				}
+				if doc, ok = object[field]; !ok {
+					return "", false
+				}
+			}
+			encoded, err := json.Marshal(doc)
+			if err != nil {
+				return "", false
+			}
+			return data.Path + "\x00" + string(encoded), true
+		}
+	}
+	log.Warn().Str("rule", spec).Msg("Unknown REPLAY_COMPACTION rule ignored")
+	return nil
+}
+
+func readCompactionBody(data RequestData) ([]byte, bool) {
+	if data.BodyLength() > maxCompactionBodyRead {
+		return nil, false
+	}
+	r, err := data.BodyReader()
+	if err != nil {
+		return nil, false
+	}
+	defer r.Close()
+	body, err := io.ReadAll(io.LimitReader(r, maxCompactionBodyRead))
+	return body, err == nil
+}
+
+// CompactReprocessable drops buffered HTTP writes that a later write to the
+// same key (per REPLAY_COMPACTION) overwrites anyway, so recovery does not
+// replay every intermediate PUT. Any other write to the same key in between
+// is a barrier. Order of the kept entries is preserved.
+// Returns the entries to replay and the superseded ones.
+func CompactReprocessable(requests []*BufferedRequest) (kept, superseded []*BufferedRequest) {
+	specs := GetReplayCompactionRules()
+	if len(specs) == 0 {
+		return requests, nil
+	}
+	var rules []compactionRule
+	var names []string
+	for _, spec := range specs {
+		if rule := parseCompactionRule(spec); rule != nil {
+			rules = append(rules, rule)
+			names = append(names, spec)
+		}
+	}
+	methods := GetReplayCompactionMethods()
+
+	// De trás pra frente: a última escrita de cada chave é a que vale. Uma
+	// escrita que não é compactada (POST/PATCH, ou sem chave) entre duas do
+	// mesmo path é barreira: a anterior pode ser a base dela, então não é
+	// descartada. Com chave, a barreira vale só pra chave; sem, pro path todo.
+	seen := map[string]map[string]bool{}
+	drop := make([]bool, len(requests))
+	for i := len(requests) - 1; i >= 0; i-- {
+		data := requests[i].Data
+		if data.Protocol != "" {
+			continue
+		}
+		key, matched := "", false
+		for ruleIndex, rule := range rules {
+			if k, ok := rule(data); ok {
+				key, matched = names[ruleIndex]+"\x00"+k, true
+				break
+			}
+		}
+		keys := seen[data.Path]
+		switch {
+		case !matched:
+			delete(seen, data.Path)
+		case !methods[data.Method]:
+			delete(keys, key)
+		default:
+			if keys == nil {
+				keys = map[string]bool{}
+				seen[data.Path] = keys
+			}
+			if keys[key] {
+				drop[i] = true
+			}
+			keys[key] = true
+		}
+	}
+
+	for i, request := range requests {
+		if drop[i] {
+			superseded = append(superseded, request)
+		} else {
+			kept = append(kept, request)
+		}
+	}
+	return kept, superseded
+}
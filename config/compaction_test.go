@@ -0,0 +1,122 @@
+package config
+
+import (
+	"net/http"
+	"testing"
+)
+
+func write(method, path, query, contentType, body string) *BufferedRequest {
+	data := RequestData{Method: method, Path: path, Query: query, Body: []byte(body), Header: http.Header{}}
+	if contentType != "" {
+		data.Header.Set("Content-Type", contentType)
+	}
+	return &BufferedRequest{Data: data}
+}
+
+// compact devolve os índices mantidos.
+func compact(t *testing.T, requests []*BufferedRequest) []int {
+	t.Helper()
+	kept, superseded := CompactReprocessable(requests)
+	if len(kept)+len(superseded) != len(requests) {
+		t.Fatalf("kept %d + superseded %d != %d", len(kept), len(superseded), len(requests))
+	}
+	var indexes []int
+	for _, request := range kept {
+		for i := range requests {
+			if requests[i] == request {
+				indexes = append(indexes, i)
+			}
+		}
+	}
+	return indexes
+}
+
+func assertKept(t *testing.T, got []int, want ...int) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("kept %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("kept %v, want %v", got, want)
+		}
+	}
+}
+
+func TestCompactionDisabled(t *testing.T) {
+	t.Setenv("REPLAY_COMPACTION", "")
+	requests := []*BufferedRequest{write("PUT", "/a", "", "", ""), write("PUT", "/a", "", "", "")}
+	assertKept(t, compact(t, requests), 0, 1)
+}
+
+func TestCompactionPathRule(t *testing.T) {
+	t.Setenv("REPLAY_COMPACTION", "path")
+	requests := []*BufferedRequest{
+		write("PUT", "/a", "", "", "1"),
+		write("PUT", "/b", "", "", "1"),
+		write("DELETE", "/a", "", "", ""),
+		write("PUT", "/a", "", "", "2"),
+		write("PUT", "/a", "v=2", "", "3"),
+	}
+	// A última escrita de cada chave vale; a query faz parte da chave.
+	assertKept(t, compact(t, requests), 1, 3, 4)
+}
+
+func TestCompactionBarrier(t *testing.T) {
+	t.Setenv("REPLAY_COMPACTION", "path")
+	requests := []*BufferedRequest{
+		write("PUT", "/a", "", "", "1"),
+		write("PATCH", "/a", "", "", "2"),
+		write("PUT", "/a", "", "", "3"),
+		write("PUT", "/b", "", "", "1"),
+		write("POST", "/c", "", "", ""),
+		write("PUT", "/b", "", "", "2"),
+	}
+	// O PATCH pode depender do PUT anterior; o POST em outro path não
+	// protege /b.
+	assertKept(t, compact(t, requests), 0, 1, 2, 4, 5)
+}
+
+func TestCompactionQueryRule(t *testing.T) {
+	t.Setenv("REPLAY_COMPACTION", "query:id")
+	requests := []*BufferedRequest{
+		write("PUT", "/items", "id=1", "", "a"),
+		write("PUT", "/items", "id=2", "", "a"),
+		write("PUT", "/items", "id=1", "", "b"),
+		write("PUT", "/items", "id=2&x=1", "", "b"),
+	}
+	assertKept(t, compact(t, requests), 2, 3)
+
+	// Escrita sem chave no mesmo path é barreira pro path inteiro.
+	requests = []*BufferedRequest{
+		write("PUT", "/items", "id=1", "", "a"),
+		write("PUT", "/items", "", "", "all"),
+		write("PUT", "/items", "id=1", "", "b"),
+	}
+	assertKept(t, compact(t, requests), 0, 1, 2)
+}
+
+func TestCompactionBodyRules(t *testing.T) {
+	t.Setenv("REPLAY_COMPACTION", "json:user.id,form:id")
+	requests := []*BufferedRequest{
+		write("PUT", "/users", "", "application/json", `{"user":{"id":7,"name":"a"}}`),
+		write("PUT", "/users", "", "application/json", `{"user":{"id":8}}`),
+		write("PUT", "/users", "", "application/json", `{"user":{"id":7,"name":"b"}}`),
+		write("PUT", "/forms", "", "application/x-www-form-urlencoded", "id=3&v=1"),
+		write("PUT", "/forms", "", "application/x-www-form-urlencoded; charset=utf-8", "v=2&id=3"),
+		write("PUT", "/forms", "id=4", "", ""),
+		write("PUT", "/forms", "", "text/plain", "id=4"),
+	}
+	// O último /forms não casa regra nenhuma (não é form): barreira, e o
+	// id=4 da query antes dele fica.
+	assertKept(t, compact(t, requests), 1, 2, 4, 5, 6)
+}
+
+func TestCompactionSkipsOtherProtocols(t *testing.T) {
+	t.Setenv("REPLAY_COMPACTION", "path")
+	first := write("SET", "", "", "", "*3\r\n")
+	first.Data.Protocol = "resp"
+	second := write("SET", "", "", "", "*3\r\n")
+	second.Data.Protocol = "resp"
+	assertKept(t, compact(t, []*BufferedRequest{first, second}), 0, 1)
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"net"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -14,6 +17,18 @@ var IsSnapshotBeingTaken = false
 // - INTERCEPTOR_PORT: Port to listen to
 // - HEARTBEAT_ENABLED: Enable or disable the heartbeat
 // - CHECKPOINT_ENABLED: Enable or disable the checkpoint
+// - RESP_ENABLED: Enable the Redis (RESP) listener; requires RESP_PORT and RESP_UPSTREAM_ADDR
+// - TCP_PROXY_ENABLED: Enable the raw TCP listener; requires TCP_PROXY_PORT and TCP_PROXY_UPSTREAM_ADDR
+// - GRPC_TLS_CERT_FILE/GRPC_TLS_KEY_FILE: Serve the gRPC control plane over TLS (set together)
+// - DAEMON_TLS_CERT_FILE/DAEMON_TLS_KEY_FILE: Client certificate for mTLS with the daemon (set together)
+// - LISTENER_TLS_CERT_FILE/LISTENER_TLS_KEY_FILE: Terminate TLS on the client-facing listener (set together)
+// - UPSTREAM_TLS_CERT_FILE/UPSTREAM_TLS_KEY_FILE: Client certificate for mTLS with the application (set together)
+// - ADMIN_PORT: Serve the /_internal/ endpoints on a separate listener
+// - ADMIN_ALLOWED_CIDRS: Source networks allowed on the /_internal/ endpoints
+// - ASYNC_STATUS_PREFIX: Path prefix of the 202 status URLs (never forwarded)
+// - QUEUE_PRIORITY_MODE/QUEUE_CLASS_WEIGHTS/QUEUE_CLASS_CONCURRENCY: Recovery queue scheduling per class
+// - QUEUE_FAIRNESS_KEY: Client identity for fair draining of live requests (ip, subject, header:<Name>)
+// - RAMP_WINDOW/RAMP_INITIAL_PERCENT/RAMP_EXCESS: Slow-start after the gate reopens
 func VerifyEnvVars() {
 	applicationUrl, ok := os.LookupEnv("APPLICATION_URL")
 	if !ok {
@@ -116,6 +131,92 @@ func VerifyEnvVars() {
 	if err != nil {
 		panic("CHECKPOINT_INTERVAL must be a number")
 	}
+
+	if GetRespEnabled() {
+		respPort, ok := os.LookupEnv("RESP_PORT")
+		if !ok || respPort == "" {
+			panic("RESP_PORT is required when RESP_ENABLED is true")
+		}
+		if _, err := strconv.Atoi(respPort); err != nil {
+			panic("RESP_PORT must be a number")
+		}
+		if GetRespUpstreamAddr() == "" {
+			panic("RESP_UPSTREAM_ADDR is required when RESP_ENABLED is true")
+		}
+	}
+
+	if GetTcpProxyEnabled() {
+		tcpProxyPort, ok := os.LookupEnv("TCP_PROXY_PORT")
+		if !ok || tcpProxyPort == "" {
+			panic("TCP_PROXY_PORT is required when TCP_PROXY_ENABLED is true")
+		}
+		if _, err := strconv.Atoi(tcpProxyPort); err != nil {
+			panic("TCP_PROXY_PORT must be a number")
+		}
+		if GetTcpProxyUpstreamAddr() == "" {
+			panic("TCP_PROXY_UPSTREAM_ADDR is required when TCP_PROXY_ENABLED is true")
+		}
+	}
+
+	if (GetGrpcTLSCertFile() == "") != (GetGrpcTLSKeyFile() == "") {
+		panic("GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE must be set together")
+	}
+	if GetGrpcTLSClientCAFile() != "" && GetGrpcTLSCertFile() == "" {
+		panic("GRPC_TLS_CLIENT_CA_FILE requires GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE")
+	}
+	if len(GetGrpcAllowedSpiffeIDs()) > 0 && GetGrpcTLSClientCAFile() == "" {
+		panic("GRPC_ALLOWED_SPIFFE_IDS requires mTLS (GRPC_TLS_CLIENT_CA_FILE)")
+	}
+	if (GetDaemonTLSCertFile() == "") != (GetDaemonTLSKeyFile() == "") {
+		panic("DAEMON_TLS_CERT_FILE and DAEMON_TLS_KEY_FILE must be set together")
+	}
+
+	if (GetListenerTLSCertFile() == "") != (GetListenerTLSKeyFile() == "") {
+		panic("LISTENER_TLS_CERT_FILE and LISTENER_TLS_KEY_FILE must be set together")
+	}
+	if GetListenerTLSClientCAFile() != "" && GetListenerTLSCertFile() == "" {
+		panic("LISTENER_TLS_CLIENT_CA_FILE requires LISTENER_TLS_CERT_FILE and LISTENER_TLS_KEY_FILE")
+	}
+	switch GetListenerTLSClientAuth() {
+	case "request", "verify-if-given", "require":
+	default:
+		panic("LISTENER_TLS_CLIENT_AUTH must be request, verify-if-given or require")
+	}
+
+	if (GetUpstreamTLSCertFile() == "") != (GetUpstreamTLSKeyFile() == "") {
+		panic("UPSTREAM_TLS_CERT_FILE and UPSTREAM_TLS_KEY_FILE must be set together")
+	}
+
+	if adminPort := os.Getenv("ADMIN_PORT"); adminPort != "" {
+		if _, err := strconv.Atoi(strings.TrimPrefix(adminPort, ":")); err != nil {
+			panic("ADMIN_PORT must be a number")
+		}
+	}
+	for _, cidr := range GetAdminAllowedCIDRs() {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			panic("ADMIN_ALLOWED_CIDRS has an invalid CIDR: " + cidr)
+		}
+	}
+	if prefix := GetAsyncStatusPrefix(); !strings.HasPrefix(prefix, "/") || prefix == "/" ||
+		strings.HasPrefix(prefix, "/_internal/") {
+		panic("ASYNC_STATUS_PREFIX must be an absolute path other than / and outside /_internal/")
+	}
+	if mode := GetQueuePriorityMode(); mode != "strict" && mode != "weighted" {
+		panic("QUEUE_PRIORITY_MODE must be strict or weighted")
+	}
+	verifyClassInts("QUEUE_CLASS_WEIGHTS")
+	verifyClassInts("QUEUE_CLASS_CONCURRENCY")
+	if key := GetQueueFairnessKey(); key != "" && key != "ip" && key != "subject" &&
+		(!strings.HasPrefix(key, "header:") || strings.TrimPrefix(key, "header:") == "") {
+		panic("QUEUE_FAIRNESS_KEY must be ip, subject or header:<Name>")
+	}
+	if minLimit, maxLimit, initial := GetAdaptiveConcurrencyMin(), GetAdaptiveConcurrencyMax(),
+		GetAdaptiveConcurrencyInitial(); minLimit > initial || initial > maxLimit {
+		panic("ADAPTIVE_CONCURRENCY_MIN <= ADAPTIVE_CONCURRENCY_INITIAL <= ADAPTIVE_CONCURRENCY_MAX must hold")
+	}
+	if excess := GetRampExcess(); excess != "queue" && excess != "shed" {
+		panic("RAMP_EXCESS must be queue or shed")
+	}
 }
 
 func GetApplicationURL() string {
@@ -174,6 +275,48 @@ func GetDaemonGrpcUrl() string {
 	return os.Getenv("DAEMON_GRPC_URL")
 }
 
+// GetDaemonGrpcEndpoints separa DAEMON_GRPC_URL por vírgula: com mais de um
+// endereço o cliente faz failover entre eles, na ordem. Um único alvo pode
+// usar esquema do gRPC (ex.: dns:///daemon.ns:50051).
+func GetDaemonGrpcEndpoints() []string {
+	var endpoints []string
+	for _, endpoint := range strings.Split(GetDaemonGrpcUrl(), ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+// GetDaemonRpcTimeout retorna o deadline (segundos) de cada RPC ao daemon sem
+// deadline próprio. Env DAEMON_RPC_TIMEOUT; default 10.
+func GetDaemonRpcTimeout() int {
+	if n := getNonNegativeInt("DAEMON_RPC_TIMEOUT"); n > 0 {
+		return n
+	}
+	return 10
+}
+
+// GetDaemonKeepaliveTime retorna o intervalo (segundos) de ping de keepalive
+// da conexão com o daemon. Env DAEMON_KEEPALIVE_TIME; default 300 — abaixo
+// disso o servidor gRPC precisa permitir (EnforcementPolicy.MinTime), senão
+// derruba a conexão com too_many_pings.
+func GetDaemonKeepaliveTime() int {
+	if n := getNonNegativeInt("DAEMON_KEEPALIVE_TIME"); n > 0 {
+		return n
+	}
+	return 300
+}
+
+// GetDaemonKeepaliveTimeout retorna quanto (segundos) esperar o ack de um ping
+// antes de dar a conexão como morta. Env DAEMON_KEEPALIVE_TIMEOUT; default 20.
+func GetDaemonKeepaliveTimeout() int {
+	if n := getNonNegativeInt("DAEMON_KEEPALIVE_TIMEOUT"); n > 0 {
+		return n
+	}
+	return 20
+}
+
 func GetSelfGrpcUrl() string {
 	return os.Getenv("GRPC_URL")
 }
@@ -204,3 +347,1000 @@ func GetSnapshotDrainTimeout() int {
 	}
 	return n
 }
+
+func GetRespEnabled() bool {
+	respEnabled, err := strconv.ParseBool(os.Getenv("RESP_ENABLED"))
+	if err != nil {
+		return false
+	}
+	return respEnabled
+}
+
+func GetRespPort() string {
+	respPort := os.Getenv("RESP_PORT")
+	if respPort[0] != ':' {
+		respPort = ":" + respPort
+	}
+	return respPort
+}
+
+// GetRespUpstreamAddr retorna host:port do servidor Redis-compatível que o
+// listener RESP encaminha.
+func GetRespUpstreamAddr() string {
+	return os.Getenv("RESP_UPSTREAM_ADDR")
+}
+
+// GetRespReplayTimeout: prazo (segundos) de cada entrada do replay RESP, do
+// envio à última resposta. Env RESP_REPLAY_TIMEOUT; default 10.
+func GetRespReplayTimeout() int {
+	if n := getNonNegativeInt("RESP_REPLAY_TIMEOUT"); n > 0 {
+		return n
+	}
+	return 10
+}
+
+func GetTcpProxyEnabled() bool {
+	tcpProxyEnabled, err := strconv.ParseBool(os.Getenv("TCP_PROXY_ENABLED"))
+	if err != nil {
+		return false
+	}
+	return tcpProxyEnabled
+}
+
+func GetTcpProxyPort() string {
+	tcpProxyPort := os.Getenv("TCP_PROXY_PORT")
+	if tcpProxyPort[0] != ':' {
+		tcpProxyPort = ":" + tcpProxyPort
+	}
+	return tcpProxyPort
+}
+
+func GetTcpProxyUpstreamAddr() string {
+	return os.Getenv("TCP_PROXY_UPSTREAM_ADDR")
+}
+
+// GetTcpProxyCodec retorna a especificação do codec de framing do listener
+// TCP (ex.: "newline", "length:4"). Env TCP_PROXY_CODEC; default "newline".
+func GetTcpProxyCodec() string {
+	v := os.Getenv("TCP_PROXY_CODEC")
+	if v == "" {
+		return "newline"
+	}
+	return v
+}
+
+// GetTcpProxyMaxFrame retorna o tamanho máximo (bytes) de um frame do
+// listener TCP. Env TCP_PROXY_MAX_FRAME; default 16MiB se ausente/invalido.
+func GetTcpProxyMaxFrame() int {
+	v := os.Getenv("TCP_PROXY_MAX_FRAME")
+	if v == "" {
+		return 16 << 20
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 16 << 20
+	}
+	return n
+}
+
+// GetLongLivedConnPolicy retorna o que fazer com conexões de longa duração
+// (upgrade/WebSocket, streams) antes de um checkpoint: "close" fecha na hora,
+// "wait" espera até GetLongLivedConnWaitTimeout e fecha as restantes. Env
+// LONG_LIVED_CONN_POLICY; default "close" (o CRIU exige zero conexões TCP).
+func GetLongLivedConnPolicy() string {
+	v := os.Getenv("LONG_LIVED_CONN_POLICY")
+	if v != "wait" {
+		return "close"
+	}
+	return v
+}
+
+// GetLongLivedConnWaitTimeout retorna quanto (segundos) a política "wait"
+// espera as conexões de longa duração terminarem sozinhas. Env
+// LONG_LIVED_CONN_WAIT_TIMEOUT; default 10 se ausente/invalido.
+func GetLongLivedConnWaitTimeout() int {
+	v := os.Getenv("LONG_LIVED_CONN_WAIT_TIMEOUT")
+	if v == "" {
+		return 10
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 10
+	}
+	return n
+}
+
+func GetWebSocketRecordEnabled() bool {
+	webSocketRecord, err := strconv.ParseBool(os.Getenv("WEBSOCKET_RECORD"))
+	if err != nil {
+		return false
+	}
+	return webSocketRecord
+}
+
+// GetWebSocketRecordMaxMessage retorna o maior payload (bytes) de mensagem
+// WebSocket que entra no buffer de replay; maiores passam sem registro. Env
+// WEBSOCKET_RECORD_MAX_MESSAGE; default 1MiB se ausente/invalido.
+func GetWebSocketRecordMaxMessage() int {
+	v := os.Getenv("WEBSOCKET_RECORD_MAX_MESSAGE")
+	if v == "" {
+		return 1 << 20
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 1 << 20
+	}
+	return n
+}
+
+// GetStreamContentTypes retorna os media types de resposta repassados em
+// streaming (flush a cada leitura) em vez de bufferizados. Env
+// STREAM_CONTENT_TYPES, separado por vírgula; default SSE e NDJSON.
+func GetStreamContentTypes() []string {
+	v := os.Getenv("STREAM_CONTENT_TYPES")
+	if v == "" {
+		return []string{"text/event-stream", "application/x-ndjson"}
+	}
+	var contentTypes []string
+	for _, contentType := range strings.Split(v, ",") {
+		if contentType = strings.TrimSpace(contentType); contentType != "" {
+			contentTypes = append(contentTypes, strings.ToLower(contentType))
+		}
+	}
+	return contentTypes
+}
+
+// GetStreamChunkedResponses diz se respostas sem Content-Length (chunked)
+// também são repassadas em streaming. Env STREAM_CHUNKED_RESPONSES; default
+// false: a maioria das respostas chunked é um corpo comum, e em streaming
+// elas sairiam do buffer de resposta e da drenagem do snapshot. Os streams de
+// verdade são detectados por STREAM_CONTENT_TYPES.
+func GetStreamChunkedResponses() bool {
+	streamChunked, err := strconv.ParseBool(os.Getenv("STREAM_CHUNKED_RESPONSES"))
+	if err != nil {
+		return false
+	}
+	return streamChunked
+}
+
+// GetBodyMaxBytes retorna o maior corpo de request aceito; acima disso o
+// cliente recebe 413. Env BODY_MAX_BYTES; 0 (default) = sem limite.
+func GetBodyMaxBytes() int64 {
+	n, err := strconv.ParseInt(os.Getenv("BODY_MAX_BYTES"), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// GetBodyMemoryThreshold retorna o tamanho (bytes) acima do qual o corpo do
+// request é despejado em arquivo temporário em vez de ficar em memória. Env
+// BODY_MEMORY_THRESHOLD; 0 (default) = tudo em memória.
+func GetBodyMemoryThreshold() int64 {
+	n, err := strconv.ParseInt(os.Getenv("BODY_MEMORY_THRESHOLD"), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// GetBodySpillDir retorna o diretório dos corpos despejados em disco. Env
+// BODY_SPILL_DIR; default o diretório temporário do sistema. Os arquivos
+// interceptor-body-* dele são apagados no primeiro despejo: não compartilhe o
+// diretório entre interceptors.
+func GetBodySpillDir() string {
+	if v := os.Getenv("BODY_SPILL_DIR"); v != "" {
+		return v
+	}
+	return os.TempDir()
+}
+
+// GetBodySpillDiskBudget retorna o total (bytes) que os corpos despejados
+// podem ocupar em disco. Env BODY_SPILL_DISK_BUDGET; default 1GiB se
+// ausente/invalido.
+func GetBodySpillDiskBudget() int64 {
+	n, err := strconv.ParseInt(os.Getenv("BODY_SPILL_DISK_BUDGET"), 10, 64)
+	if err != nil || n <= 0 {
+		return 1 << 30
+	}
+	return n
+}
+
+// GetBufferCompression retorna o codec aplicado aos corpos guardados no
+// buffer de reprocess: "zstd", "snappy", "gzip", "flate" ou "" (default, sem
+// compressão). Env BUFFER_COMPRESSION; valores desconhecidos desligam a
+// compressão.
+func GetBufferCompression() string {
+	switch v := os.Getenv("BUFFER_COMPRESSION"); v {
+	case "zstd", "snappy", "gzip", "flate":
+		return v
+	}
+	return ""
+}
+
+// GetBufferCompressionMinSize retorna o menor corpo (bytes) que vale a pena
+// comprimir. Env BUFFER_COMPRESSION_MIN_SIZE; default 1024 se ausente/invalido.
+func GetBufferCompressionMinSize() int {
+	v := os.Getenv("BUFFER_COMPRESSION_MIN_SIZE")
+	if v == "" {
+		return 1024
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 1024
+	}
+	return n
+}
+
+// GetBufferMaxBytes retorna o teto de memória (bytes) das entradas ainda não
+// cobertas por snapshot; ao ultrapassá-lo um snapshot antecipado é pedido.
+// Env BUFFER_MAX_BYTES; 0 (default) = sem teto.
+func GetBufferMaxBytes() int64 {
+	n, err := strconv.ParseInt(os.Getenv("BUFFER_MAX_BYTES"), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// GetReplayCompactionRules lê REPLAY_COMPACTION: regras separadas por
+// vírgula, avaliadas em ordem (a primeira que produz chave vale):
+//   - path:          mesmo path+query
+//   - query:<param>: mesmo path e mesmo valor do parâmetro de query
+//   - form:<campo>:  mesmo path e mesmo campo do corpo form-urlencoded (ou query)
+//   - json:<campo>:  mesmo path e mesmo campo (a.b.c) do corpo JSON
+//
+// Vazio (default) desliga a compactação.
+func GetReplayCompactionRules() []string {
+	var rules []string
+	for _, rule := range strings.Split(os.Getenv("REPLAY_COMPACTION"), ",") {
+		if rule = strings.TrimSpace(rule); rule != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// GetReplayCompactionMethods retorna os métodos cujas requests sobrescrevem
+// (e podem ser sobrescritas). Env REPLAY_COMPACTION_METHODS; default PUT,DELETE.
+func GetReplayCompactionMethods() map[string]bool {
+	v := os.Getenv("REPLAY_COMPACTION_METHODS")
+	if v == "" {
+		v = "PUT,DELETE"
+	}
+	methods := map[string]bool{}
+	for _, method := range strings.Split(v, ",") {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			methods[method] = true
+		}
+	}
+	return methods
+}
+
+func getNonNegativeInt(name string) int {
+	n, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// GetSnapshotSkipIdle diz se o snapshot é pulado quando nenhum request foi
+// bufferizado desde o último. Env SNAPSHOT_SKIP_IDLE; default false.
+func GetSnapshotSkipIdle() bool {
+	skipIdle, err := strconv.ParseBool(os.Getenv("SNAPSHOT_SKIP_IDLE"))
+	if err != nil {
+		return false
+	}
+	return skipIdle
+}
+
+// GetSnapshotMinInterval retorna o intervalo mínimo (segundos) entre dois
+// snapshots, qualquer que seja o gatilho. Env SNAPSHOT_MIN_INTERVAL; default 0.
+func GetSnapshotMinInterval() int {
+	return getNonNegativeInt("SNAPSHOT_MIN_INTERVAL")
+}
+
+// GetSnapshotMaxInterval retorna o intervalo máximo (segundos) sem snapshot
+// havendo escritas: vence quiet hours. Env SNAPSHOT_MAX_INTERVAL; default 0
+// (desligado).
+func GetSnapshotMaxInterval() int {
+	return getNonNegativeInt("SNAPSHOT_MAX_INTERVAL")
+}
+
+// GetSnapshotMaxBufferedRequests retorna quantas entradas não cobertas por
+// snapshot disparam um snapshot. Env SNAPSHOT_MAX_BUFFERED_REQUESTS; default 0
+// (desligado).
+func GetSnapshotMaxBufferedRequests() int {
+	return getNonNegativeInt("SNAPSHOT_MAX_BUFFERED_REQUESTS")
+}
+
+// GetSnapshotWriteRateThreshold retorna a taxa de escrita (requests
+// bufferizados/s) na janela que dispara um snapshot. Env
+// SNAPSHOT_WRITE_RATE_THRESHOLD; default 0 (desligado).
+func GetSnapshotWriteRateThreshold() float64 {
+	n, err := strconv.ParseFloat(os.Getenv("SNAPSHOT_WRITE_RATE_THRESHOLD"), 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// GetSnapshotWriteRateWindow retorna a janela (segundos) da taxa de escrita.
+// Env SNAPSHOT_WRITE_RATE_WINDOW; default 60.
+func GetSnapshotWriteRateWindow() int {
+	if n := getNonNegativeInt("SNAPSHOT_WRITE_RATE_WINDOW"); n > 0 {
+		return n
+	}
+	return 60
+}
+
+// GetSnapshotCron retorna uma expressão cron de 5 campos (minuto hora dia mês
+// dia-da-semana) que dispara snapshots. Env SNAPSHOT_CRON; default vazio. Soma
+// ao CHECKPOINT_INTERVAL, que continua valendo: pra só o cron, use um
+// intervalo maior que o período do cron.
+func GetSnapshotCron() string {
+	return os.Getenv("SNAPSHOT_CRON")
+}
+
+// GetSnapshotQuietHours retorna janelas "HH:MM-HH:MM" (separadas por vírgula,
+// hora local) em que snapshots não começam, salvo SNAPSHOT_MAX_INTERVAL ou
+// pedido antecipado. Env SNAPSHOT_QUIET_HOURS; default vazio.
+func GetSnapshotQuietHours() string {
+	return os.Getenv("SNAPSHOT_QUIET_HOURS")
+}
+
+// GetSnapshotRetryBase retorna o primeiro atraso (segundos) do backoff
+// exponencial após uma falha de snapshot. Env SNAPSHOT_RETRY_BASE; default 5.
+func GetSnapshotRetryBase() int {
+	if n := getNonNegativeInt("SNAPSHOT_RETRY_BASE"); n > 0 {
+		return n
+	}
+	return 5
+}
+
+// GetSnapshotRetryMax retorna o teto (segundos) do backoff de retry de
+// snapshot. Env SNAPSHOT_RETRY_MAX; default 300.
+func GetSnapshotRetryMax() int {
+	if n := getNonNegativeInt("SNAPSHOT_RETRY_MAX"); n > 0 {
+		return n
+	}
+	return 300
+}
+
+// GetMaxUnsnapshottedAge retorna a idade máxima (segundos) da escrita mais
+// antiga ainda não coberta por snapshot antes de alertar. Env
+// MAX_UNSNAPSHOTTED_AGE; default 0 (desligado).
+func GetMaxUnsnapshottedAge() int {
+	return getNonNegativeInt("MAX_UNSNAPSHOTTED_AGE")
+}
+
+// GetAlertWebhookURL retorna a URL que recebe POSTs JSON de alerta de
+// durabilidade. Env ALERT_WEBHOOK_URL; default vazio (só log/métrica).
+func GetAlertWebhookURL() string {
+	return os.Getenv("ALERT_WEBHOOK_URL")
+}
+
+// GetAlertRepeatInterval retorna de quanto em quanto tempo (segundos) um
+// alerta ativo é reemitido. Env ALERT_REPEAT_INTERVAL; default 300.
+func GetAlertRepeatInterval() int {
+	if n := getNonNegativeInt("ALERT_REPEAT_INTERVAL"); n > 0 {
+		return n
+	}
+	return 300
+}
+
+// GetDurabilityHardMode diz se escritas são recusadas enquanto a
+// durabilidade está degradada (MAX_UNSNAPSHOTTED_AGE estourado): 503 no HTTP,
+// erro no RESP, conexão fechada no TCP e no WebSocket gravado. Env
+// DURABILITY_HARD_MODE; default false.
+func GetDurabilityHardMode() bool {
+	hardMode, err := strconv.ParseBool(os.Getenv("DURABILITY_HARD_MODE"))
+	if err != nil {
+		return false
+	}
+	return hardMode
+}
+
+// GetGrpcTLSCertFile/GetGrpcTLSKeyFile: par servido pelo servidor gRPC do
+// interceptor. Env GRPC_TLS_CERT_FILE/GRPC_TLS_KEY_FILE; vazios = plaintext.
+func GetGrpcTLSCertFile() string {
+	return os.Getenv("GRPC_TLS_CERT_FILE")
+}
+
+func GetGrpcTLSKeyFile() string {
+	return os.Getenv("GRPC_TLS_KEY_FILE")
+}
+
+// GetGrpcTLSClientCAFile: CA que assina os certificados de cliente aceitos
+// (mTLS obrigatório quando definido). Env GRPC_TLS_CLIENT_CA_FILE.
+func GetGrpcTLSClientCAFile() string {
+	return os.Getenv("GRPC_TLS_CLIENT_CA_FILE")
+}
+
+// GetGrpcAuthToken: bearer token exigido em cada RPC recebido. Env
+// GRPC_AUTH_TOKEN; vazio = sem token.
+func GetGrpcAuthToken() string {
+	return os.Getenv("GRPC_AUTH_TOKEN")
+}
+
+// GetGrpcAllowedSpiffeIDs: SPIFFE IDs (URI SAN do certificado de cliente)
+// autorizados a chamar o servidor gRPC. Env GRPC_ALLOWED_SPIFFE_IDS, separado
+// por vírgula; vazio = sem checagem.
+func GetGrpcAllowedSpiffeIDs() []string {
+	var ids []string
+	for _, id := range strings.Split(os.Getenv("GRPC_ALLOWED_SPIFFE_IDS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// GetDaemonTLSEnabled diz se a conexão com o daemon usa TLS. Env
+// DAEMON_TLS_ENABLED; default false.
+func GetDaemonTLSEnabled() bool {
+	enabled, err := strconv.ParseBool(os.Getenv("DAEMON_TLS_ENABLED"))
+	if err != nil {
+		return false
+	}
+	return enabled
+}
+
+// GetDaemonTLSCertFile/GetDaemonTLSKeyFile: certificado de cliente pro mTLS
+// com o daemon. Env DAEMON_TLS_CERT_FILE/DAEMON_TLS_KEY_FILE.
+func GetDaemonTLSCertFile() string {
+	return os.Getenv("DAEMON_TLS_CERT_FILE")
+}
+
+func GetDaemonTLSKeyFile() string {
+	return os.Getenv("DAEMON_TLS_KEY_FILE")
+}
+
+// GetDaemonTLSCAFile: CA que assina o certificado do daemon. Env
+// DAEMON_TLS_CA_FILE; vazio = roots do sistema.
+func GetDaemonTLSCAFile() string {
+	return os.Getenv("DAEMON_TLS_CA_FILE")
+}
+
+// GetDaemonTLSServerName: nome verificado no certificado do daemon. Env
+// DAEMON_TLS_SERVER_NAME; vazio = host do endpoint. Obrigatório com
+// DAEMON_TLS_CA_FILE se o endpoint é um IP.
+func GetDaemonTLSServerName() string {
+	return os.Getenv("DAEMON_TLS_SERVER_NAME")
+}
+
+// GetDaemonAuthToken: bearer token enviado ao daemon em cada RPC. Env
+// DAEMON_AUTH_TOKEN.
+func GetDaemonAuthToken() string {
+	return os.Getenv("DAEMON_AUTH_TOKEN")
+}
+
+// GetAdminPort retorna a porta do listener separado dos endpoints
+// /_internal/ (":9090"), ou "" quando eles ficam no listener principal. Env
+// ADMIN_PORT.
+func GetAdminPort() string {
+	adminPort := os.Getenv("ADMIN_PORT")
+	if adminPort != "" && adminPort[0] != ':' {
+		adminPort = ":" + adminPort
+	}
+	return adminPort
+}
+
+// GetAdminToken: segredo compartilhado aceito em "Authorization: Bearer" nos
+// endpoints /_internal/. Env ADMIN_TOKEN.
+func GetAdminToken() string {
+	return os.Getenv("ADMIN_TOKEN")
+}
+
+// GetAdminHmacSecret: chave das assinaturas X-Admin-Signature. Env
+// ADMIN_HMAC_SECRET.
+func GetAdminHmacSecret() string {
+	return os.Getenv("ADMIN_HMAC_SECRET")
+}
+
+// GetAdminAllowedCIDRs: redes de origem aceitas nos endpoints /_internal/.
+// Env ADMIN_ALLOWED_CIDRS, separado por vírgula; vazio = qualquer origem.
+func GetAdminAllowedCIDRs() []string {
+	var cidrs []string
+	for _, cidr := range strings.Split(os.Getenv("ADMIN_ALLOWED_CIDRS"), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	return cidrs
+}
+
+// GetListenerTLSCertFile/GetListenerTLSKeyFile: par servido no listener dos
+// clientes (INTERCEPTOR_PORT). Env LISTENER_TLS_CERT_FILE/LISTENER_TLS_KEY_FILE;
+// vazios = HTTP puro.
+func GetListenerTLSCertFile() string {
+	return os.Getenv("LISTENER_TLS_CERT_FILE")
+}
+
+func GetListenerTLSKeyFile() string {
+	return os.Getenv("LISTENER_TLS_KEY_FILE")
+}
+
+// GetListenerTLSClientCAFile: CA dos certificados de cliente aceitos no
+// listener. Env LISTENER_TLS_CLIENT_CA_FILE; vazio = sem certificado de cliente.
+func GetListenerTLSClientCAFile() string {
+	return os.Getenv("LISTENER_TLS_CLIENT_CA_FILE")
+}
+
+// GetListenerTLSClientAuth: "request" (pede, não verifica), "verify-if-given"
+// ou "require". Env LISTENER_TLS_CLIENT_AUTH; default "require".
+func GetListenerTLSClientAuth() string {
+	if clientAuth := os.Getenv("LISTENER_TLS_CLIENT_AUTH"); clientAuth != "" {
+		return clientAuth
+	}
+	return "require"
+}
+
+// GetClientCertHeaders: campos do certificado de cliente repassados à
+// aplicação, como "campo=Header" (campos: subject, issuer, serial,
+// fingerprint, spiffe, dns, cert, verified). Env CLIENT_CERT_HEADERS,
+// separado por vírgula; "-" desliga. Default subject, fingerprint e spiffe.
+func GetClientCertHeaders() []string {
+	spec, ok := os.LookupEnv("CLIENT_CERT_HEADERS")
+	if !ok || spec == "" {
+		spec = "subject=X-Client-Cert-Subject,fingerprint=X-Client-Cert-Fingerprint,spiffe=X-Client-Cert-Spiffe-Id"
+	}
+	if spec == "-" {
+		return nil
+	}
+	var headers []string
+	for _, mapping := range strings.Split(spec, ",") {
+		if mapping = strings.TrimSpace(mapping); mapping != "" {
+			headers = append(headers, mapping)
+		}
+	}
+	return headers
+}
+
+// GetUpstreamTLSCAFile: CA que assina o certificado da aplicação (https).
+// Env UPSTREAM_TLS_CA_FILE; vazio = roots do sistema.
+func GetUpstreamTLSCAFile() string {
+	return os.Getenv("UPSTREAM_TLS_CA_FILE")
+}
+
+// GetUpstreamTLSServerName: nome verificado no certificado da aplicação. Env
+// UPSTREAM_TLS_SERVER_NAME; vazio = host de APPLICATION_URL. Obrigatório com
+// UPSTREAM_TLS_CA_FILE se APPLICATION_URL usa IP (não há SNI pra verificar).
+func GetUpstreamTLSServerName() string {
+	return os.Getenv("UPSTREAM_TLS_SERVER_NAME")
+}
+
+// GetUpstreamTLSCertFile/GetUpstreamTLSKeyFile: certificado de cliente pro
+// mTLS com a aplicação. Env UPSTREAM_TLS_CERT_FILE/UPSTREAM_TLS_KEY_FILE.
+func GetUpstreamTLSCertFile() string {
+	return os.Getenv("UPSTREAM_TLS_CERT_FILE")
+}
+
+func GetUpstreamTLSKeyFile() string {
+	return os.Getenv("UPSTREAM_TLS_KEY_FILE")
+}
+
+// GetUpstreamTLSInsecureSkipVerify: opt-in explícito pra não verificar o
+// certificado da aplicação. Env UPSTREAM_TLS_INSECURE_SKIP_VERIFY; default false.
+func GetUpstreamTLSInsecureSkipVerify() bool {
+	skip, err := strconv.ParseBool(os.Getenv("UPSTREAM_TLS_INSECURE_SKIP_VERIFY"))
+	if err != nil {
+		return false
+	}
+	return skip
+}
+
+// GetPreserveHost diz se o Host original do cliente é repassado à aplicação
+// (em vez do host de APPLICATION_URL). Env PRESERVE_HOST; default false.
+func GetPreserveHost() bool {
+	preserveHost, err := strconv.ParseBool(os.Getenv("PRESERVE_HOST"))
+	if err != nil {
+		return false
+	}
+	return preserveHost
+}
+
+// GetCorrelationIDHeader: header com o ID de correlação, gerado quando o
+// cliente não manda e devolvido nos erros do interceptor. Env
+// CORRELATION_ID_HEADER; default X-Request-Id.
+func GetCorrelationIDHeader() string {
+	if header := os.Getenv("CORRELATION_ID_HEADER"); header != "" {
+		return header
+	}
+	return "X-Request-Id"
+}
+
+// GetUpstreamRetryMax: tentativas extras por request após falha de
+// transporte. Env UPSTREAM_RETRY_MAX; default 2 (0 desliga).
+func GetUpstreamRetryMax() int {
+	if _, ok := os.LookupEnv("UPSTREAM_RETRY_MAX"); !ok {
+		return 2
+	}
+	return getNonNegativeInt("UPSTREAM_RETRY_MAX")
+}
+
+// GetUpstreamRetryMethods: quais requests que CHEGARAM na aplicação podem
+// ser repetidos — "idempotent" (default), "all" (a aplicação deduplica pelo
+// Interceptor-Controller) ou lista de métodos. Os que nunca chegaram são
+// sempre repetíveis. Env UPSTREAM_RETRY_METHODS.
+func GetUpstreamRetryMethods() string {
+	if methods := os.Getenv("UPSTREAM_RETRY_METHODS"); methods != "" {
+		return methods
+	}
+	return "idempotent"
+}
+
+// GetUpstreamRetryBackoffMs: atraso da primeira repetição (ms), dobrando até
+// GetUpstreamRetryBackoffMaxMs. Env UPSTREAM_RETRY_BACKOFF_MS; default 50.
+func GetUpstreamRetryBackoffMs() int {
+	if n := getNonNegativeInt("UPSTREAM_RETRY_BACKOFF_MS"); n > 0 {
+		return n
+	}
+	return 50
+}
+
+// GetUpstreamRetryBackoffMaxMs: teto do backoff (ms). Env
+// UPSTREAM_RETRY_BACKOFF_MAX_MS; default 1000.
+func GetUpstreamRetryBackoffMaxMs() int {
+	if n := getNonNegativeInt("UPSTREAM_RETRY_BACKOFF_MAX_MS"); n > 0 {
+		return n
+	}
+	return 1000
+}
+
+// GetUpstreamRetryBudgetRatio: repetições permitidas por request enviado
+// (orçamento), pra retry não multiplicar a carga numa aplicação caída. Env
+// UPSTREAM_RETRY_BUDGET_RATIO; default 0.2.
+func GetUpstreamRetryBudgetRatio() float64 {
+	ratio, err := strconv.ParseFloat(os.Getenv("UPSTREAM_RETRY_BUDGET_RATIO"), 64)
+	if err != nil || ratio < 0 {
+		return 0.2
+	}
+	return ratio
+}
+
+// GetUpstreamRetryMinPerSecond: repetições por segundo sempre permitidas,
+// mesmo com pouco tráfego. Env UPSTREAM_RETRY_MIN_PER_SECOND; default 10.
+func GetUpstreamRetryMinPerSecond() int {
+	if _, ok := os.LookupEnv("UPSTREAM_RETRY_MIN_PER_SECOND"); !ok {
+		return 10
+	}
+	return getNonNegativeInt("UPSTREAM_RETRY_MIN_PER_SECOND")
+}
+
+// GetUpstreamBreakerThreshold: falhas de transporte seguidas que abrem o
+// circuito. Env UPSTREAM_BREAKER_THRESHOLD; default 5 (0 desliga).
+func GetUpstreamBreakerThreshold() int {
+	if _, ok := os.LookupEnv("UPSTREAM_BREAKER_THRESHOLD"); !ok {
+		return 5
+	}
+	return getNonNegativeInt("UPSTREAM_BREAKER_THRESHOLD")
+}
+
+// GetUpstreamBreakerCooldown: intervalo (segundos) entre as sondas que
+// tentam fechar o circuito. Env UPSTREAM_BREAKER_COOLDOWN; default 2.
+func GetUpstreamBreakerCooldown() int {
+	if n := getNonNegativeInt("UPSTREAM_BREAKER_COOLDOWN"); n > 0 {
+		return n
+	}
+	return 2
+}
+
+// GetUpstreamTimeout: timeout (segundos) de cada envio à aplicação quando
+// nenhuma rota de UPSTREAM_ROUTE_TIMEOUTS casa. Env UPSTREAM_TIMEOUT;
+// default 0 (sem timeout).
+func GetUpstreamTimeout() int {
+	return getNonNegativeInt("UPSTREAM_TIMEOUT")
+}
+
+// GetUpstreamRouteTimeouts: timeouts por rota, "[MÉTODO ]padrão=duração"
+// separados por vírgula (ex.: "POST /upload*=5m,/reports/*=90s"). Padrão
+// terminado em * casa por prefixo; o primeiro que casar vale. Env
+// UPSTREAM_ROUTE_TIMEOUTS.
+func GetUpstreamRouteTimeouts() []string {
+	var routes []string
+	for _, route := range strings.Split(os.Getenv("UPSTREAM_ROUTE_TIMEOUTS"), ",") {
+		if route = strings.TrimSpace(route); route != "" {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// GetAsyncRoutes: rotas ("[MÉTODO ]padrão", * no fim casa por prefixo) em
+// que escritas chegando com o gate fechado recebem 202 na hora em vez de
+// segurar a conexão; fora delas o cliente pede com "Prefer: respond-async".
+// Env ASYNC_ROUTES.
+func GetAsyncRoutes() []string {
+	var routes []string
+	for _, route := range strings.Split(os.Getenv("ASYNC_ROUTES"), ",") {
+		if route = strings.TrimSpace(route); route != "" {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// GetAsyncStatusPrefix: prefixo das URLs de status das escritas aceitas com
+// 202; não é encaminhado à aplicação. Env ASYNC_STATUS_PREFIX; default
+// /_async/.
+func GetAsyncStatusPrefix() string {
+	prefix := os.Getenv("ASYNC_STATUS_PREFIX")
+	if prefix == "" {
+		return "/_async/"
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// GetAsyncResultMax: quantos resultados assíncronos ficam guardados; o mais
+// antigo sai quando enche. Env ASYNC_RESULT_MAX; default 10000.
+func GetAsyncResultMax() int {
+	if n := getNonNegativeInt("ASYNC_RESULT_MAX"); n > 0 {
+		return n
+	}
+	return 10000
+}
+
+// GetAsyncResultMaxBytes: teto de memória (bytes) do store de resultados
+// assíncronos, corpos e headers somados; o mais antigo sai quando enche. Sem
+// ele o pior caso seria ASYNC_RESULT_MAX x ASYNC_RESULT_MAX_BODY (~640MB com
+// os defaults). Env ASYNC_RESULT_MAX_BYTES; default 64MiB.
+func GetAsyncResultMaxBytes() int64 {
+	n, err := strconv.ParseInt(os.Getenv("ASYNC_RESULT_MAX_BYTES"), 10, 64)
+	if err != nil || n <= 0 {
+		return 64 << 20
+	}
+	return n
+}
+
+// GetAsyncResultTTL: por quanto tempo (segundos) um resultado assíncrono
+// pode ser consultado. Env ASYNC_RESULT_TTL; default 3600.
+func GetAsyncResultTTL() int {
+	if n := getNonNegativeInt("ASYNC_RESULT_TTL"); n > 0 {
+		return n
+	}
+	return 3600
+}
+
+// GetAsyncResultMaxBody: bytes do corpo da resposta guardados por resultado;
+// acima disso o corpo é descartado e só status e headers ficam. Env
+// ASYNC_RESULT_MAX_BODY; default 65536.
+func GetAsyncResultMaxBody() int {
+	if _, ok := os.LookupEnv("ASYNC_RESULT_MAX_BODY"); !ok {
+		return 65536
+	}
+	return getNonNegativeInt("ASYNC_RESULT_MAX_BODY")
+}
+
+// GetAsyncCallbackAllowedHosts: hosts (ou "*.sufixo") que podem receber o
+// webhook de conclusão pedido no header Async-Callback-Url. Vazio desliga
+// os callbacks. Env ASYNC_CALLBACK_ALLOWED_HOSTS.
+func GetAsyncCallbackAllowedHosts() []string {
+	var hosts []string
+	for _, host := range strings.Split(os.Getenv("ASYNC_CALLBACK_ALLOWED_HOSTS"), ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// GetStatusHeadersEnabled liga os headers Interceptor-* e Server-Timing nas
+// respostas (número do request, fila, tempos de gate/fila/aplicação, geração
+// do snapshot). Env STATUS_HEADERS_ENABLED; default false.
+func GetStatusHeadersEnabled() bool {
+	enabled, err := strconv.ParseBool(os.Getenv("STATUS_HEADERS_ENABLED"))
+	if err != nil {
+		return false
+	}
+	return enabled
+}
+
+// GetQueuePriorityMode: como a fila de recuperação escolhe entre as classes
+// replay, write e read — "strict" (default: só a de maior prioridade com
+// item anda) ou "weighted" (round-robin ponderado por QUEUE_CLASS_WEIGHTS).
+// Env QUEUE_PRIORITY_MODE.
+func GetQueuePriorityMode() string {
+	if mode := os.Getenv("QUEUE_PRIORITY_MODE"); mode != "" {
+		return mode
+	}
+	return "strict"
+}
+
+// GetQueueClassWeights: pesos do modo weighted, "classe=peso" separados por
+// vírgula. Env QUEUE_CLASS_WEIGHTS; default replay=6,write=3,read=1.
+func GetQueueClassWeights() map[string]int {
+	return getClassInts("QUEUE_CLASS_WEIGHTS", map[string]int{"replay": 6, "write": 3, "read": 1})
+}
+
+// GetQueueClassConcurrency: teto de requests da fila em voo por classe,
+// "classe=n" separados por vírgula, abaixo do limite adaptativo global. Env
+// QUEUE_CLASS_CONCURRENCY; default replay=256,write=128,read=64.
+func GetQueueClassConcurrency() map[string]int {
+	return getClassInts("QUEUE_CLASS_CONCURRENCY", map[string]int{"replay": 256, "write": 128, "read": 64})
+}
+
+// queueClasses são as classes aceitas em QUEUE_CLASS_*.
+var queueClasses = []string{"replay", "write", "read"}
+
+// getClassInts lê "classe=n"; classe omitida ou valor inválido fica no default.
+func getClassInts(name string, defaults map[string]int) map[string]int {
+	values := map[string]int{}
+	for class, n := range defaults {
+		values[class] = n
+	}
+	for _, entry := range strings.Split(os.Getenv(name), ",") {
+		class, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || err != nil || n <= 0 {
+			continue
+		}
+		if _, known := defaults[strings.TrimSpace(class)]; known {
+			values[strings.TrimSpace(class)] = n
+		}
+	}
+	return values
+}
+
+func verifyClassInts(name string) {
+	for _, entry := range strings.Split(os.Getenv(name), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		class, value, ok := strings.Cut(entry, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || err != nil || n <= 0 || !slices.Contains(queueClasses, strings.TrimSpace(class)) {
+			panic(name + " entries must be <replay|write|read>=<positive integer>: " + entry)
+		}
+	}
+}
+
+// GetQueueFairnessKey liga o enfileiramento justo nas classes ao vivo da
+// fila de recuperação: "ip" (origem da conexão), "header:<Nome>" ou
+// "subject" (SPIFFE ID ou subject do certificado de cliente verificado);
+// sem identidade cai no ip. Vazio (default) mantém FIFO. Env
+// QUEUE_FAIRNESS_KEY.
+func GetQueueFairnessKey() string {
+	return os.Getenv("QUEUE_FAIRNESS_KEY")
+}
+
+// GetQueueSerializeClientWrites: com QUEUE_FAIRNESS_KEY, no máximo uma
+// escrita enfileirada de cada cliente vai à aplicação por vez, pra que ela
+// veja as escritas do cliente na ordem em que chegaram. Desligado, até
+// QUEUE_CLASS_CONCURRENCY escritas do mesmo cliente vão juntas e a ordem de
+// chegada na aplicação não é garantida. Sem QUEUE_FAIRNESS_KEY não há
+// cliente a serializar. Env QUEUE_SERIALIZE_CLIENT_WRITES; default true.
+func GetQueueSerializeClientWrites() bool {
+	serialize, err := strconv.ParseBool(os.Getenv("QUEUE_SERIALIZE_CLIENT_WRITES"))
+	if err != nil {
+		return true
+	}
+	return serialize
+}
+
+// GetQueueFairnessQuantumKB: crédito (KiB de corpo) que cada cliente ganha
+// por volta no deficit round-robin. Env QUEUE_FAIRNESS_QUANTUM_KB; default 64.
+func GetQueueFairnessQuantumKB() int {
+	if n := getNonNegativeInt("QUEUE_FAIRNESS_QUANTUM_KB"); n > 0 {
+		return n
+	}
+	return 64
+}
+
+// GetAdaptiveConcurrencyInitial: limite inicial de requests simultâneos à
+// aplicação durante a drenagem da fila e a janela pós-reabertura (era o
+// drainSlots fixo de 32). Env ADAPTIVE_CONCURRENCY_INITIAL; default 32.
+func GetAdaptiveConcurrencyInitial() int {
+	if n := getNonNegativeInt("ADAPTIVE_CONCURRENCY_INITIAL"); n > 0 {
+		return n
+	}
+	return 32
+}
+
+// GetAdaptiveConcurrencyMin: piso do limite adaptativo. Env
+// ADAPTIVE_CONCURRENCY_MIN; default 4.
+func GetAdaptiveConcurrencyMin() int {
+	if n := getNonNegativeInt("ADAPTIVE_CONCURRENCY_MIN"); n > 0 {
+		return n
+	}
+	return 4
+}
+
+// GetAdaptiveConcurrencyMax: teto do limite adaptativo. Min = max = inicial
+// fixa o limite. Env ADAPTIVE_CONCURRENCY_MAX; default 512.
+func GetAdaptiveConcurrencyMax() int {
+	if n := getNonNegativeInt("ADAPTIVE_CONCURRENCY_MAX"); n > 0 {
+		return n
+	}
+	return 512
+}
+
+// GetAdaptiveLatencyTolerance: quantas vezes a latência mínima recente da
+// aplicação uma resposta pode levar antes de contar como pico. Env
+// ADAPTIVE_LATENCY_TOLERANCE; default 2.
+func GetAdaptiveLatencyTolerance() float64 {
+	tolerance, err := strconv.ParseFloat(os.Getenv("ADAPTIVE_LATENCY_TOLERANCE"), 64)
+	if err != nil || tolerance <= 1 {
+		return 2
+	}
+	return tolerance
+}
+
+// GetAdaptiveBackoffRatio: fator aplicado ao limite num pico de latência,
+// 5xx ou falha de transporte. Env ADAPTIVE_BACKOFF_RATIO; default 0.9.
+func GetAdaptiveBackoffRatio() float64 {
+	ratio, err := strconv.ParseFloat(os.Getenv("ADAPTIVE_BACKOFF_RATIO"), 64)
+	if err != nil || ratio <= 0 || ratio >= 1 {
+		return 0.9
+	}
+	return ratio
+}
+
+// GetAdaptiveLiveWindow: por quantos segundos após o gate reabrir o tráfego
+// ao vivo também passa pelo limite adaptativo. Env ADAPTIVE_LIVE_WINDOW;
+// default 60 (o flushGrace do heartbeat).
+func GetAdaptiveLiveWindow() int {
+	if _, ok := os.LookupEnv("ADAPTIVE_LIVE_WINDOW"); !ok {
+		return 60
+	}
+	return getNonNegativeInt("ADAPTIVE_LIVE_WINDOW")
+}
+
+// GetRampWindow: duração (segundos) do slow-start após cada reabertura do
+// gate: a fração de tráfego admitida cresce de RAMP_INITIAL_PERCENT a 100%
+// nesse intervalo. Env RAMP_WINDOW; default 0 (desligado).
+func GetRampWindow() int {
+	return getNonNegativeInt("RAMP_WINDOW")
+}
+
+// GetRampInitialPercent: fração do tráfego admitida no início do ramp. Env
+// RAMP_INITIAL_PERCENT; default 10.
+func GetRampInitialPercent() int {
+	if n := getNonNegativeInt("RAMP_INITIAL_PERCENT"); n > 0 && n <= 100 {
+		return n
+	}
+	return 10
+}
+
+// GetRampExcess: o que acontece com o tráfego acima da fração do ramp —
+// "queue" (default: espera slot do limite adaptativo, cujo teto é escalado
+// pela fração) ou "shed" (além disso, a fração não admitida recebe 503 com
+// Retry-After). Env RAMP_EXCESS.
+func GetRampExcess() string {
+	if excess := os.Getenv("RAMP_EXCESS"); excess != "" {
+		return excess
+	}
+	return "queue"
+}
+
+// GetReplayRetryMax: novas tentativas de um replay que a aplicação recusou
+// com erro transitório (408, 425, 429, 5xx exceto 501/505, falha de
+// transporte) antes de ir pro dead-letter. Env REPLAY_RETRY_MAX; default 5.
+func GetReplayRetryMax() int {
+	if _, ok := os.LookupEnv("REPLAY_RETRY_MAX"); !ok {
+		return 5
+	}
+	return getNonNegativeInt("REPLAY_RETRY_MAX")
+}
+
+// GetReplayRetryBackoffMs: atraso (ms) da primeira nova tentativa de replay,
+// dobrando até 30s. Env REPLAY_RETRY_BACKOFF_MS; default 500.
+func GetReplayRetryBackoffMs() int {
+	if n := getNonNegativeInt("REPLAY_RETRY_BACKOFF_MS"); n > 0 {
+		return n
+	}
+	return 500
+}
+
+// GetDeadLetterMax: quantos replays recusados ficam no dead-letter; cheio, o
+// mais antigo é descartado. Env DEAD_LETTER_MAX; default 10000.
+func GetDeadLetterMax() int {
+	if n := getNonNegativeInt("DEAD_LETTER_MAX"); n > 0 {
+		return n
+	}
+	return 10000
+}
@@ -1,22 +1,116 @@
 package config
 
-import "net/http"
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+)
 
 // RequestData is a self-contained copy of an HTTP request. The live
 // *http.Request and http.ResponseWriter are only valid while their handler
 // is running, so anything that outlives the handler (recovery queue,
 // reprocess buffer) must hold this copy instead.
+//
+// Protocol identifies who knows how to replay the entry: empty means HTTP
+// (the interceptor queue); other values are registered by their listeners
+// through crController.RegisterProtocolReprocessCallback. Non-HTTP protocols
+// reuse the fields loosely (Method is the command name, Body the raw bytes
+// as they went on the wire).
+//
+// Large HTTP bodies may live on disk instead of Body (see ReadRequestBody),
+// and buffered copies may hold Body compressed (BodyEncoding): always read
+// HTTP bodies through BodyReader/BodyLength. Buffered Header maps may be
+// shared between entries (see internHeader) and must be treated as
+// read-only.
+//
+// RawPath, Host, RemoteAddr, Scheme and Proto keep what the client sent so
+// that forwarding (live or replay) can rebuild the original URL and the
+// X-Forwarded-*/Forwarded/Via headers.
 type RequestData struct {
-	Method string
-	Path   string
-	Query  string
-	Header http.Header
-	Body   []byte
+	Protocol     string
+	Method       string
+	Path         string
+	RawPath      string
+	Query        string
+	Host         string
+	RemoteAddr   string
+	Scheme       string
+	Proto        string
+	Header       http.Header
+	Body         []byte
+	BodyFile     string
+	BodyEncoding string
+	BodySize     int64
+	// Deadline is the client's time budget for a live request (zero = none).
+	// Buffered copies never keep it: a replay is not bound by the original
+	// client's patience.
+	Deadline time.Time
+}
+
+// BodyReader opens a fresh reader over the body, wherever it is stored. Every
+// send (live or replay) needs its own reader.
+func (d RequestData) BodyReader() (io.ReadCloser, error) {
+	if d.BodyFile != "" {
+		return os.Open(d.BodyFile)
+	}
+	switch d.BodyEncoding {
+	case "zstd":
+		body, err := zstdDecoder.DecodeAll(d.Body, nil)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(body)), nil
+	case "snappy":
+		body, err := s2.Decode(nil, d.Body)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(body)), nil
+	case "gzip":
+		return gzip.NewReader(bytes.NewReader(d.Body))
+	case "flate":
+		return flate.NewReader(bytes.NewReader(d.Body)), nil
+	}
+	return io.NopCloser(bytes.NewReader(d.Body)), nil
+}
+
+// BodyLength returns the (uncompressed) body size in bytes.
+func (d RequestData) BodyLength() int64 {
+	if d.BodyFile != "" || d.BodyEncoding != "" {
+		return d.BodySize
+	}
+	return int64(len(d.Body))
 }
 
 // Result is the outcome of forwarding a request to the application,
 // delivered back to the waiting handler through a channel.
+//
+// Streaming responses (SSE, long chunked bodies) carry Stream instead of
+// Body: whoever receives the Result owns it and must Close it, whether it
+// is copied to the client or discarded.
+//
+// ErrorCode is set when the interceptor, not the application, produced the
+// outcome (the client gets a structured error body instead of Body).
+// NotDelivered means the request never reached the application, so it must
+// not be treated as applied.
+//
+// RequestNumber is the buffer number of a write (0 for reads) and Upstream the
+// time spent sending it, retries included. Proto is the application's
+// response protocol ("HTTP/1.1"), used for the response Via.
 type Result struct {
-	Status int
-	Body   []byte
+	Status        int
+	Proto         string
+	Header        http.Header
+	Body          []byte
+	Stream        io.ReadCloser
+	ErrorCode     string
+	NotDelivered  bool
+	RequestNumber uint64
+	Upstream      time.Duration
 }
@@ -5,6 +5,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
 const (
@@ -20,6 +22,18 @@ type BufferedRequest struct {
 	Data          RequestData
 	RequestNumber uint64
 	State         int
+	Bytes         int64
+	BufferedAt    int64
+}
+
+// RequestStats is a snapshot of the reprocess buffer for monitoring.
+type RequestStats struct {
+	Pending            int
+	Processed          int
+	Snapshoted         int
+	Bytes              int64
+	UnsnapshottedBytes int64
+	SpooledBytes       int64
 }
 
 var processedMap sync.Map
@@ -27,60 +41,134 @@ var requestsMap sync.Map
 var requestNumber atomic.Uint64
 var requestsMapMutex sync.RWMutex
 
+// stateCounts conta as entradas por estado, mantido a cada transição (como
+// os bytes em bufferMemory.go): GetRequestStats roda a cada tick do
+// snapshotter e não pode percorrer centenas de milhares de entradas.
+var stateCounts [Snapshoted + 1]atomic.Int64
+
+// setStateLocked muda o estado de uma entrada nos dois maps e nos contadores.
+// Chamar com requestsMapMutex travado.
+func setStateLocked(bufferedReq *BufferedRequest, state int) {
+	if bufferedReq.State == state {
+		return
+	}
+	stateCounts[bufferedReq.State].Add(-1)
+	stateCounts[state].Add(1)
+	bufferedReq.State = state
+	processedMap.Store(bufferedReq.RequestNumber, state)
+}
+
+// oldestUnsnapshottedAt (unix nano) é quando entrou a escrita mais antiga
+// ainda não coberta por snapshot; 0 = nenhuma. Remoções fora do snapshot
+// (replay) não o recalculam: a idade pode ficar superestimada, nunca
+// subestimada.
+var oldestUnsnapshottedAt atomic.Int64
+
 func GetLatestRequestNumber() uint64 {
 	return requestNumber.Load()
 }
 
+// GetUnsnapshottedAge returns how long the oldest write not yet covered by a
+// snapshot has been waiting (zero when there is none).
+func GetUnsnapshottedAge() time.Duration {
+	oldest := oldestUnsnapshottedAt.Load()
+	if oldest == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, oldest))
+}
+
 // SaveRequestToBuffer stores a copy of the request data for potential reprocessing
 func SaveRequestToBuffer(data RequestData) uint64 {
 	num := requestNumber.Add(1)
+	bufferRequest(num, data, Pending)
+	return num
+}
 
+// RebufferProcessedRequest devolve ao buffer, já como Processed e sob o
+// número original, uma escrita que saiu dele pra ser refeita (retry de
+// replay, redrive do dead-letter) e que a aplicação enfim aceitou: um
+// restore posterior precisa reaplicá-la como qualquer outra.
+func RebufferProcessedRequest(num uint64, data RequestData) {
+	bufferRequest(num, data, Processed)
+}
+
+func bufferRequest(num uint64, data RequestData, state int) {
+	data, size := prepareForBuffer(data)
+	now := time.Now().UnixNano()
 	bufferedReq := &BufferedRequest{
 		Data:          data,
 		RequestNumber: num,
-		State:         Pending,
+		State:         state,
+		Bytes:         size,
+		BufferedAt:    now,
 	}
+	accountBuffered(size)
 
 	requestsMapMutex.Lock()
+	oldestUnsnapshottedAt.CompareAndSwap(0, now)
 	requestsMap.Store(num, bufferedReq)
+	processedMap.Store(num, state)
+	stateCounts[state].Add(1)
 	requestsMapMutex.Unlock()
-
-	processedMap.Store(num, Pending)
-	return num
 }
 
 func UpdateRequestToProcessed(number uint64) {
-	processedMap.Store(number, Processed)
-
-	// Also update the buffered request state
 	requestsMapMutex.Lock()
 	if val, ok := requestsMap.Load(number); ok {
-		if bufferedReq, ok := val.(*BufferedRequest); ok {
-			bufferedReq.State = Processed
+		if bufferedReq, ok := val.(*BufferedRequest); ok && bufferedReq.State == Pending {
+			setStateLocked(bufferedReq, Processed)
 		}
 	}
 	requestsMapMutex.Unlock()
 }
 
 func UpdateRequestsToSnapshoted(latestRequest uint64) {
+	var released []RequestData
 	processedMap.Range(func(key, value interface{}) bool {
 		// Use <= to include the request with ID equal to latestRequest
 		if key.(uint64) <= latestRequest {
-			processedMap.Store(key, Snapshoted)
-
-			// Also update the buffered request state
 			requestsMapMutex.Lock()
 			if val, ok := requestsMap.Load(key); ok {
 				if bufferedReq, ok := val.(*BufferedRequest); ok {
-					bufferedReq.State = Snapshoted
+					if bufferedReq.State != Snapshoted {
+						unsnapshottedBytes.Add(-bufferedReq.Bytes)
+					}
+					setStateLocked(bufferedReq, Snapshoted)
+					released = append(released, bufferedReq.Data)
 				}
 			}
 			requestsMapMutex.Unlock()
 		}
 		return true
 	})
+
+	// O que sobrou fora do snapshot define a nova idade mínima. Recalcula e
+	// grava sob o mesmo lock em que SaveRequestToBuffer faz o CAS(0, now): um
+	// save concorrente ou já está no map, ou vê o valor novo.
+	var oldest int64
+	requestsMapMutex.Lock()
+	requestsMap.Range(func(key, value interface{}) bool {
+		bufferedReq := value.(*BufferedRequest)
+		if bufferedReq.State != Snapshoted && (oldest == 0 || bufferedReq.BufferedAt < oldest) {
+			oldest = bufferedReq.BufferedAt
+		}
+		return true
+	})
+	oldestUnsnapshottedAt.Store(oldest)
+	requestsMapMutex.Unlock()
+
+	// Snapshoted nunca mais é reenviado: corpos em disco já podem sair.
+	for _, data := range released {
+		ReleaseBody(data)
+	}
 }
 
+// Idade a partir da qual uma entrada ainda Pending é dada como órfã: nenhum
+// envio (retries e timeouts incluídos) dura tanto, então ninguém mais vai
+// marcá-la como Processed nem removê-la.
+const stalePendingAge = 15 * time.Minute
+
 func ClearRequestsMap() {
 	tick := time.Tick(60 * time.Second)
 	for range tick {
@@ -95,22 +183,42 @@ func ClearRequestsMap() {
 		snapshotInProgress := IsSnapshotBeingTaken
 		SnapshotLock.Unlock()
 
+		staleBefore := time.Now().Add(-stalePendingAge).UnixNano()
+		stale := 0
 		processedMap.Range(func(key, value interface{}) bool {
 			state := value.(int)
 			if state == Snapshoted {
 				keysToDelete = append(keysToDelete, key)
 			} else if state == Processed && !snapshotInProgress && !GetCheckpointEnabled() {
 				keysToDelete = append(keysToDelete, key)
+			} else if state == Pending {
+				if val, ok := requestsMap.Load(key); ok && val.(*BufferedRequest).BufferedAt < staleBefore {
+					keysToDelete = append(keysToDelete, key)
+					stale++
+				}
 			}
 			return true
 		})
+		if stale > 0 {
+			log.Warn().Int("entries", stale).Dur("age", stalePendingAge).
+				Msg("Stale Pending entries removed from the reprocess buffer")
+		}
 
+		var released []RequestData
 		requestsMapMutex.Lock()
 		for _, key := range keysToDelete {
+			if val, ok := requestsMap.Load(key); ok {
+				bufferedReq := val.(*BufferedRequest)
+				forgetBufferedEntry(bufferedReq)
+				released = append(released, bufferedReq.Data)
+			}
 			processedMap.Delete(key)
 			requestsMap.Delete(key)
 		}
 		requestsMapMutex.Unlock()
+		for _, data := range released {
+			ReleaseBody(data)
+		}
 	}
 }
 
@@ -140,28 +248,42 @@ func GetReprocessableRequests() []*BufferedRequest {
 	return reprocessableRequests
 }
 
-// GetRequestStats returns counts of requests in each state for monitoring
-func GetRequestStats() (pending, processed, snapshoted int) {
-	processedMap.Range(func(key, value interface{}) bool {
-		switch value.(int) {
-		case Pending:
-			pending++
-		case Processed:
-			processed++
-		case Snapshoted:
-			snapshoted++
-		}
-		return true
-	})
-	return
+// GetRequestStats returns counts of requests in each state, and the memory
+// and disk they hold, for monitoring
+func GetRequestStats() RequestStats {
+	stats := RequestStats{
+		Pending:    int(stateCounts[Pending].Load()),
+		Processed:  int(stateCounts[Processed].Load()),
+		Snapshoted: int(stateCounts[Snapshoted].Load()),
+	}
+	stats.Bytes, stats.UnsnapshottedBytes = GetBufferedBytes()
+	spoolMutex.Lock()
+	stats.SpooledBytes = spooledBytes
+	spoolMutex.Unlock()
+	return stats
+}
+
+// forgetBufferedEntry desconta uma entrada que está saindo do buffer. Chamar
+// com requestsMapMutex travado.
+func forgetBufferedEntry(bufferedReq *BufferedRequest) {
+	stateCounts[bufferedReq.State].Add(-1)
+	bufferedBytes.Add(-bufferedReq.Bytes)
+	if bufferedReq.State != Snapshoted {
+		unsnapshottedBytes.Add(-bufferedReq.Bytes)
+	}
 }
 
 // RemoveRequestFromBuffer drops an entry after it was handed back to the
 // recovery queue: the replay re-buffers it under a new number, so keeping the
 // old entry as Pending would replay it again on every future ReprocessRequests
-// and leak (ClearRequestsMap never collects Pending).
+// and leak (ClearRequestsMap only collects Pending past stalePendingAge). It
+// also drops writes that never reached the application. A spooled body is NOT
+// released here: the replayed copy still points at the same file.
 func RemoveRequestFromBuffer(requestNum uint64) {
 	requestsMapMutex.Lock()
+	if val, ok := requestsMap.Load(requestNum); ok {
+		forgetBufferedEntry(val.(*BufferedRequest))
+	}
 	processedMap.Delete(requestNum)
 	requestsMap.Delete(requestNum)
 	requestsMapMutex.Unlock()
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"crypto/tls"
+	"sync"
+
+	"interceptor-grpc/tlsutil"
+
+	"github.com/rs/zerolog/log"
+)
+
+var upstreamTLSConfig = sync.OnceValue(newUpstreamTLSConfig)
+
+func newUpstreamTLSConfig() *tls.Config {
+	if GetUpstreamTLSInsecureSkipVerify() {
+		log.Warn().Msg("UPSTREAM_TLS_INSECURE_SKIP_VERIFY is set: application certificates are not verified")
+	}
+	var cert *tlsutil.CertReloader
+	var ca *tlsutil.CAReloader
+	var err error
+	if certFile := GetUpstreamTLSCertFile(); certFile != "" {
+		if cert, err = tlsutil.NewCertReloader(certFile, GetUpstreamTLSKeyFile()); err != nil {
+			log.Fatal().Err(err).Msg("Failed to load upstream TLS client certificate")
+		}
+	}
+	if caFile := GetUpstreamTLSCAFile(); caFile != "" && !GetUpstreamTLSInsecureSkipVerify() {
+		if ca, err = tlsutil.NewCAReloader(caFile); err != nil {
+			log.Fatal().Err(err).Msg("Failed to load upstream TLS CA bundle")
+		}
+	}
+	cfg := tlsutil.ClientConfig(cert, ca, GetUpstreamTLSServerName())
+	if GetUpstreamTLSInsecureSkipVerify() {
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = nil
+	}
+	return cfg
+}
+
+// UpstreamTLSConfig returns the TLS settings for every connection to the
+// application (forwarding, upgrades, heartbeat, canary). Each call returns a
+// copy the caller may adjust.
+func UpstreamTLSConfig() *tls.Config {
+	return upstreamTLSConfig().Clone()
+}
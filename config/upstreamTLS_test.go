@@ -0,0 +1,97 @@
+package config
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCA(t *testing.T, der []byte) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func selfSignedCA(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "other CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestUpstreamTLSConfig(t *testing.T) {
+	// O certificado do httptest vale pra example.com e 127.0.0.1.
+	server := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+	serverCA := writeCA(t, server.Certificate().Raw)
+	wrongCA := writeCA(t, selfSignedCA(t))
+
+	cases := []struct {
+		name, ca, serverName, host string
+		insecure                   bool
+		ok                         bool
+	}{
+		{"host from the URL", serverCA, "", "example.com", false, true},
+		{"explicit server name", serverCA, "example.com", "app.internal", false, true},
+		{"wrong host", serverCA, "", "app.internal", false, false},
+		{"wrong server name", serverCA, "wrong.example", "example.com", false, false},
+		{"IP with server name", serverCA, "127.0.0.1", "127.0.0.1", false, true},
+		{"IP without server name", serverCA, "", "127.0.0.1", false, false},
+		{"wrong CA", wrongCA, "", "example.com", false, false},
+		{"wrong CA, insecure", wrongCA, "", "example.com", true, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Setenv("UPSTREAM_TLS_CA_FILE", c.ca)
+			t.Setenv("UPSTREAM_TLS_SERVER_NAME", c.serverName)
+			if c.insecure {
+				t.Setenv("UPSTREAM_TLS_INSECURE_SKIP_VERIFY", "true")
+			}
+			// Qualquer host da URL cai no servidor de teste.
+			transport := &http.Transport{
+				TLSClientConfig: newUpstreamTLSConfig(),
+				DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+				},
+			}
+			defer transport.CloseIdleConnections()
+			resp, err := (&http.Client{Transport: transport}).Get("https://" + c.host + "/")
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err == nil) != c.ok {
+				t.Errorf("request error %v, want ok=%v", err, c.ok)
+			}
+		})
+	}
+}
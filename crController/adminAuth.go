@@ -0,0 +1,115 @@
+package crController
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Janela aceita entre X-Admin-Timestamp e o relógio local: limita o replay
+// de uma assinatura capturada.
+const adminSignatureMaxSkew = 5 * time.Minute
+
+var adminRejectedTotal = metrics.NewCounter("interceptor_admin_rejected_total",
+	"Requests to /_internal/ endpoints rejected by source CIDR or credentials")
+
+// AdminAuth protege um endpoint /_internal/. Com ADMIN_ALLOWED_CIDRS a origem
+// precisa estar numa das redes; com ADMIN_TOKEN e/ou ADMIN_HMAC_SECRET o
+// request precisa trazer o token (Authorization: Bearer) ou uma assinatura
+// válida (X-Admin-Timestamp + X-Admin-Signature = hex(HMAC-SHA256(segredo,
+// "<timestamp>\n<método>\n<path>"))). Sem nada configurado passa tudo, o que
+// só é aceitável no listener separado (ADMIN_PORT): o listener principal nem
+// monta as rotas nesse caso (AdminCredentialsConfigured).
+func AdminAuth(next http.HandlerFunc) http.HandlerFunc {
+	var networks []*net.IPNet
+	for _, cidr := range config.GetAdminAllowedCIDRs() {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatal().Err(err).Str("cidr", cidr).Msg("Invalid ADMIN_ALLOWED_CIDRS entry")
+		}
+		networks = append(networks, network)
+	}
+	token := config.GetAdminToken()
+	secret := config.GetAdminHmacSecret()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(networks) > 0 && !sourceAllowed(r.RemoteAddr, networks) {
+			adminRejectedTotal.Inc()
+			log.Warn().Str("remote", r.RemoteAddr).Str("path", r.URL.Path).Msg("Admin request rejected: source not allowed")
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if (token != "" || secret != "") && !validAdminToken(r, token) && !validAdminSignature(r, secret) {
+			adminRejectedTotal.Inc()
+			log.Warn().Str("remote", r.RemoteAddr).Str("path", r.URL.Path).Msg("Admin request rejected: invalid credentials")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// AdminCredentialsConfigured diz se há alguma proteção além da porta:
+// ADMIN_TOKEN, ADMIN_HMAC_SECRET ou ADMIN_ALLOWED_CIDRS.
+func AdminCredentialsConfigured() bool {
+	return config.GetAdminToken() != "" || config.GetAdminHmacSecret() != "" ||
+		len(config.GetAdminAllowedCIDRs()) > 0
+}
+
+func sourceAllowed(remoteAddr string, networks []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func validAdminToken(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	presented, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return found && subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1
+}
+
+func validAdminSignature(r *http.Request, secret string) bool {
+	if secret == "" {
+		return false
+	}
+	timestamp := r.Header.Get("X-Admin-Timestamp")
+	signature, err := hex.DecodeString(r.Header.Get("X-Admin-Signature"))
+	if timestamp == "" || err != nil {
+		return false
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := time.Since(time.Unix(seconds, 0))
+	if skew > adminSignatureMaxSkew || skew < -adminSignatureMaxSkew {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + r.Method + "\n" + r.URL.Path))
+	return hmac.Equal(signature, mac.Sum(nil))
+}
@@ -0,0 +1,142 @@
+package crController
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func adminSignature(secret, timestamp, method, path string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + method + "\n" + path))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func adminStatus(handler http.HandlerFunc, r *http.Request) int {
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w.Code
+}
+
+func TestAdminAuthToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "s3cret")
+	handler := AdminAuth(func(w http.ResponseWriter, r *http.Request) {})
+	cases := map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer s3cret": http.StatusOK,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer s3cre":  http.StatusUnauthorized,
+		"bearer s3cret": http.StatusUnauthorized,
+		"s3cret":        http.StatusUnauthorized,
+		"Basic s3cret":  http.StatusUnauthorized,
+	}
+	for authorization, want := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/_internal/replay", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		if got := adminStatus(handler, r); got != want {
+			t.Errorf("Authorization %q: %d, want %d", authorization, got, want)
+		}
+	}
+}
+
+func TestAdminAuthSignature(t *testing.T) {
+	t.Setenv("ADMIN_HMAC_SECRET", "key")
+	handler := AdminAuth(func(w http.ResponseWriter, r *http.Request) {})
+	now := time.Now()
+	stamp := func(d time.Duration) string { return strconv.FormatInt(now.Add(d).Unix(), 10) }
+
+	cases := []struct {
+		name, timestamp, signature string
+		want                       int
+	}{
+		{"valid", stamp(0), adminSignature("key", stamp(0), "POST", "/_internal/replay"), http.StatusOK},
+		{"slightly old", stamp(-4 * time.Minute), adminSignature("key", stamp(-4*time.Minute), "POST", "/_internal/replay"), http.StatusOK},
+		{"too old", stamp(-6 * time.Minute), adminSignature("key", stamp(-6*time.Minute), "POST", "/_internal/replay"), http.StatusUnauthorized},
+		{"too far ahead", stamp(6 * time.Minute), adminSignature("key", stamp(6*time.Minute), "POST", "/_internal/replay"), http.StatusUnauthorized},
+		{"wrong method", stamp(0), adminSignature("key", stamp(0), "GET", "/_internal/replay"), http.StatusUnauthorized},
+		{"wrong path", stamp(0), adminSignature("key", stamp(0), "POST", "/_internal/dead-letters"), http.StatusUnauthorized},
+		{"wrong secret", stamp(0), adminSignature("other", stamp(0), "POST", "/_internal/replay"), http.StatusUnauthorized},
+		{"timestamp not signed", stamp(1 * time.Second), adminSignature("key", stamp(0), "POST", "/_internal/replay"), http.StatusUnauthorized},
+		{"not hex", stamp(0), "zz", http.StatusUnauthorized},
+		{"no timestamp", "", adminSignature("key", "", "POST", "/_internal/replay"), http.StatusUnauthorized},
+		{"no signature", stamp(0), "", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/_internal/replay", nil)
+		if c.timestamp != "" {
+			r.Header.Set("X-Admin-Timestamp", c.timestamp)
+		}
+		if c.signature != "" {
+			r.Header.Set("X-Admin-Signature", c.signature)
+		}
+		if got := adminStatus(handler, r); got != c.want {
+			t.Errorf("%s: %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+// Com token e segredo basta um dos dois; a rede vale antes de ambos.
+func TestAdminAuthSourceAndEitherCredential(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "s3cret")
+	t.Setenv("ADMIN_HMAC_SECRET", "key")
+	t.Setenv("ADMIN_ALLOWED_CIDRS", "10.0.0.0/8, fd00::/8")
+	handler := AdminAuth(func(w http.ResponseWriter, r *http.Request) {})
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	request := func(remote string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/_internal/status", nil)
+		r.RemoteAddr = remote
+		return r
+	}
+	withToken := func(r *http.Request) *http.Request {
+		r.Header.Set("Authorization", "Bearer s3cret")
+		return r
+	}
+	withSignature := func(r *http.Request) *http.Request {
+		r.Header.Set("X-Admin-Timestamp", timestamp)
+		r.Header.Set("X-Admin-Signature", adminSignature("key", timestamp, "GET", "/_internal/status"))
+		return r
+	}
+
+	if got := adminStatus(handler, withToken(request("10.1.2.3:4000"))); got != http.StatusOK {
+		t.Errorf("token from allowed network: %d", got)
+	}
+	if got := adminStatus(handler, withSignature(request("[fd00::5]:4000"))); got != http.StatusOK {
+		t.Errorf("signature from allowed network: %d", got)
+	}
+	if got := adminStatus(handler, request("10.1.2.3:4000")); got != http.StatusUnauthorized {
+		t.Errorf("no credentials from allowed network: %d", got)
+	}
+	if got := adminStatus(handler, withToken(request("192.168.0.1:4000"))); got != http.StatusForbidden {
+		t.Errorf("token from outside the networks: %d", got)
+	}
+}
+
+func TestSourceAllowed(t *testing.T) {
+	_, v4, _ := net.ParseCIDR("10.0.0.0/8")
+	_, v6, _ := net.ParseCIDR("fd00::/8")
+	networks := []*net.IPNet{v4, v6}
+	cases := map[string]bool{
+		"10.255.0.1:80":        true,
+		"10.0.0.1":             true,
+		"[fd12::1]:443":        true,
+		"11.0.0.1:80":          false,
+		"[2001:db8::1]:80":     false,
+		"[::ffff:10.0.0.1]:80": true,
+		"not-an-ip:80":         false,
+		"":                     false,
+	}
+	for remote, want := range cases {
+		if got := sourceAllowed(remote, networks); got != want {
+			t.Errorf("sourceAllowed(%q) = %v, want %v", remote, got, want)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
 	"interceptor-grpc/config"
+	"interceptor-grpc/metrics"
 	"interceptor-grpc/protos"
 )
 
@@ -34,6 +35,43 @@ var LastTrafficRelease atomic.Int64
 // que os writes estejam nele (perda permanente — medido 2x com intervalo 180s).
 var CanaryVerdictPending atomic.Bool
 
+// ConsecutiveSnapshotFailures conta snapshots que falharam (conexão, Create
+// recusado, Reply que nunca chegou) desde o último Reply. O snapshotter usa
+// pro backoff; o Reply zera.
+var ConsecutiveSnapshotFailures atomic.Int64
+
+// LastSnapshotSuccess marca (unix nano) o último Reply recebido.
+var LastSnapshotSuccess atomic.Int64
+
+// DurabilityDegraded é setado quando a escrita mais antiga fora de snapshot
+// passa de MAX_UNSNAPSHOTTED_AGE. Com DURABILITY_HARD_MODE o interceptor
+// recusa escritas enquanto estiver setado.
+var DurabilityDegraded atomic.Bool
+
+// WritesRejected diz se escritas novas devem ser recusadas agora
+// (DURABILITY_HARD_MODE com a durabilidade degradada). Vale pra todos os
+// protocolos que bufferizam: HTTP, RESP, TCP e WebSocket gravado.
+func WritesRejected() bool {
+	return config.GetDurabilityHardMode() && DurabilityDegraded.Load()
+}
+
+// SnapshotGeneration counts started snapshots. It identifies which snapshot
+// the snapshotter's safety-net goroutine was armed for: without it, the
+// goroutine from snapshot N (sleeping replyTimeout, which can coincide with
+// the checkpoint interval) wakes up exactly when snapshot N+1 starts, sees the
+// global IsDoingSnapshot flag set and releases N+1's locks, unblocking traffic
+// while the daemon is still dumping/pushing. The interceptor also reports it
+// to clients in its status headers.
+var SnapshotGeneration atomic.Uint64
+
+// UpstreamCircuitOpen é setado pelo circuit breaker do interceptor após
+// falhas seguidas de transporte: requests novos vão pra fila de recuperação
+// (que não drena enquanto aberto) em vez de falhar.
+var UpstreamCircuitOpen atomic.Bool
+
+var replayCompactedTotal = metrics.NewCounter("interceptor_replay_compacted_total",
+	"Buffered writes dropped before replay because a later write superseded them")
+
 // ReprocessCallback is a function type for adding requests back to the queue.
 // This callback is set by the interceptor package to avoid circular imports.
 // It receives a request COPY: the live *http.Request/ResponseWriter die when
@@ -41,19 +79,35 @@ var CanaryVerdictPending atomic.Bool
 type ReprocessCallback func(data config.RequestData)
 
 var reprocessCallback ReprocessCallback
+var protocolReprocessCallbacks = map[string]ReprocessCallback{}
 var drainConnectionsCallback func()
+var longLivedConnectionsCallback func()
 
 // RegisterReprocessCallback allows the interceptor package to register its AddRequestToQueue function
 func RegisterReprocessCallback(callback ReprocessCallback) {
 	reprocessCallback = callback
 }
 
+// RegisterProtocolReprocessCallback registra o replay de entradas do buffer
+// cujo RequestData.Protocol não é HTTP (ex.: "resp"). Deve ser chamada antes
+// do listener do protocolo começar a bufferizar.
+func RegisterProtocolReprocessCallback(protocol string, callback ReprocessCallback) {
+	protocolReprocessCallbacks[protocol] = callback
+}
+
 // RegisterDrainConnectionsCallback registra a função que fecha conexões keep-alive
 // antes do checkpoint. Deve ser chamada antes do primeiro StopRequests.
 func RegisterDrainConnectionsCallback(fn func()) {
 	drainConnectionsCallback = fn
 }
 
+// RegisterLongLivedConnectionsCallback registra a função que aplica a política
+// de conexões de longa duração (upgrade/WebSocket) antes do checkpoint. Elas
+// não entram em InFlightRequests, então sem isso o dump as pegaria abertas.
+func RegisterLongLivedConnectionsCallback(fn func()) {
+	longLivedConnectionsCallback = fn
+}
+
 type server struct {
 	protos.UnimplementedFailureServiceServer
 	protos.UnimplementedSnapshotRPCServiceServer
@@ -62,6 +116,9 @@ type server struct {
 func (s *server) StopRequests(_ context.Context, _ *protos.RestoreRequest) (*protos.RestoreResponse, error) {
 	IsContainerUnavailable.Store(true)
 	IsRestoringSnapshot.Store(true)
+	if longLivedConnectionsCallback != nil {
+		longLivedConnectionsCallback()
+	}
 	// Aguarda todos os requests em voo terminarem, depois drena o pool de conexões
 	// keep-alive. O CRIU requer zero conexões TCP abertas no momento do dump.
 	InFlightRequests.Wait()
@@ -72,8 +129,9 @@ func (s *server) StopRequests(_ context.Context, _ *protos.RestoreRequest) (*pro
 }
 
 func (s *server) ReprocessRequests(_ context.Context, _ *protos.RestoreRequest) (*protos.RestoreResponse, error) {
-	n := ReplayBufferedRequests()
-	log.Info().Int("replayed", n).Msg("ReprocessRequests: buffered requests queued for replay")
+	n, compacted := ReplayBufferedRequests()
+	log.Info().Int("replayed", n).Int("compacted", compacted).
+		Msg("ReprocessRequests: buffered requests queued for replay")
 
 	IsRestoringSnapshot.Store(false)
 	IsContainerUnavailable.Store(false)
@@ -85,20 +143,40 @@ func (s *server) ReprocessRequests(_ context.Context, _ *protos.RestoreRequest)
 // requests do buffer ainda não cobertas por um snapshot (Pending/Processed) —
 // elas foram perdidas quando o backend restaurou um checkpoint anterior.
 // Replay-only: o cliente original já foi respondido (ou desistiu), então o
-// resultado é descartado. Cada entrada sai do buffer ao ser re-enfileirada
-// (o replay re-registra sob um número novo). Retorna o total enfileirado.
+// resultado é descartado. Entradas não-HTTP vão pro callback do protocolo.
+// Antes do replay, escritas sobrescritas por uma posterior na mesma chave
+// (REPLAY_COMPACTION) saem do buffer sem reenvio. Cada entrada sai do buffer ao ser re-enfileirada
+// (o replay re-registra sob um número novo). Retorna o total enfileirado e o
+// total compactado.
 // Chamado pelo gRPC ReprocessRequests e pelo heartbeat ao detectar recuperação.
-func ReplayBufferedRequests() int {
+func ReplayBufferedRequests() (int, int) {
 	if reprocessCallback == nil {
 		log.Warn().Msg("Reprocess callback not registered")
-		return 0
+		return 0, 0
+	}
+	queued := 0
+	reprocessableRequests, superseded := config.CompactReprocessable(config.GetReprocessableRequests())
+	for _, bufferedReq := range superseded {
+		config.RemoveRequestFromBuffer(bufferedReq.RequestNumber)
+		config.ReleaseBody(bufferedReq.Data)
 	}
-	reprocessableRequests := config.GetReprocessableRequests()
+	replayCompactedTotal.Add(int64(len(superseded)))
 	for _, bufferedReq := range reprocessableRequests {
-		reprocessCallback(bufferedReq.Data)
+		callback := reprocessCallback
+		if protocol := bufferedReq.Data.Protocol; protocol != "" {
+			callback = protocolReprocessCallbacks[protocol]
+			if callback == nil {
+				// Sem replay registrado: mantém no buffer em vez de descartar.
+				log.Warn().Str("protocol", protocol).Uint64("request", bufferedReq.RequestNumber).
+					Msg("No reprocess callback for protocol, entry kept in buffer")
+				continue
+			}
+		}
+		callback(bufferedReq.Data)
 		config.RemoveRequestFromBuffer(bufferedReq.RequestNumber)
+		queued++
 	}
-	return len(reprocessableRequests)
+	return queued, len(superseded)
 }
 
 func (s *server) Reply(_ context.Context, replySnapshot *protos.ReplySnapshotRequest) (*protos.AckResponse, error) {
@@ -109,6 +187,8 @@ func (s *server) Reply(_ context.Context, replySnapshot *protos.ReplySnapshotReq
 		Msg("Snapshot Reply received from daemon")
 
 	config.UpdateRequestsToSnapshoted(replySnapshot.LatestRequest)
+	ConsecutiveSnapshotFailures.Store(0)
+	LastSnapshotSuccess.Store(time.Now().UnixNano())
 
 	IsDoingSnapshot.Store(false)
 	config.SnapshotLock.Lock()
@@ -127,9 +207,10 @@ func IsUnavailable() bool {
 	// when checkpoint is off causes a feedback loop where concurrent requests
 	// pile into the queue faster than it drains (50ms/iteration).
 	if !config.GetCheckpointEnabled() {
-		return IsContainerUnavailable.Load()
+		return IsContainerUnavailable.Load() || UpstreamCircuitOpen.Load()
 	}
-	return IsRunningPendingRequestQueue.Load() || IsDoingSnapshot.Load() || IsRestoringSnapshot.Load() || IsContainerUnavailable.Load()
+	return IsRunningPendingRequestQueue.Load() || IsDoingSnapshot.Load() || IsRestoringSnapshot.Load() ||
+		IsContainerUnavailable.Load() || UpstreamCircuitOpen.Load()
 }
 
 func PodBeganRestarting(w http.ResponseWriter, _ *http.Request) {
@@ -148,7 +229,7 @@ func RunGRPCServer() {
 		log.Fatal().Err(err).Str("port", config.GetSelfGrpcUrl()).Msg("Failed to listen on port")
 	}
 
-	s := grpc.NewServer()
+	s := grpc.NewServer(grpcServerOptions()...)
 	protos.RegisterFailureServiceServer(s, &server{})
 	protos.RegisterSnapshotRPCServiceServer(s, &server{})
 	if err := s.Serve(lis); err != nil {
@@ -0,0 +1,106 @@
+package crController
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"strings"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/metrics"
+	"interceptor-grpc/tlsutil"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var grpcAuthRejectedTotal = metrics.NewCounter("interceptor_grpc_auth_rejected_total",
+	"Control-plane RPCs rejected by token or SPIFFE ID authorization")
+
+// grpcServerOptions monta TLS/mTLS e a autorização do servidor gRPC. Sem
+// isso, qualquer um que alcance GRPC_URL chama StopRequests (congela o
+// tráfego) ou Reply (descarta o buffer).
+func grpcServerOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if certFile := config.GetGrpcTLSCertFile(); certFile != "" {
+		cert, err := tlsutil.NewCertReloader(certFile, config.GetGrpcTLSKeyFile())
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load gRPC TLS certificate")
+		}
+		var clientCA *tlsutil.CAReloader
+		if caFile := config.GetGrpcTLSClientCAFile(); caFile != "" {
+			if clientCA, err = tlsutil.NewCAReloader(caFile); err != nil {
+				log.Fatal().Err(err).Msg("Failed to load gRPC client CA")
+			}
+		}
+		tlsConfig := tlsutil.ServerConfig(cert, clientCA, tls.RequireAndVerifyClientCert)
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		log.Info().Bool("mtls", clientCA != nil).Msg("gRPC server TLS enabled")
+	} else {
+		log.Warn().Msg("gRPC server running without TLS (GRPC_TLS_CERT_FILE not set)")
+	}
+
+	if config.GetGrpcAuthToken() != "" || len(config.GetGrpcAllowedSpiffeIDs()) > 0 {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(unaryAuthInterceptor),
+			grpc.ChainStreamInterceptor(streamAuthInterceptor))
+	}
+	return opts
+}
+
+func unaryAuthInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := authorizeRPC(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func streamAuthInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := authorizeRPC(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// authorizeRPC aceita a chamada se o bearer token confere OU o SPIFFE ID do
+// certificado de cliente está na lista — basta um dos mecanismos
+// configurados.
+func authorizeRPC(ctx context.Context, method string) error {
+	token := config.GetGrpcAuthToken()
+	allowedIDs := config.GetGrpcAllowedSpiffeIDs()
+
+	if token != "" {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			for _, value := range md.Get("authorization") {
+				presented, found := strings.CutPrefix(value, "Bearer ")
+				if found && subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1 {
+					return nil
+				}
+			}
+		}
+	}
+
+	spiffeID := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.PeerCertificates) > 0 {
+			spiffeID = tlsutil.SpiffeID(tlsInfo.State.PeerCertificates[0])
+		}
+	}
+	for _, id := range allowedIDs {
+		if spiffeID != "" && spiffeID == id {
+			return nil
+		}
+	}
+
+	grpcAuthRejectedTotal.Inc()
+	log.Warn().Str("method", method).Str("spiffe_id", spiffeID).Msg("Rejected unauthorized gRPC call")
+	if spiffeID != "" && len(allowedIDs) > 0 {
+		return status.Error(codes.PermissionDenied, "spiffe id not allowed")
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid credentials")
+}
@@ -0,0 +1,64 @@
+package crController
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/url"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// rpcContext monta o contexto de uma chamada com o header authorization e o
+// SPIFFE ID do certificado de cliente (vazios = ausentes).
+func rpcContext(authorization, spiffeID string) context.Context {
+	ctx := context.Background()
+	if authorization != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", authorization))
+	}
+	if spiffeID != "" {
+		id, _ := url.Parse(spiffeID)
+		cert := &x509.Certificate{URIs: []*url.URL{id}}
+		ctx = peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}},
+		}})
+	}
+	return ctx
+}
+
+func TestAuthorizeRPC(t *testing.T) {
+	const controller = "spiffe://cluster.local/ns/system/sa/controller"
+	const other = "spiffe://cluster.local/ns/default/sa/app"
+	cases := []struct {
+		name, token, spiffeIDs  string
+		authorization, presents string
+		want                    codes.Code
+	}{
+		{"token only, valid", "t0k", "", "Bearer t0k", "", codes.OK},
+		{"token only, wrong", "t0k", "", "Bearer nope", "", codes.Unauthenticated},
+		{"token only, missing", "t0k", "", "", "", codes.Unauthenticated},
+		{"token only, id ignored", "t0k", "", "", controller, codes.Unauthenticated},
+		{"spiffe only, allowed", "", controller, "", controller, codes.OK},
+		{"spiffe only, other id", "", controller, "", other, codes.PermissionDenied},
+		{"spiffe only, no certificate", "", controller, "", "", codes.Unauthenticated},
+		{"spiffe only, token ignored", "", controller, "Bearer t0k", "", codes.Unauthenticated},
+		{"both, token passes", "t0k", controller, "Bearer t0k", other, codes.OK},
+		{"both, id passes", "t0k", controller, "Bearer nope", controller, codes.OK},
+		{"both, neither", "t0k", controller, "Bearer nope", other, codes.PermissionDenied},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Setenv("GRPC_AUTH_TOKEN", c.token)
+			t.Setenv("GRPC_ALLOWED_SPIFFE_IDS", c.spiffeIDs)
+			err := authorizeRPC(rpcContext(c.authorization, c.presents), "/crController.CRController/StopRequests")
+			if got := status.Code(err); got != c.want {
+				t.Errorf("code %v, want %v (%v)", got, c.want, err)
+			}
+		})
+	}
+}
@@ -5,15 +5,16 @@ go 1.22.0
 toolchain go1.23.0
 
 require (
+	github.com/gorilla/mux v1.8.1
+	github.com/klauspost/compress v1.17.9
+	github.com/rs/zerolog v1.33.0
 	google.golang.org/grpc v1.65.0
 	google.golang.org/protobuf v1.34.2
 )
 
 require (
-	github.com/gorilla/mux v1.8.1 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	github.com/rs/zerolog v1.33.0 // indirect
 	golang.org/x/net v0.26.0 // indirect
 	golang.org/x/sys v0.24.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
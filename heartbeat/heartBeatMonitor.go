@@ -21,7 +21,7 @@ import (
 // caísse, de forma errática).
 var hbClient = &http.Client{
 	Timeout:   2 * time.Second,
-	Transport: &http.Transport{DisableKeepAlives: true},
+	Transport: &http.Transport{DisableKeepAlives: true, TLSClientConfig: config.UpstreamTLSConfig()},
 }
 
 // O canário tem cliente PRÓPRIO com timeout generoso: ele não é probe de
@@ -32,18 +32,20 @@ var hbClient = &http.Client{
 // perdidos). Medido no v5: detecção foi de 67s pra 269s.
 var canaryClient = &http.Client{
 	Timeout:   30 * time.Second,
-	Transport: &http.Transport{DisableKeepAlives: true},
+	Transport: &http.Transport{DisableKeepAlives: true, TLSClientConfig: config.UpstreamTLSConfig()},
 }
 
 // flushGrace é a janela após um desbloqueio de tráfego pós-snapshot em que
 // erros de APLICAÇÃO (status>299) no health não fecham o gate: o backend está
 // digerindo o flush de backlog, não morto. Connection refused fecha SEMPRE
-// (sinal inequívoco de pod morto, independe de graça).
+// (sinal inequívoco de pod morto, independe de graça). Com slow-start
+// (RAMP_WINDOW) o flush só termina no fim do ramp: a graça vale a partir daí.
 const flushGrace = 60 * time.Second
 
 func inFlushGrace() bool {
 	t := crController.LastTrafficRelease.Load()
-	return t > 0 && time.Since(time.Unix(0, t)) < flushGrace
+	grace := flushGrace + time.Duration(config.GetRampWindow())*time.Second
+	return t > 0 && time.Since(time.Unix(0, t)) < grace
 }
 
 // canaryKey é a chave reservada do canário de regressão de estado — fora do
@@ -149,10 +151,15 @@ func Monitor() {
 			// nenhum) e, num restore real, dispara DEPOIS do canário, re-
 			// enfileirando o que o replay já re-registrou no buffer —
 			// amplificação (medido: 173K do canário + 197K da transição).
-			if crController.IsContainerUnavailable.Load() {
+			if crController.IsContainerUnavailable.Swap(false) {
 				log.Warn().Msg("Recovery detected by heartbeat: unblocking traffic (replay delegated to canary)")
+				// Com RAMP_WINDOW a reabertura também é desbloqueio: início do
+				// ramp e da graça. Sem ramp fica como antes — falha de health
+				// pós-reabertura fecha o gate na hora.
+				if config.GetRampWindow() > 0 {
+					crController.LastTrafficRelease.Store(time.Now().UnixNano())
+				}
 			}
-			crController.IsContainerUnavailable.Store(false)
 		}
 
 	}
@@ -214,9 +221,13 @@ func stateRegressionRecovery() {
 	log.Warn().Uint64("last_canary", lastCanary).
 		Msg("State regression detected (canary rolled back): backend restored from older checkpoint")
 	crController.IsContainerUnavailable.Store(true)
-	n := crController.ReplayBufferedRequests()
+	n, compacted := crController.ReplayBufferedRequests()
 	crController.IsContainerUnavailable.Store(false)
-	log.Warn().Int("replayed", n).Msg("State regression recovery: buffered requests queued for replay")
+	if config.GetRampWindow() > 0 {
+		crController.LastTrafficRelease.Store(time.Now().UnixNano())
+	}
+	log.Warn().Int("replayed", n).Int("compacted", compacted).
+		Msg("State regression recovery: buffered requests queued for replay")
 }
 
 func canaryGet(appURL string) (uint64, bool, error) {
@@ -0,0 +1,43 @@
+package heartbeat
+
+import (
+	"testing"
+	"time"
+
+	"interceptor-grpc/crController"
+)
+
+// Com RAMP_WINDOW a graça de flush conta a partir do fim do ramp.
+func TestInFlushGraceExtendedByRamp(t *testing.T) {
+	previous := crController.LastTrafficRelease.Load()
+	defer crController.LastTrafficRelease.Store(previous)
+	releasedAgo := func(d time.Duration) {
+		crController.LastTrafficRelease.Store(time.Now().Add(-d).UnixNano())
+	}
+
+	crController.LastTrafficRelease.Store(0)
+	if inFlushGrace() {
+		t.Error("grace without any release")
+	}
+	releasedAgo(30 * time.Second)
+	if !inFlushGrace() {
+		t.Error("no grace 30s after release")
+	}
+	releasedAgo(90 * time.Second)
+	if inFlushGrace() {
+		t.Error("grace 90s after release without ramp")
+	}
+
+	t.Setenv("RAMP_WINDOW", "120")
+	if !inFlushGrace() {
+		t.Error("no grace 90s after release during a 120s ramp")
+	}
+	releasedAgo(170 * time.Second)
+	if !inFlushGrace() {
+		t.Error("no grace 50s after the ramp ended")
+	}
+	releasedAgo(190 * time.Second)
+	if inFlushGrace() {
+		t.Error("grace 70s after the ramp ended")
+	}
+}
@@ -0,0 +1,272 @@
+package interceptor
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+	"interceptor-grpc/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+var adaptiveDecreasesTotal = metrics.NewCounter("interceptor_adaptive_limit_decreases_total",
+	"Times the adaptive concurrency limit backed off on a latency spike, 5xx or transport failure")
+var adaptiveRejectedTotal = metrics.NewCounter("interceptor_adaptive_limit_rejected_total",
+	"Live requests that gave up waiting for an adaptive concurrency slot")
+
+// Janela do mínimo móvel de latência: a referência de "saudável" acompanha a
+// aplicação em vez de ficar presa a um mínimo de horas atrás.
+const minLatencyWindow = 30 * time.Second
+
+// Folga absoluta sobre a tolerância: com latência base de 1ms, 2ms ainda não
+// é pico.
+const latencySpikeFloor = 5 * time.Millisecond
+
+// adaptiveLimiter é um AIMD sobre os requests simultâneos à aplicação durante
+// a drenagem da fila e a janela pós-reabertura (substitui o drainSlots fixo
+// de 32). Cresce +1 por "volta" (1/limite por resposta) enquanto a latência
+// fica abaixo de ADAPTIVE_LATENCY_TOLERANCE x a mínima recente e o limite
+// está em uso; pico de latência, 5xx ou falha de transporte multiplicam por
+// ADAPTIVE_BACKOFF_RATIO, no máximo uma vez por latência base, pra uma rajada
+// de respostas lentas do mesmo episódio não derrubar o limite ao piso.
+//
+// Quem espera entra numa fila FIFO e recebe o slot na mão quando um libera
+// (sem corrida entre acordados). A fila de recuperação (priority) é sempre
+// atendida antes do tráfego ao vivo: senão os handlers estacionados, que
+// são muitos, ganhariam todo slot e o replay ficaria parado.
+type adaptiveLimiter struct {
+	mutex        sync.Mutex
+	limit        float64
+	inFlight     int
+	waiters      [2]*list.List
+	minLatency   [2]time.Duration
+	windowStart  time.Time
+	lastDecrease time.Time
+}
+
+// Índices de adaptiveLimiter.waiters, em ordem de atendimento.
+const (
+	priorityWaiters = iota
+	liveWaiters
+)
+
+type limiterWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+var upstreamLimiter = newAdaptiveLimiter()
+
+func newAdaptiveLimiter() *adaptiveLimiter {
+	return &adaptiveLimiter{waiters: [2]*list.List{list.New(), list.New()}}
+}
+
+func (l *adaptiveLimiter) currentLimit() float64 {
+	if l.limit == 0 {
+		l.limit = float64(config.GetAdaptiveConcurrencyInitial())
+	}
+	return l.limit
+}
+
+// effectiveLimitLocked é o limite escalado pelo slow-start (ramp.go).
+func (l *adaptiveLimiter) effectiveLimitLocked() float64 {
+	limit := l.currentLimit()
+	if fraction, _ := rampProgress(time.Now()); fraction < 1 {
+		return max(limit*fraction, float64(config.GetAdaptiveConcurrencyMin()))
+	}
+	return limit
+}
+
+// canTakeLocked: há slot livre e ninguém na frente (priority só respeita os
+// outros priority; ao vivo respeita todo mundo).
+func (l *adaptiveLimiter) canTakeLocked(priority bool) bool {
+	if float64(l.inFlight) >= l.effectiveLimitLocked() {
+		return false
+	}
+	if l.waiters[priorityWaiters].Len() > 0 {
+		return false
+	}
+	return priority || l.waiters[liveWaiters].Len() == 0
+}
+
+// tryAcquire pega um slot do tráfego ao vivo sem esperar.
+func (l *adaptiveLimiter) tryAcquire() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.canTakeLocked(false) {
+		return false
+	}
+	l.inFlight++
+	return true
+}
+
+// acquire espera um slot até until (zero = sem prazo) ou o ctx acabar.
+// priority é a fila de recuperação, atendida antes do tráfego ao vivo.
+func (l *adaptiveLimiter) acquire(ctx context.Context, until time.Time, priority bool) bool {
+	l.mutex.Lock()
+	if l.canTakeLocked(priority) {
+		l.inFlight++
+		l.mutex.Unlock()
+		return true
+	}
+	queue := l.waiters[liveWaiters]
+	if priority {
+		queue = l.waiters[priorityWaiters]
+	}
+	waiter := &limiterWaiter{ready: make(chan struct{})}
+	elem := queue.PushBack(waiter)
+	l.mutex.Unlock()
+
+	var timeout <-chan time.Time
+	if !until.IsZero() {
+		timer := time.NewTimer(time.Until(until))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	// Reavalia periodicamente: durante o ramp o teto sobe sem release.
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-waiter.ready:
+			return true
+		case <-ticker.C:
+			l.mutex.Lock()
+			l.grantLocked()
+			l.mutex.Unlock()
+			continue
+		case <-timeout:
+		case <-ctx.Done():
+		}
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		if waiter.granted {
+			// O slot chegou junto com a desistência: devolve pro próximo.
+			l.inFlight--
+			l.grantLocked()
+		} else {
+			queue.Remove(elem)
+		}
+		return false
+	}
+}
+
+// grantLocked entrega os slots livres aos primeiros da fila, priority antes.
+func (l *adaptiveLimiter) grantLocked() {
+	for float64(l.inFlight) < l.effectiveLimitLocked() {
+		queue := l.waiters[priorityWaiters]
+		if queue.Len() == 0 {
+			queue = l.waiters[liveWaiters]
+		}
+		front := queue.Front()
+		if front == nil {
+			return
+		}
+		queue.Remove(front)
+		waiter := front.Value.(*limiterWaiter)
+		waiter.granted = true
+		l.inFlight++
+		close(waiter.ready)
+	}
+}
+
+// cancel devolve um slot sem amostra (nada foi enviado).
+func (l *adaptiveLimiter) cancel() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.inFlight--
+	l.grantLocked()
+}
+
+// release devolve o slot e ajusta o limite pela resposta.
+func (l *adaptiveLimiter) release(res config.Result) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.inFlight--
+	l.sampleLocked(res, true)
+	l.grantLocked()
+}
+
+// observe só alimenta a latência de referência, com tráfego fora do limite.
+func (l *adaptiveLimiter) observe(res config.Result) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.sampleLocked(res, false)
+}
+
+func (l *adaptiveLimiter) baselineLocked() time.Duration {
+	a, b := l.minLatency[0], l.minLatency[1]
+	switch {
+	case a == 0:
+		return b
+	case b == 0 || a < b:
+		return a
+	}
+	return b
+}
+
+func (l *adaptiveLimiter) sampleLocked(res config.Result, adjust bool) {
+	now := time.Now()
+	if now.Sub(l.windowStart) > minLatencyWindow {
+		l.minLatency[1], l.minLatency[0] = l.minLatency[0], 0
+		l.windowStart = now
+	}
+	failed := breakerFailureCodes[res.ErrorCode] || res.Status >= 500
+	baseline := l.baselineLocked()
+	spike := baseline > 0 &&
+		res.Upstream > time.Duration(float64(baseline)*config.GetAdaptiveLatencyTolerance())+latencySpikeFloor
+	if !failed && res.ErrorCode == "" && (l.minLatency[0] == 0 || res.Upstream < l.minLatency[0]) {
+		l.minLatency[0] = res.Upstream
+	}
+	if !adjust {
+		return
+	}
+
+	limit := l.currentLimit()
+	if failed || spike {
+		if now.Sub(l.lastDecrease) < max(baseline, 100*time.Millisecond) {
+			return
+		}
+		l.lastDecrease = now
+		l.limit = max(limit*config.GetAdaptiveBackoffRatio(), float64(config.GetAdaptiveConcurrencyMin()))
+		adaptiveDecreasesTotal.Inc()
+		log.Debug().Float64("limit", l.limit).Bool("failed", failed).Dur("latency", res.Upstream).
+			Dur("baseline", baseline).Msg("Adaptive concurrency limit decreased")
+		return
+	}
+	// Só cresce com o limite em uso; ocioso não prova nada sobre a aplicação.
+	if float64(l.inFlight+1) >= limit/2 {
+		l.limit = min(limit+1/limit, float64(config.GetAdaptiveConcurrencyMax()))
+	}
+}
+
+// liveTrafficLimited: tráfego ao vivo passa pelo limite enquanto a fila
+// drena e por ADAPTIVE_LIVE_WINDOW (ou o ramp, se mais longo) após o gate
+// reabrir — é quando todo handler estacionado volta de uma vez.
+func liveTrafficLimited() bool {
+	if crController.IsRunningPendingRequestQueue.Load() {
+		return true
+	}
+	released := crController.LastTrafficRelease.Load()
+	window := time.Duration(max(config.GetAdaptiveLiveWindow(), config.GetRampWindow())) * time.Second
+	return released > 0 && time.Since(time.Unix(0, released)) < window
+}
+
+func init() {
+	metrics.NewGaugeFunc("interceptor_adaptive_limit", "Current adaptive concurrency limit",
+		func() float64 {
+			upstreamLimiter.mutex.Lock()
+			defer upstreamLimiter.mutex.Unlock()
+			return upstreamLimiter.currentLimit()
+		})
+	metrics.NewGaugeFunc("interceptor_adaptive_in_flight", "Requests holding an adaptive concurrency slot",
+		func() float64 {
+			upstreamLimiter.mutex.Lock()
+			defer upstreamLimiter.mutex.Unlock()
+			return float64(upstreamLimiter.inFlight)
+		})
+}
@@ -0,0 +1,145 @@
+package interceptor
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"interceptor-grpc/config"
+)
+
+func okResult(latency time.Duration) config.Result {
+	return config.Result{Status: http.StatusOK, Upstream: latency}
+}
+
+func TestAdaptiveLimiterCapsConcurrency(t *testing.T) {
+	t.Setenv("ADAPTIVE_CONCURRENCY_INITIAL", "2")
+	l := newAdaptiveLimiter()
+	if !l.tryAcquire() || !l.tryAcquire() {
+		t.Fatal("slots under the limit refused")
+	}
+	if l.tryAcquire() {
+		t.Fatal("slot above the limit granted")
+	}
+	l.cancel()
+	if !l.tryAcquire() {
+		t.Fatal("slot returned by cancel not reusable")
+	}
+	if l.acquire(context.Background(), time.Now().Add(20*time.Millisecond), true) {
+		t.Fatal("acquire past until succeeded")
+	}
+	if l.waiters[priorityWaiters].Len() != 0 || l.inFlight != 2 {
+		t.Errorf("after timeout: %d waiters, %d in flight", l.waiters[priorityWaiters].Len(), l.inFlight)
+	}
+}
+
+func TestAdaptiveLimiterIncreasesWhileHealthy(t *testing.T) {
+	t.Setenv("ADAPTIVE_CONCURRENCY_INITIAL", "4")
+	t.Setenv("ADAPTIVE_CONCURRENCY_MAX", "5")
+	l := newAdaptiveLimiter()
+	for i := 0; i < 40; i++ {
+		// Três em voo: mais da metade do limite em uso.
+		l.tryAcquire()
+		l.tryAcquire()
+		l.tryAcquire()
+		l.release(okResult(10 * time.Millisecond))
+		l.release(okResult(10 * time.Millisecond))
+		l.release(okResult(10 * time.Millisecond))
+	}
+	if l.limit != 5 {
+		t.Errorf("limit %v, want capped at ADAPTIVE_CONCURRENCY_MAX", l.limit)
+	}
+
+	// Ocioso (um em voo de 32) não prova nada: não cresce.
+	t.Setenv("ADAPTIVE_CONCURRENCY_INITIAL", "32")
+	idle := newAdaptiveLimiter()
+	for i := 0; i < 10; i++ {
+		idle.tryAcquire()
+		idle.release(okResult(10 * time.Millisecond))
+	}
+	if idle.limit != 32 {
+		t.Errorf("idle limit %v, want 32", idle.limit)
+	}
+}
+
+func TestAdaptiveLimiterBacksOff(t *testing.T) {
+	t.Setenv("ADAPTIVE_CONCURRENCY_INITIAL", "20")
+	t.Setenv("ADAPTIVE_CONCURRENCY_MIN", "15")
+	t.Setenv("ADAPTIVE_BACKOFF_RATIO", "0.5")
+	l := newAdaptiveLimiter()
+	l.tryAcquire()
+	l.release(okResult(10 * time.Millisecond))
+	before := l.limit
+
+	// Pico: acima de 2x a mínima (10ms) mais a folga de 5ms.
+	l.tryAcquire()
+	l.release(okResult(40 * time.Millisecond))
+	if l.limit != 15 {
+		t.Fatalf("limit %v after a spike from %v, want the floor 15", l.limit, before)
+	}
+
+	// Mesmo episódio: a segunda falha logo em seguida não reduz de novo.
+	t.Setenv("ADAPTIVE_CONCURRENCY_MIN", "1")
+	l.tryAcquire()
+	l.release(config.Result{Status: http.StatusServiceUnavailable, Upstream: time.Millisecond})
+	if l.limit != 15 {
+		t.Errorf("limit %v, decreased twice in one episode", l.limit)
+	}
+
+	l.lastDecrease = time.Now().Add(-time.Second)
+	l.tryAcquire()
+	l.release(config.Result{ErrorCode: errUpstreamUnavailable, NotDelivered: true})
+	if l.limit != 7.5 {
+		t.Errorf("limit %v after a transport failure, want 7.5", l.limit)
+	}
+}
+
+func TestAdaptiveLimiterServesPriorityFirst(t *testing.T) {
+	t.Setenv("ADAPTIVE_CONCURRENCY_INITIAL", "1")
+	l := newAdaptiveLimiter()
+	if !l.tryAcquire() {
+		t.Fatal("first slot refused")
+	}
+
+	granted := make(chan string, 3)
+	waitFor := func(name string, priority bool, queued int) {
+		go func() {
+			if l.acquire(context.Background(), time.Time{}, priority) {
+				granted <- name
+			}
+		}()
+		// Garante a ordem de chegada na fila.
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			l.mutex.Lock()
+			n := l.waiters[priorityWaiters].Len() + l.waiters[liveWaiters].Len()
+			l.mutex.Unlock()
+			if n == queued {
+				return
+			}
+		}
+		t.Fatalf("%s never queued", name)
+	}
+	waitFor("live1", false, 1)
+	waitFor("live2", false, 2)
+	waitFor("prio1", true, 3)
+
+	// Com gente na fila, ninguém fura por fora.
+	if l.tryAcquire() {
+		t.Fatal("tryAcquire jumped the queue")
+	}
+	var order []string
+	for i := 0; i < 3; i++ {
+		// Sem amostra: o limite não cresce e cada devolução libera um só.
+		l.cancel()
+		select {
+		case name := <-granted:
+			order = append(order, name)
+		case <-time.After(time.Second):
+			t.Fatalf("no waiter granted after release %d (order %v)", i, order)
+		}
+	}
+	if order[0] != "prio1" || order[1] != "live1" || order[2] != "live2" {
+		t.Errorf("grant order %v, want the recovery queue first, then FIFO", order)
+	}
+}
@@ -0,0 +1,422 @@
+package interceptor
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+	"interceptor-grpc/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Modo assíncrono: uma escrita que chega com o gate fechado e pede (Prefer:
+// respond-async ou rota em ASYNC_ROUTES) recebe 202 na hora com a URL de
+// status em Location, em vez de segurar a conexão até queueWaitTimeout. O
+// worker da fila aplica o request normalmente; a resposta fica no
+// asyncResults pra consulta e, se pedido, vai por webhook.
+const (
+	preferHeader        = "Prefer"
+	asyncCallbackHeader = "Async-Callback-Url"
+	respondAsync        = "respond-async"
+)
+
+var asyncAcceptedTotal = metrics.NewCounter("interceptor_async_accepted_total",
+	"Writes answered with 202 Accepted while the gate was closed")
+var asyncEvictedTotal = metrics.NewCounter("interceptor_async_evicted_total",
+	"Async results dropped from the result store before expiring because it was full")
+var asyncCallbacksDelivered = metrics.NewCounter("interceptor_async_callbacks_total",
+	"Async completion webhooks, by outcome", "result", "delivered")
+var asyncCallbacksFailed = metrics.NewCounter("interceptor_async_callbacks_total",
+	"Async completion webhooks, by outcome", "result", "failed")
+
+var asyncRoutes = sync.OnceValue(func() []routePattern {
+	var routes []routePattern
+	for _, spec := range config.GetAsyncRoutes() {
+		pattern, ok := parseRoutePattern(spec)
+		if !ok {
+			log.Warn().Str("route", spec).Msg("Invalid ASYNC_ROUTES entry ignored")
+			continue
+		}
+		routes = append(routes, pattern)
+	}
+	return routes
+})
+
+// wantsAsync: só escritas (leitura não tem o que aplicar depois) e nunca
+// upgrade, que precisa da conexão viva.
+func wantsAsync(r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || isUpgradeRequest(r) {
+		return false
+	}
+	if prefersAsync(r.Header) {
+		return true
+	}
+	for _, route := range asyncRoutes() {
+		if route.matches(r.Method, r.URL.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+func prefersAsync(h http.Header) bool {
+	for _, value := range h.Values(preferHeader) {
+		for _, preference := range strings.Split(value, ",") {
+			token, _, _ := strings.Cut(preference, ";")
+			if strings.EqualFold(strings.TrimSpace(token), respondAsync) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeRespondAsync tira só a preferência respond-async, mantendo as demais.
+func removeRespondAsync(h http.Header) {
+	var kept []string
+	for _, value := range h.Values(preferHeader) {
+		for _, preference := range strings.Split(value, ",") {
+			token, _, _ := strings.Cut(preference, ";")
+			if !strings.EqualFold(strings.TrimSpace(token), respondAsync) && strings.TrimSpace(preference) != "" {
+				kept = append(kept, strings.TrimSpace(preference))
+			}
+		}
+	}
+	h.Del(preferHeader)
+	if len(kept) > 0 {
+		h.Set(preferHeader, strings.Join(kept, ", "))
+	}
+}
+
+// gateClosed cobre tanto o spin-gate (snapshot/restore/container) quanto a
+// fila de recuperação.
+func gateClosed() bool {
+	return crController.IsDoingSnapshot.Load() || crController.IsRestoringSnapshot.Load() ||
+		crController.IsUnavailable()
+}
+
+// asyncCallbackURL valida o webhook pedido pelo cliente contra
+// ASYNC_CALLBACK_ALLOWED_HOSTS; sem a allowlist o interceptor faria POST pra
+// qualquer endereço que o cliente escolhesse (inclusive internos).
+func asyncCallbackURL(r *http.Request) (string, bool) {
+	raw := r.Header.Get(asyncCallbackHeader)
+	if raw == "" {
+		return "", true
+	}
+	target, err := url.Parse(raw)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+		return "", false
+	}
+	host := strings.ToLower(target.Hostname())
+	for _, allowed := range config.GetAsyncCallbackAllowedHosts() {
+		if host == allowed || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return target.String(), true
+		}
+	}
+	return "", false
+}
+
+type asyncEntry struct {
+	id            string
+	acceptedAt    time.Time
+	done          bool
+	completedAt   time.Time
+	result        config.Result
+	bodyTruncated bool
+	bytes         int64
+	elem          *list.Element
+}
+
+// Custo fixo aproximado de uma entrada, pra que as ainda na fila contem.
+const asyncEntryOverhead = 256
+
+// asyncStore guarda até ASYNC_RESULT_MAX resultados, somando no máximo
+// ASYNC_RESULT_MAX_BYTES, por ASYNC_RESULT_TTL a partir do aceite. Cheio, o
+// mais antigo sai — mesmo se ainda na fila: a escrita é aplicada igual, só o
+// status deixa de ser consultável.
+type asyncStore struct {
+	mutex   sync.Mutex
+	entries map[string]*asyncEntry
+	order   *list.List
+	bytes   int64
+}
+
+var asyncResults = &asyncStore{entries: map[string]*asyncEntry{}, order: list.New()}
+
+func (s *asyncStore) pruneLocked(now time.Time) {
+	ttl := time.Duration(config.GetAsyncResultTTL()) * time.Second
+	for front := s.order.Front(); front != nil; front = s.order.Front() {
+		entry := front.Value.(*asyncEntry)
+		if now.Sub(entry.acceptedAt) < ttl {
+			return
+		}
+		s.removeLocked(entry)
+	}
+}
+
+func (s *asyncStore) removeLocked(entry *asyncEntry) {
+	s.order.Remove(entry.elem)
+	delete(s.entries, entry.id)
+	s.bytes -= entry.bytes
+}
+
+// evictLocked tira os mais antigos (menos keep) até caberem mais incoming
+// entradas e extra bytes.
+func (s *asyncStore) evictLocked(incoming int, extra int64, keep *asyncEntry) {
+	maxEntries, maxBytes := config.GetAsyncResultMax(), config.GetAsyncResultMaxBytes()
+	for e := s.order.Front(); e != nil; {
+		if s.order.Len()+incoming <= maxEntries && s.bytes+extra <= maxBytes {
+			return
+		}
+		next := e.Next()
+		if entry := e.Value.(*asyncEntry); entry != keep {
+			s.removeLocked(entry)
+			asyncEvictedTotal.Inc()
+		}
+		e = next
+	}
+}
+
+func (s *asyncStore) add() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	entry := &asyncEntry{id: hex.EncodeToString(b), acceptedAt: time.Now(), bytes: asyncEntryOverhead}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pruneLocked(entry.acceptedAt)
+	s.evictLocked(1, entry.bytes, nil)
+	entry.elem = s.order.PushBack(entry)
+	s.entries[entry.id] = entry
+	s.bytes += entry.bytes
+	return entry.id
+}
+
+// complete guarda o resultado; corpo acima de ASYNC_RESULT_MAX_BODY (ou
+// stream) fica de fora pra memória do store ser limitada.
+func (s *asyncStore) complete(id string, res config.Result) {
+	maxBody := config.GetAsyncResultMaxBody()
+	truncated := false
+	if res.Stream != nil {
+		body, err := io.ReadAll(io.LimitReader(res.Stream, int64(maxBody)+1))
+		_ = res.Stream.Close()
+		res.Stream, res.Body = nil, body
+		if err != nil {
+			res.Body, truncated = nil, true
+		}
+	}
+	if len(res.Body) > maxBody {
+		res.Body, truncated = nil, true
+	}
+	size := int64(asyncEntryOverhead + len(res.Body))
+	for name, values := range res.Header {
+		size += int64(len(name))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		return
+	}
+	entry.done, entry.completedAt = true, time.Now()
+	entry.result, entry.bodyTruncated = res, truncated
+	s.bytes += size - entry.bytes
+	entry.bytes = size
+	s.evictLocked(0, 0, entry)
+}
+
+func (s *asyncStore) get(id string) (asyncEntry, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pruneLocked(time.Now())
+	entry, ok := s.entries[id]
+	if !ok {
+		return asyncEntry{}, false
+	}
+	return *entry, true
+}
+
+func (s *asyncStore) len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.order.Len()
+}
+
+func init() {
+	metrics.NewGaugeFunc("interceptor_async_results", "Async results held in the result store",
+		func() float64 { return float64(asyncResults.len()) })
+	metrics.NewGaugeFunc("interceptor_async_result_bytes", "Estimated memory held by the async result store",
+		func() float64 {
+			asyncResults.mutex.Lock()
+			defer asyncResults.mutex.Unlock()
+			return float64(asyncResults.bytes)
+		})
+}
+
+type asyncStatus struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	StatusURL      string `json:"status_url"`
+	AcceptedAt     string `json:"accepted_at"`
+	CompletedAt    string `json:"completed_at,omitempty"`
+	ResponseStatus int    `json:"response_status,omitempty"`
+	ErrorCode      string `json:"error_code,omitempty"`
+}
+
+// acceptAsync enfileira a escrita sem prazo (é aplicada mesmo sem ninguém
+// esperando) e responde 202.
+func acceptAsync(w http.ResponseWriter, r *http.Request, data config.RequestData, callbackURL string) {
+	id := asyncResults.add()
+	statusPath := config.GetAsyncStatusPrefix() + id
+	statusURL := (&url.URL{Scheme: data.Scheme, Host: data.Host, Path: statusPath}).String()
+	correlationID := r.Header.Get(config.GetCorrelationIDHeader())
+
+	// Os pedidos ao interceptor não seguem pra aplicação.
+	data.Header.Del(asyncCallbackHeader)
+	removeRespondAsync(data.Header)
+	data.Deadline = time.Time{}
+	// Sem goroutine por escrita aceita: o worker da fila chama o OnResult.
+	// Só o webhook, que é I/O, ganha goroutine — e só na conclusão.
+	onResult := func(res config.Result) {
+		asyncResults.complete(id, res)
+		if callbackURL != "" {
+			go sendAsyncCallback(callbackURL, id, statusURL, correlationID)
+		}
+	}
+	AddRequestToQueue(QueueHttpRequest{Data: data, OnResult: onResult, ClientKey: fairnessKey(r)})
+	asyncAcceptedTotal.Inc()
+
+	if prefersAsync(r.Header) {
+		w.Header().Set("Preference-Applied", respondAsync)
+	}
+	w.Header().Set("Location", statusPath)
+	w.Header().Set("Retry-After", "1")
+	writeAsyncStatus(w, http.StatusAccepted, asyncStatus{
+		ID:         id,
+		Status:     "queued",
+		StatusURL:  statusURL,
+		AcceptedAt: time.Now().UTC().Format(time.RFC3339Nano),
+	})
+}
+
+func writeAsyncStatus(w http.ResponseWriter, status int, body asyncStatus) {
+	encoded, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if _, err := w.Write(append(encoded, '\n')); err != nil {
+		log.Err(err).Msg("Error writing async status")
+	}
+}
+
+// AsyncStatusHandler serve ASYNC_STATUS_PREFIX<id>: 202 enquanto na fila,
+// depois a resposta que a aplicação deu à escrita. O id (128 bits
+// aleatórios) é a credencial.
+func AsyncStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, config.GetAsyncStatusPrefix())
+	entry, ok := asyncResults.get(id)
+	if !ok {
+		writeError(w, r, errAsyncNotFound, false)
+		return
+	}
+	if !entry.done {
+		w.Header().Set("Retry-After", "1")
+		writeAsyncStatus(w, http.StatusAccepted, asyncStatus{
+			ID:         entry.id,
+			Status:     "queued",
+			StatusURL:  r.URL.Path,
+			AcceptedAt: entry.acceptedAt.UTC().Format(time.RFC3339Nano),
+		})
+		return
+	}
+	w.Header().Set("Async-Completed-At", entry.completedAt.UTC().Format(time.RFC3339Nano))
+	if entry.bodyTruncated {
+		w.Header().Set("Async-Body-Omitted", "true")
+	}
+	writeResult(w, r, entry.result)
+}
+
+var asyncCallbackClient = &http.Client{
+	Timeout: 10 * time.Second,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// Tentativas do webhook, com backoff dobrando a partir de 1s.
+const asyncCallbackAttempts = 3
+
+// sendAsyncCallback avisa que a escrita foi aplicada; o resultado completo
+// fica na URL de status (o webhook não carrega o corpo da resposta).
+func sendAsyncCallback(callbackURL, id, statusURL, correlationID string) {
+	entry, ok := asyncResults.get(id)
+	if !ok {
+		return
+	}
+	body, _ := json.Marshal(asyncStatus{
+		ID:             id,
+		Status:         "done",
+		StatusURL:      statusURL,
+		AcceptedAt:     entry.acceptedAt.UTC().Format(time.RFC3339Nano),
+		CompletedAt:    entry.completedAt.UTC().Format(time.RFC3339Nano),
+		ResponseStatus: entry.result.Status,
+		ErrorCode:      entry.result.ErrorCode,
+	})
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := postAsyncCallback(callbackURL, body, correlationID)
+		if err == nil {
+			asyncCallbacksDelivered.Inc()
+			return
+		}
+		if attempt >= asyncCallbackAttempts {
+			asyncCallbacksFailed.Inc()
+			log.Warn().Err(err).Str("id", id).Str("callback", callbackURL).Msg("Async callback failed")
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func postAsyncCallback(callbackURL string, body []byte, correlationID string) error {
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if correlationID != "" {
+		req.Header.Set(config.GetCorrelationIDHeader(), correlationID)
+	}
+	resp, err := asyncCallbackClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("callback answered %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package interceptor
+
+import (
+	"container/list"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"interceptor-grpc/config"
+)
+
+func newAsyncStore() *asyncStore {
+	return &asyncStore{entries: map[string]*asyncEntry{}, order: list.New()}
+}
+
+func TestPrefersAsync(t *testing.T) {
+	h := http.Header{}
+	h.Add(preferHeader, "return=minimal")
+	h.Add(preferHeader, "wait=10, Respond-Async ; foo=bar")
+	if !prefersAsync(h) {
+		t.Fatal("respond-async not found")
+	}
+	removeRespondAsync(h)
+	if prefersAsync(h) {
+		t.Error("respond-async kept")
+	}
+	if got := h.Get(preferHeader); got != "return=minimal, wait=10" {
+		t.Errorf("other preferences = %q", got)
+	}
+
+	only := http.Header{preferHeader: {"respond-async"}}
+	removeRespondAsync(only)
+	if _, ok := only[preferHeader]; ok {
+		t.Error("empty Prefer header left behind")
+	}
+}
+
+func TestAsyncCallbackURL(t *testing.T) {
+	t.Setenv("ASYNC_CALLBACK_ALLOWED_HOSTS", "hooks.example.com, *.internal.example")
+	cases := map[string]bool{
+		"":                                      true,
+		"https://hooks.example.com/done":        true,
+		"http://a.b.internal.example:8080/done": true,
+		"https://HOOKS.example.com/x":           true,
+		"https://evil.example.com/done":         false,
+		"https://internal.example.evil.com/":    false,
+		"ftp://hooks.example.com/done":          false,
+		"https:///done":                         false,
+	}
+	for raw, want := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		if raw != "" {
+			r.Header.Set(asyncCallbackHeader, raw)
+		}
+		if _, ok := asyncCallbackURL(r); ok != want {
+			t.Errorf("asyncCallbackURL(%q) = %v, want %v", raw, ok, want)
+		}
+	}
+
+	t.Setenv("ASYNC_CALLBACK_ALLOWED_HOSTS", "")
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set(asyncCallbackHeader, "https://hooks.example.com/done")
+	if _, ok := asyncCallbackURL(r); ok {
+		t.Error("callback accepted without an allowlist")
+	}
+}
+
+func TestAsyncStoreEvictsOldest(t *testing.T) {
+	t.Setenv("ASYNC_RESULT_MAX", "2")
+	s := newAsyncStore()
+	first, second, third := s.add(), s.add(), s.add()
+	if _, ok := s.get(first); ok {
+		t.Error("oldest entry kept past ASYNC_RESULT_MAX")
+	}
+	for _, id := range []string{second, third} {
+		if entry, ok := s.get(id); !ok || entry.done {
+			t.Errorf("entry %s: present %v done %v", id, ok, entry.done)
+		}
+	}
+	if s.bytes != 2*asyncEntryOverhead {
+		t.Errorf("bytes %d, want two queued entries", s.bytes)
+	}
+}
+
+func TestAsyncStoreByteCeiling(t *testing.T) {
+	t.Setenv("ASYNC_RESULT_MAX_BYTES", "2000")
+	t.Setenv("ASYNC_RESULT_MAX_BODY", "1500")
+	s := newAsyncStore()
+	older, newer := s.add(), s.add()
+
+	// Corpo acima de ASYNC_RESULT_MAX_BODY não é guardado.
+	s.complete(older, config.Result{Status: http.StatusOK, Body: make([]byte, 1600)})
+	entry, _ := s.get(older)
+	if !entry.bodyTruncated || entry.result.Body != nil || entry.bytes != asyncEntryOverhead {
+		t.Errorf("oversized body: truncated %v, %d bytes kept", entry.bodyTruncated, len(entry.result.Body))
+	}
+
+	// O resultado que acabou de chegar fica; os mais antigos saem pra ele caber.
+	s.complete(newer, config.Result{Status: http.StatusCreated, Body: make([]byte, 1500)})
+	if _, ok := s.get(older); ok {
+		t.Error("older entry kept past ASYNC_RESULT_MAX_BYTES")
+	}
+	entry, ok := s.get(newer)
+	if !ok || !entry.done || len(entry.result.Body) != 1500 {
+		t.Fatalf("completed entry: present %v done %v", ok, entry.done)
+	}
+	if s.bytes != entry.bytes || s.bytes > 2000 {
+		t.Errorf("store bytes %d, entry bytes %d", s.bytes, entry.bytes)
+	}
+
+	// Stream é lido até o limite e fechado.
+	id := s.add()
+	stream := &closeRecorder{Reader: strings.NewReader("streamed")}
+	s.complete(id, config.Result{Status: http.StatusOK, Stream: stream})
+	if entry, _ := s.get(id); string(entry.result.Body) != "streamed" || !stream.closed {
+		t.Errorf("stream: body %q closed %v", entry.result.Body, stream.closed)
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestAsyncStoreExpires(t *testing.T) {
+	t.Setenv("ASYNC_RESULT_TTL", "1")
+	s := newAsyncStore()
+	id := s.add()
+	s.entries[id].acceptedAt = time.Now().Add(-2 * time.Second)
+	if _, ok := s.get(id); ok {
+		t.Error("entry served past ASYNC_RESULT_TTL")
+	}
+	if s.len() != 0 || s.bytes != 0 {
+		t.Errorf("expired entry still accounted: %d entries, %d bytes", s.len(), s.bytes)
+	}
+	// Conclusão de uma entrada que já saiu é ignorada.
+	s.complete(id, config.Result{Status: http.StatusOK})
+	if s.len() != 0 {
+		t.Error("expired entry recreated")
+	}
+}
+
+func TestAsyncStatusHandler(t *testing.T) {
+	id := asyncResults.add()
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		AsyncStatusHandler(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if w := get("/_async/" + id); w.Code != http.StatusAccepted || w.Header().Get("Retry-After") == "" {
+		t.Errorf("queued: %d %v", w.Code, w.Header())
+	}
+	asyncResults.complete(id, config.Result{
+		Status: http.StatusCreated,
+		Header: http.Header{"Location": {"/orders/1"}},
+		Body:   []byte("created"),
+	})
+	w := get("/_async/" + id)
+	if w.Code != http.StatusCreated || w.Body.String() != "created" ||
+		w.Header().Get("Location") != "/orders/1" || w.Header().Get("Async-Completed-At") == "" {
+		t.Errorf("done: %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	if w := get("/_async/unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown id: %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	AsyncStatusHandler(w, httptest.NewRequest(http.MethodDelete, "/_async/"+id, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE: %d", w.Code)
+	}
+}
@@ -0,0 +1,89 @@
+package interceptor
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/tlsutil"
+
+	"github.com/rs/zerolog/log"
+)
+
+type clientCertHeader struct {
+	field  string
+	header string
+}
+
+var clientCertFields = map[string]func(cert *x509.Certificate, verified bool) string{
+	"subject": func(cert *x509.Certificate, _ bool) string { return cert.Subject.String() },
+	"issuer":  func(cert *x509.Certificate, _ bool) string { return cert.Issuer.String() },
+	"serial":  func(cert *x509.Certificate, _ bool) string { return cert.SerialNumber.Text(16) },
+	"fingerprint": func(cert *x509.Certificate, _ bool) string {
+		sum := sha256.Sum256(cert.Raw)
+		return hex.EncodeToString(sum[:])
+	},
+	"spiffe": func(cert *x509.Certificate, _ bool) string { return tlsutil.SpiffeID(cert) },
+	"dns":    func(cert *x509.Certificate, _ bool) string { return strings.Join(cert.DNSNames, ",") },
+	// PEM com escape de URL, igual ao $ssl_client_escaped_cert do nginx.
+	"cert": func(cert *x509.Certificate, _ bool) string {
+		return url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+	},
+	"verified": func(_ *x509.Certificate, verified bool) string { return strconv.FormatBool(verified) },
+}
+
+// Só vale quando o próprio interceptor verifica certificado de cliente
+// (LISTENER_TLS_CLIENT_CA_FILE); senão quem termina TLS antes dele é que
+// define esses headers e eles passam intocados.
+var clientCertHeaders = sync.OnceValue(loadClientCertHeaders)
+
+func loadClientCertHeaders() []clientCertHeader {
+	if config.GetListenerTLSClientCAFile() == "" {
+		return nil
+	}
+	var headers []clientCertHeader
+	for _, mapping := range config.GetClientCertHeaders() {
+		field, header, ok := strings.Cut(mapping, "=")
+		if !ok || clientCertFields[field] == nil || header == "" {
+			log.Warn().Str("mapping", mapping).Msg("Invalid CLIENT_CERT_HEADERS entry ignored")
+			continue
+		}
+		headers = append(headers, clientCertHeader{field: field, header: http.CanonicalHeaderKey(header)})
+	}
+	return headers
+}
+
+// applyClientCertHeaders remove do request os headers de certificado vindos
+// do cliente (forjáveis) e os preenche com o certificado da conexão TLS.
+func applyClientCertHeaders(r *http.Request) {
+	headers := clientCertHeaders()
+	if len(headers) == 0 {
+		return
+	}
+	for _, h := range headers {
+		r.Header.Del(h.header)
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return
+	}
+	cert := r.TLS.PeerCertificates[0]
+	verified := len(r.TLS.VerifiedChains) > 0
+	for _, h := range headers {
+		// Com LISTENER_TLS_CLIENT_AUTH=request o cliente pode apresentar
+		// qualquer certificado: sem cadeia verificada, nenhum campo de
+		// identidade vai pra aplicação, só o "verified" (false).
+		if !verified && h.field != "verified" {
+			continue
+		}
+		if value := clientCertFields[h.field](cert, verified); value != "" {
+			r.Header.Set(h.header, value)
+		}
+	}
+}
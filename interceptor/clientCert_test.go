@@ -0,0 +1,91 @@
+package interceptor
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// reloadClientCertHeaders relê CLIENT_CERT_HEADERS do ambiente do teste.
+func reloadClientCertHeaders(t *testing.T) {
+	clientCertHeaders = sync.OnceValue(loadClientCertHeaders)
+	t.Cleanup(func() { clientCertHeaders = sync.OnceValue(loadClientCertHeaders) })
+}
+
+func certRequest(verified bool) *http.Request {
+	id, _ := url.Parse("spiffe://cluster.local/ns/default/sa/app")
+	cert := &x509.Certificate{
+		Raw:          []byte("der"),
+		Subject:      pkix.Name{CommonName: "app"},
+		SerialNumber: big.NewInt(255),
+		URIs:         []*url.URL{id},
+	}
+	r := httptest.NewRequest(http.MethodGet, "https://app.example/", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if verified {
+		r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	// Forjados pelo cliente.
+	r.Header.Set("X-Client-Cert-Subject", "CN=admin")
+	r.Header.Set("X-Client-Verified", "true")
+	r.Header.Set("X-Other", "kept")
+	return r
+}
+
+func TestApplyClientCertHeaders(t *testing.T) {
+	t.Setenv("LISTENER_TLS_CLIENT_CA_FILE", "/etc/tls/client-ca.pem")
+	t.Setenv("CLIENT_CERT_HEADERS", "subject=X-Client-Cert-Subject,serial=X-Client-Serial,spiffe=X-Client-Spiffe,verified=X-Client-Verified")
+	reloadClientCertHeaders(t)
+
+	r := certRequest(true)
+	applyClientCertHeaders(r)
+	want := http.Header{
+		"X-Client-Cert-Subject": {"CN=app"},
+		"X-Client-Serial":       {"ff"},
+		"X-Client-Spiffe":       {"spiffe://cluster.local/ns/default/sa/app"},
+		"X-Client-Verified":     {"true"},
+		"X-Other":               {"kept"},
+	}
+	if !reflect.DeepEqual(r.Header, want) {
+		t.Errorf("verified chain: %v", r.Header)
+	}
+
+	// Sem cadeia verificada (LISTENER_TLS_CLIENT_AUTH=request) só sai o
+	// verified=false; os forjados somem do mesmo jeito.
+	r = certRequest(false)
+	applyClientCertHeaders(r)
+	want = http.Header{"X-Client-Verified": {"false"}, "X-Other": {"kept"}}
+	if !reflect.DeepEqual(r.Header, want) {
+		t.Errorf("unverified certificate: %v", r.Header)
+	}
+
+	// Sem TLS: nada a preencher, mas nada forjado passa.
+	r = certRequest(false)
+	r.TLS = nil
+	applyClientCertHeaders(r)
+	if want = (http.Header{"X-Other": {"kept"}}); !reflect.DeepEqual(r.Header, want) {
+		t.Errorf("plain connection: %v", r.Header)
+	}
+}
+
+// Sem LISTENER_TLS_CLIENT_CA_FILE quem termina o TLS antes do interceptor é
+// que define os headers: passam intocados.
+func TestApplyClientCertHeadersWithoutClientCA(t *testing.T) {
+	t.Setenv("LISTENER_TLS_CLIENT_CA_FILE", "")
+	t.Setenv("CLIENT_CERT_HEADERS", "subject=X-Client-Cert-Subject,verified=X-Client-Verified")
+	reloadClientCertHeaders(t)
+
+	r := certRequest(true)
+	before := r.Header.Clone()
+	applyClientCertHeaders(r)
+	if !reflect.DeepEqual(r.Header, before) {
+		t.Errorf("headers changed: %v", r.Header)
+	}
+}
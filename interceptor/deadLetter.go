@@ -0,0 +1,321 @@
+package interceptor
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+	"interceptor-grpc/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+var replayRetriesTotal = metrics.NewCounter("interceptor_replay_retries_total",
+	"Replayed requests re-sent after the application answered with a transient error")
+var deadLetteredPermanent = metrics.NewCounter("interceptor_dead_letter_total",
+	"Replayed requests moved to the dead-letter store, by reason", "reason", "permanent")
+var deadLetteredExhausted = metrics.NewCounter("interceptor_dead_letter_total",
+	"Replayed requests moved to the dead-letter store, by reason", "reason", "retries_exhausted")
+var deadLetterRedrivenTotal = metrics.NewCounter("interceptor_dead_letter_redriven_total",
+	"Dead-lettered requests sent back to the recovery queue")
+var deadLetterPurgedTotal = metrics.NewCounter("interceptor_dead_letter_purged_total",
+	"Dead-lettered requests purged through the admin API")
+var deadLetterDroppedTotal = metrics.NewCounter("interceptor_dead_letter_dropped_total",
+	"Dead-lettered requests dropped because the store was full")
+
+// Teto do backoff entre tentativas de replay.
+const maxReplayBackoff = 30 * time.Second
+
+// Trecho da resposta da aplicação guardado com a entrada, pro diagnóstico.
+const deadLetterBodySnippet = 512
+
+// transientStatus: a aplicação pode aceitar o mesmo request mais tarde.
+func transientStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+		return false
+	}
+	return status >= 500
+}
+
+// handleReplayResult inspeciona o resultado de um replay (RespCh == nil, sem
+// cliente esperando): antes ele era descartado e uma recusa da aplicação
+// perdia a escrita. Transitório é refeito no lugar com backoff (retryReplay);
+// permanente, ou transitório após REPLAY_RETRY_MAX, vai pro dead-letter. A
+// entrada sai do buffer de reprocess na primeira recusa — quem a guarda agora
+// é o retry ou o dead-letter, senão o próximo restore a repetiria por fora —
+// mas o número dela é mantido: a aplicação vê o mesmo Interceptor-Controller
+// em todas as tentativas (e no redrive), e a escrita aceita volta ao buffer
+// sob esse número.
+func handleReplayResult(item QueueHttpRequest, res config.Result) {
+	for {
+		if res.ErrorCode == "" && res.Status < 400 {
+			discardResult(res)
+			if item.RequestNumber != 0 {
+				config.RebufferProcessedRequest(item.RequestNumber, item.Data)
+			}
+			return
+		}
+		snippet := res.Body
+		if res.Stream != nil {
+			discardResult(res)
+			snippet = nil
+		}
+		if len(snippet) > deadLetterBodySnippet {
+			snippet = snippet[:deadLetterBodySnippet]
+		}
+		if res.RequestNumber != 0 {
+			config.RemoveRequestFromBuffer(res.RequestNumber)
+			item.RequestNumber = res.RequestNumber
+		}
+
+		transient := res.ErrorCode != "" || transientStatus(res.Status)
+		if !transient || item.ReplayAttempt >= config.GetReplayRetryMax() {
+			if transient {
+				deadLetteredExhausted.Inc()
+			} else {
+				deadLetteredPermanent.Inc()
+			}
+			entry := deadLetters.add(item, res, string(snippet))
+			log.Error().Uint64("dead_letter", entry).Int("status", res.Status).Str("code", res.ErrorCode).
+				Int("attempts", item.ReplayAttempt+1).Str("method", item.Data.Method).Str("path", item.Data.Path).
+				Msg("Replay rejected by the application, moved to the dead-letter store")
+			return
+		}
+
+		backoff := time.Duration(config.GetReplayRetryBackoffMs()) * time.Millisecond << item.ReplayAttempt
+		if backoff <= 0 || backoff > maxReplayBackoff {
+			backoff = maxReplayBackoff
+		}
+		replayRetriesTotal.Inc()
+		log.Warn().Int("status", res.Status).Str("code", res.ErrorCode).Int("attempt", item.ReplayAttempt+1).
+			Dur("backoff", backoff).Str("path", item.Data.Path).Msg("Replay rejected with a transient error, retrying")
+		item.ReplayAttempt++
+		var sent bool
+		if res, sent = retryReplay(item, backoff); !sent {
+			return
+		}
+	}
+}
+
+// retryReplay refaz um replay recusado no lugar, com o despacho de replay
+// pausado: uma entrada posterior da mesma chave não pode ser aplicada antes
+// dele (e depois sobrescrita com o estado velho). Durante o backoff sai de
+// InFlightRequests pra não segurar um snapshot; se o gate fechar nesse meio
+// tempo o item volta pro início da fila de replay e sent=false. Chamado pelo
+// worker do ProcessQueue, dentro do InFlightRequests dele.
+func retryReplay(item QueueHttpRequest, backoff time.Duration) (res config.Result, sent bool) {
+	holdReplay()
+	defer resumeReplay()
+
+	crController.InFlightRequests.Done()
+	time.Sleep(backoff)
+	crController.InFlightRequests.Add(1)
+
+	closed := func() bool {
+		return crController.IsDoingSnapshot.Load() || crController.IsRestoringSnapshot.Load() ||
+			crController.IsContainerUnavailable.Load() || crController.UpstreamCircuitOpen.Load()
+	}
+	for !upstreamLimiter.acquire(context.Background(), time.Now().Add(50*time.Millisecond), true) {
+		if closed() {
+			requeueReplayFront(item)
+			return config.Result{}, false
+		}
+	}
+	if closed() {
+		upstreamLimiter.cancel()
+		requeueReplayFront(item)
+		return config.Result{}, false
+	}
+	res = sendWithRetry(item.Data, item.RequestNumber)
+	upstreamLimiter.release(res)
+	return res, true
+}
+
+type deadLetter struct {
+	ID            uint64    `json:"id"`
+	RequestNumber uint64    `json:"request_number,omitempty"`
+	Method        string    `json:"method"`
+	Path          string    `json:"path"`
+	Query         string    `json:"query,omitempty"`
+	Status        int       `json:"status,omitempty"`
+	ErrorCode     string    `json:"error_code,omitempty"`
+	ResponseBody  string    `json:"response_body,omitempty"`
+	Attempts      int       `json:"attempts"`
+	FailedAt      time.Time `json:"failed_at"`
+
+	data config.RequestData
+	elem *list.Element
+}
+
+// deadLetterStore guarda em memória, em ordem de chegada, os replays que a
+// aplicação recusou; até DEAD_LETTER_MAX, descartando o mais antigo. Fica só
+// em memória porque este interceptor não tem log durável onde gravá-lo: o
+// próprio buffer de reprocess é memória, e um restart do processo perde os
+// dois juntos.
+type deadLetterStore struct {
+	mutex   sync.Mutex
+	nextID  uint64
+	entries map[uint64]*deadLetter
+	order   *list.List
+}
+
+var deadLetters = &deadLetterStore{entries: map[uint64]*deadLetter{}, order: list.New()}
+
+func (s *deadLetterStore) add(item QueueHttpRequest, res config.Result, snippet string) uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for s.order.Len() >= config.GetDeadLetterMax() {
+		oldest := s.order.Front().Value.(*deadLetter)
+		s.removeLocked(oldest)
+		config.ReleaseBody(oldest.data)
+		deadLetterDroppedTotal.Inc()
+		log.Error().Uint64("dead_letter", oldest.ID).Str("path", oldest.Path).
+			Msg("Dead-letter store full, oldest entry dropped")
+	}
+	s.nextID++
+	entry := &deadLetter{
+		ID:            s.nextID,
+		RequestNumber: item.RequestNumber,
+		Method:        item.Data.Method,
+		Path:          item.Data.Path,
+		Query:         item.Data.Query,
+		Status:        res.Status,
+		ErrorCode:     res.ErrorCode,
+		ResponseBody:  snippet,
+		Attempts:      item.ReplayAttempt + 1,
+		FailedAt:      time.Now().UTC(),
+		data:          item.Data,
+	}
+	entry.elem = s.order.PushBack(entry)
+	s.entries[entry.ID] = entry
+	return entry.ID
+}
+
+func (s *deadLetterStore) removeLocked(entry *deadLetter) {
+	s.order.Remove(entry.elem)
+	delete(s.entries, entry.ID)
+}
+
+func (s *deadLetterStore) list() []deadLetter {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entries := make([]deadLetter, 0, s.order.Len())
+	for e := s.order.Front(); e != nil; e = e.Next() {
+		entries = append(entries, *e.Value.(*deadLetter))
+	}
+	return entries
+}
+
+// take remove e devolve as entradas pedidas (todas, com ids nil).
+func (s *deadLetterStore) take(ids []uint64) []*deadLetter {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var taken []*deadLetter
+	if ids == nil {
+		for e := s.order.Front(); e != nil; e = e.Next() {
+			taken = append(taken, e.Value.(*deadLetter))
+		}
+	} else {
+		for _, id := range ids {
+			if entry, ok := s.entries[id]; ok {
+				taken = append(taken, entry)
+			}
+		}
+	}
+	for _, entry := range taken {
+		s.removeLocked(entry)
+	}
+	return taken
+}
+
+func (s *deadLetterStore) len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.order.Len()
+}
+
+func init() {
+	metrics.NewGaugeFunc("interceptor_dead_letters", "Replayed requests held in the dead-letter store",
+		func() float64 { return float64(deadLetters.len()) })
+}
+
+// DeadLetterHandler é a API admin do dead-letter, sob /_internal/dead-letters:
+//
+//	GET    /_internal/dead-letters              lista (sem corpo do request)
+//	POST   /_internal/dead-letters/redrive      devolve todas à fila de replay
+//	POST   /_internal/dead-letters/{id}/redrive devolve uma
+//	DELETE /_internal/dead-letters              descarta todas
+//	DELETE /_internal/dead-letters/{id}         descarta uma
+func DeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/_internal/dead-letters"), "/")
+	parts := strings.Split(rest, "/")
+	if rest == "" {
+		parts = nil
+	}
+
+	var ids []uint64
+	if len(parts) > 0 && parts[0] != "redrive" {
+		id, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		ids = []uint64{id}
+		parts = parts[1:]
+	}
+
+	switch {
+	case r.Method == http.MethodGet && ids == nil && len(parts) == 0:
+		writeDeadLetterJSON(w, http.StatusOK, deadLetters.list())
+	case r.Method == http.MethodPost && len(parts) == 1 && parts[0] == "redrive":
+		taken := deadLetters.take(ids)
+		for _, entry := range taken {
+			// Com o número original: a aplicação pode deduplicar a escrita.
+			AddRequestToQueue(QueueHttpRequest{Data: entry.data, RequestNumber: entry.RequestNumber})
+		}
+		deadLetterRedrivenTotal.Add(int64(len(taken)))
+		log.Info().Int("count", len(taken)).Msg("Dead-lettered requests sent back to the recovery queue")
+		writeDeadLetterResult(w, ids, "redriven", len(taken))
+	case r.Method == http.MethodDelete && len(parts) == 0:
+		taken := deadLetters.take(ids)
+		for _, entry := range taken {
+			config.ReleaseBody(entry.data)
+		}
+		deadLetterPurgedTotal.Add(int64(len(taken)))
+		log.Warn().Int("count", len(taken)).Msg("Dead-lettered requests purged")
+		writeDeadLetterResult(w, ids, "purged", len(taken))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeDeadLetterResult(w http.ResponseWriter, ids []uint64, action string, count int) {
+	if ids != nil && count == 0 {
+		http.Error(w, "dead letter not found", http.StatusNotFound)
+		return
+	}
+	writeDeadLetterJSON(w, http.StatusOK, map[string]int{action: count})
+}
+
+func writeDeadLetterJSON(w http.ResponseWriter, status int, body any) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, "error encoding response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(append(encoded, '\n')); err != nil {
+		log.Err(err).Msg("Error writing dead-letter response")
+	}
+}
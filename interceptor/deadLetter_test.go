@@ -0,0 +1,230 @@
+package interceptor
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+)
+
+func resetDeadLetters() {
+	deadLetters = &deadLetterStore{entries: map[uint64]*deadLetter{}, order: list.New()}
+}
+
+func buffered(number uint64) *config.BufferedRequest {
+	for _, entry := range config.GetReprocessableRequests() {
+		if entry.RequestNumber == number {
+			return entry
+		}
+	}
+	return nil
+}
+
+// replayResult simula o worker do ProcessQueue: o item chega com o
+// InFlightRequests dele e o resultado do primeiro envio.
+func replayResult(item QueueHttpRequest, res config.Result) {
+	crController.InFlightRequests.Add(1)
+	handleReplayResult(item, res)
+	crController.InFlightRequests.Done()
+}
+
+func TestTransientStatus(t *testing.T) {
+	transient := []int{408, 425, 429, 500, 502, 503, 504}
+	permanent := []int{400, 401, 404, 409, 422, 501, 505}
+	for _, status := range transient {
+		if !transientStatus(status) {
+			t.Errorf("%d not transient", status)
+		}
+	}
+	for _, status := range permanent {
+		if transientStatus(status) {
+			t.Errorf("%d transient", status)
+		}
+	}
+}
+
+func TestReplayRejectedPermanently(t *testing.T) {
+	resetDeadLetters()
+	data := config.RequestData{Method: http.MethodPut, Path: "/items/1", Body: []byte("v")}
+	number := config.SaveRequestToBuffer(data)
+	config.UpdateRequestToProcessed(number)
+	before := deadLetteredPermanent.Value()
+
+	replayResult(QueueHttpRequest{Data: data},
+		config.Result{Status: http.StatusUnprocessableEntity, Body: []byte("bad"), RequestNumber: number})
+
+	if buffered(number) != nil {
+		t.Error("dead-lettered replay kept in the reprocess buffer")
+	}
+	entries := deadLetters.list()
+	if len(entries) != 1 || deadLetteredPermanent.Value()-before != 1 {
+		t.Fatalf("%d dead letters", len(entries))
+	}
+	entry := entries[0]
+	if entry.Status != http.StatusUnprocessableEntity || entry.ResponseBody != "bad" ||
+		entry.Attempts != 1 || entry.Path != "/items/1" || entry.RequestNumber != number {
+		t.Errorf("dead letter %+v", entry)
+	}
+
+	// Sucesso não vai pro dead-letter.
+	replayResult(QueueHttpRequest{Data: data}, config.Result{Status: http.StatusOK})
+	if deadLetters.len() != 1 {
+		t.Error("successful replay dead-lettered")
+	}
+}
+
+func TestReplayRetriedInPlace(t *testing.T) {
+	resetDeadLetters()
+	t.Setenv("UPSTREAM_BREAKER_THRESHOLD", "0")
+	t.Setenv("REPLAY_RETRY_BACKOFF_MS", "1")
+	t.Setenv("REPLAY_RETRY_MAX", "3")
+
+	var mutex sync.Mutex
+	var controllers []string
+	application := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		controllers = append(controllers, r.Header.Get("Interceptor-Controller"))
+		attempt := len(controllers)
+		mutex.Unlock()
+		if attempt == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer application.Close()
+	t.Setenv("APPLICATION_URL", application.URL)
+
+	data := config.RequestData{Method: http.MethodPut, Path: "/items/1", Body: []byte("v")}
+	number := config.SaveRequestToBuffer(data)
+	config.UpdateRequestToProcessed(number)
+	// Uma entrada posterior já na fila não pode passar na frente do retry.
+	AddToQueueForReprocess(config.RequestData{Method: http.MethodPut, Path: "/items/1", Body: []byte("w")})
+	retriesBefore := replayRetriesTotal.Value()
+
+	replayResult(QueueHttpRequest{Data: data},
+		config.Result{Status: http.StatusServiceUnavailable, RequestNumber: number})
+
+	if retries := replayRetriesTotal.Value() - retriesBefore; retries != 2 {
+		t.Errorf("%d retries, want 2", retries)
+	}
+	want := strconv.FormatUint(number, 10)
+	if len(controllers) != 2 || controllers[0] != want || controllers[1] != want {
+		t.Errorf("retries sent Interceptor-Controller %q, want the original %s", controllers, want)
+	}
+	if deadLetters.len() != 0 {
+		t.Errorf("%d dead letters after a successful retry", deadLetters.len())
+	}
+	// Aceita, volta ao buffer sob o número original pra um restore futuro.
+	if entry := buffered(number); entry == nil || entry.State != config.Processed {
+		t.Errorf("accepted retry not back in the buffer as Processed: %+v", entry)
+	}
+	config.RemoveRequestFromBuffer(number)
+	if held := classQueues[classReplay].held; held != 0 {
+		t.Errorf("replay dispatch still held (%d)", held)
+	}
+	if items := drainQueue(t); len(items) != 1 || string(items[0].Data.Body) != "w" {
+		t.Errorf("queue after retry: %+v", items)
+	}
+}
+
+func TestReplayRetryRequeuedWhenGateCloses(t *testing.T) {
+	resetDeadLetters()
+	t.Setenv("REPLAY_RETRY_BACKOFF_MS", "1")
+	t.Setenv("REPLAY_RETRY_MAX", "3")
+
+	data := config.RequestData{Method: http.MethodPut, Path: "/items/2", Body: []byte("v")}
+	number := config.SaveRequestToBuffer(data)
+	AddToQueueForReprocess(config.RequestData{Method: http.MethodPut, Path: "/items/2", Body: []byte("w")})
+
+	crController.IsContainerUnavailable.Store(true)
+	replayResult(QueueHttpRequest{Data: data},
+		config.Result{ErrorCode: errUpstreamUnavailable, NotDelivered: true, RequestNumber: number})
+	crController.IsContainerUnavailable.Store(false)
+
+	// Volta pro início da fila, com o número original e a tentativa contada.
+	items := drainQueue(t)
+	if len(items) != 2 || string(items[0].Data.Body) != "v" || string(items[1].Data.Body) != "w" {
+		t.Fatalf("queue after gate closed: %+v", items)
+	}
+	if items[0].RequestNumber != number || items[0].ReplayAttempt != 1 {
+		t.Errorf("requeued number %d attempt %d, want %d and 1", items[0].RequestNumber,
+			items[0].ReplayAttempt, number)
+	}
+	if upstreamLimiter.inFlight != 0 || classQueues[classReplay].held != 0 {
+		t.Errorf("limiter in flight %d, replay held %d", upstreamLimiter.inFlight, classQueues[classReplay].held)
+	}
+}
+
+func TestReplayRetriesExhausted(t *testing.T) {
+	resetDeadLetters()
+	t.Setenv("REPLAY_RETRY_MAX", "0")
+	before := deadLetteredExhausted.Value()
+	data := config.RequestData{Method: http.MethodPost, Path: "/orders"}
+	replayResult(QueueHttpRequest{Data: data}, config.Result{Status: http.StatusTooManyRequests})
+	if deadLetters.len() != 1 || deadLetteredExhausted.Value()-before != 1 {
+		t.Errorf("%d dead letters after exhausting retries", deadLetters.len())
+	}
+}
+
+func TestDeadLetterStoreCapacity(t *testing.T) {
+	resetDeadLetters()
+	t.Setenv("DEAD_LETTER_MAX", "2")
+	for _, path := range []string{"/a", "/b", "/c"} {
+		deadLetters.add(QueueHttpRequest{Data: config.RequestData{Path: path}}, config.Result{Status: 400}, "")
+	}
+	entries := deadLetters.list()
+	if len(entries) != 2 || entries[0].Path != "/b" || entries[1].Path != "/c" {
+		t.Errorf("entries %+v, want the two newest", entries)
+	}
+}
+
+func TestDeadLetterHandler(t *testing.T) {
+	resetDeadLetters()
+	for i, path := range []string{"/a", "/b", "/c"} {
+		deadLetters.add(QueueHttpRequest{Data: config.RequestData{Method: http.MethodPut, Path: path},
+			RequestNumber: uint64(100 + i)}, config.Result{Status: 409}, "conflict")
+	}
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		DeadLetterHandler(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := serve(http.MethodGet, "/_internal/dead-letters")
+	var listed []deadLetter
+	if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil || w.Code != http.StatusOK || len(listed) != 3 {
+		t.Fatalf("list: %d %s", w.Code, w.Body.String())
+	}
+
+	w = serve(http.MethodPost, "/_internal/dead-letters/"+strconv.FormatUint(listed[1].ID, 10)+"/redrive")
+	if w.Code != http.StatusOK {
+		t.Fatalf("redrive one: %d %s", w.Code, w.Body.String())
+	}
+	// Volta com o número original: mesmo Interceptor-Controller pra aplicação.
+	if items := drainQueue(t); len(items) != 1 || items[0].Data.Path != "/b" || items[0].live() ||
+		items[0].RequestNumber != 101 {
+		t.Errorf("redriven items %+v", items)
+	}
+
+	if w := serve(http.MethodDelete, "/_internal/dead-letters/"+strconv.FormatUint(listed[1].ID, 10)); w.Code != http.StatusNotFound {
+		t.Errorf("purge of a redriven id: %d", w.Code)
+	}
+	if w := serve(http.MethodGet, "/_internal/dead-letters/abc"); w.Code != http.StatusNotFound {
+		t.Errorf("non-numeric id: %d", w.Code)
+	}
+	if w := serve(http.MethodPut, "/_internal/dead-letters"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT: %d", w.Code)
+	}
+
+	w = serve(http.MethodDelete, "/_internal/dead-letters")
+	if w.Code != http.StatusOK || w.Body.String() != "{\"purged\":2}\n" || deadLetters.len() != 0 {
+		t.Errorf("purge all: %d %s, %d left", w.Code, w.Body.String(), deadLetters.len())
+	}
+}
@@ -0,0 +1,138 @@
+package interceptor
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"interceptor-grpc/config"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Headers de prazo aceitos do cliente e repassados à aplicação com o que
+// sobrou do orçamento.
+const (
+	requestTimeoutHeader = "X-Request-Timeout"
+	grpcTimeoutHeader    = "Grpc-Timeout"
+)
+
+type routeTimeout struct {
+	routePattern
+	timeout time.Duration
+}
+
+var routeTimeouts = sync.OnceValue(func() []routeTimeout {
+	var routes []routeTimeout
+	for _, spec := range config.GetUpstreamRouteTimeouts() {
+		route, value, ok := strings.Cut(spec, "=")
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		pattern, valid := parseRoutePattern(route)
+		if !ok || err != nil || timeout <= 0 || !valid {
+			log.Warn().Str("route", spec).Msg("Invalid UPSTREAM_ROUTE_TIMEOUTS entry ignored")
+			continue
+		}
+		routes = append(routes, routeTimeout{routePattern: pattern, timeout: timeout})
+	}
+	return routes
+})
+
+// upstreamTimeout devolve o timeout do envio pra rota (0 = sem timeout).
+func upstreamTimeout(method, path string) time.Duration {
+	for _, rt := range routeTimeouts() {
+		if rt.matches(method, path) {
+			return rt.timeout
+		}
+	}
+	return time.Duration(config.GetUpstreamTimeout()) * time.Second
+}
+
+// clientDeadline lê o prazo do cliente (X-Request-Timeout em segundos ou
+// duração Go; grpc-timeout no formato do gRPC) contado a partir de start —
+// o tempo no gate e na fila sai do mesmo orçamento.
+func clientDeadline(r *http.Request, start time.Time) time.Time {
+	if value := r.Header.Get(requestTimeoutHeader); value != "" {
+		if timeout, ok := parseRequestTimeout(value); ok {
+			return start.Add(timeout)
+		}
+	}
+	if value := r.Header.Get(grpcTimeoutHeader); value != "" {
+		if timeout, ok := parseGrpcTimeout(value); ok {
+			return start.Add(timeout)
+		}
+	}
+	return time.Time{}
+}
+
+// maxTimeoutSeconds é o maior prazo que cabe num time.Duration: acima disso
+// a conversão estoura pra negativo e viraria 504 imediato.
+const maxTimeoutSeconds = float64(math.MaxInt64 / int64(time.Second))
+
+func parseRequestTimeout(value string) (time.Duration, bool) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		// NaN falha nas duas comparações; Inf passa do teto.
+		if !(seconds > 0 && seconds <= maxTimeoutSeconds) {
+			return 0, false
+		}
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	timeout, err := time.ParseDuration(value)
+	return timeout, err == nil && timeout > 0
+}
+
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// parseGrpcTimeout: até 8 dígitos seguidos da unidade (ex.: "250m").
+func parseGrpcTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, false
+	}
+	unit, ok := grpcTimeoutUnits[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64/int64(unit) {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// effectiveDeadline combina o prazo do cliente com o timeout da rota.
+func effectiveDeadline(data config.RequestData, now time.Time) time.Time {
+	deadline := data.Deadline
+	if timeout := upstreamTimeout(data.Method, data.Path); timeout > 0 {
+		if routeDeadline := now.Add(timeout); deadline.IsZero() || routeDeadline.Before(deadline) {
+			deadline = routeDeadline
+		}
+	}
+	return deadline
+}
+
+// setDeadlineHeaders repassa à aplicação o orçamento restante, no mesmo
+// formato que o cliente usou (grpc-timeout) e sempre em X-Request-Timeout.
+func setDeadlineHeaders(h http.Header, deadline time.Time) {
+	remaining := time.Until(deadline)
+	if remaining < time.Millisecond {
+		remaining = time.Millisecond
+	}
+	h.Set(requestTimeoutHeader, strconv.FormatFloat(remaining.Seconds(), 'f', 3, 64))
+	if h.Get(grpcTimeoutHeader) != "" {
+		// O formato do gRPC aceita no máximo 8 dígitos.
+		if ms := remaining.Milliseconds(); ms <= 99999999 {
+			h.Set(grpcTimeoutHeader, strconv.FormatInt(ms, 10)+"m")
+		} else {
+			h.Set(grpcTimeoutHeader, strconv.FormatInt(int64(remaining.Seconds()), 10)+"S")
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package interceptor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"interceptor-grpc/config"
+)
+
+func TestParseRequestTimeout(t *testing.T) {
+	valid := map[string]time.Duration{
+		"5":     5 * time.Second,
+		"0.25":  250 * time.Millisecond,
+		"1m30s": 90 * time.Second,
+		"750ms": 750 * time.Millisecond,
+	}
+	for value, want := range valid {
+		if got, ok := parseRequestTimeout(value); !ok || got != want {
+			t.Errorf("parseRequestTimeout(%q) = %v, %v; want %v", value, got, ok, want)
+		}
+	}
+	// Inf, NaN e valores que estouram o time.Duration também.
+	for _, value := range []string{"0", "-1", "-2s", "soon", "", "Inf", "+Inf", "NaN", "1e300", "9300000000"} {
+		if _, ok := parseRequestTimeout(value); ok {
+			t.Errorf("parseRequestTimeout(%q) accepted", value)
+		}
+	}
+}
+
+func TestParseGrpcTimeout(t *testing.T) {
+	valid := map[string]time.Duration{
+		"1H":        time.Hour,
+		"2M":        2 * time.Minute,
+		"3S":        3 * time.Second,
+		"250m":      250 * time.Millisecond,
+		"100u":      100 * time.Microsecond,
+		"99999999n": 99999999 * time.Nanosecond,
+	}
+	for value, want := range valid {
+		if got, ok := parseGrpcTimeout(value); !ok || got != want {
+			t.Errorf("parseGrpcTimeout(%q) = %v, %v; want %v", value, got, ok, want)
+		}
+	}
+	// Mais de 8 dígitos, sem unidade, unidade desconhecida, zero, estouro.
+	for _, value := range []string{"123456789S", "100", "10s", "0m", "m", "-5S", "99999999H"} {
+		if _, ok := parseGrpcTimeout(value); ok {
+			t.Errorf("parseGrpcTimeout(%q) accepted", value)
+		}
+	}
+}
+
+func TestClientDeadline(t *testing.T) {
+	start := time.Now()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if got := clientDeadline(r, start); !got.IsZero() {
+		t.Errorf("no header: deadline %v", got)
+	}
+	r.Header.Set(grpcTimeoutHeader, "2S")
+	if got := clientDeadline(r, start); !got.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("grpc-timeout: deadline %v", got.Sub(start))
+	}
+	// X-Request-Timeout tem precedência; inválido cai no grpc-timeout.
+	r.Header.Set(requestTimeoutHeader, "1.5")
+	if got := clientDeadline(r, start); !got.Equal(start.Add(1500 * time.Millisecond)) {
+		t.Errorf("X-Request-Timeout: deadline %v", got.Sub(start))
+	}
+	r.Header.Set(requestTimeoutHeader, "later")
+	if got := clientDeadline(r, start); !got.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("invalid X-Request-Timeout: deadline %v", got.Sub(start))
+	}
+}
+
+func TestEffectiveDeadline(t *testing.T) {
+	now := time.Now()
+	t.Setenv("UPSTREAM_TIMEOUT", "10")
+	data := config.RequestData{Method: http.MethodPost, Path: "/orders"}
+	if got := effectiveDeadline(data, now); !got.Equal(now.Add(10 * time.Second)) {
+		t.Errorf("route timeout only: %v", got.Sub(now))
+	}
+	// Vale o menor dos dois.
+	data.Deadline = now.Add(3 * time.Second)
+	if got := effectiveDeadline(data, now); !got.Equal(data.Deadline) {
+		t.Errorf("client deadline earlier: %v", got.Sub(now))
+	}
+	data.Deadline = now.Add(time.Minute)
+	if got := effectiveDeadline(data, now); !got.Equal(now.Add(10 * time.Second)) {
+		t.Errorf("route timeout earlier: %v", got.Sub(now))
+	}
+	t.Setenv("UPSTREAM_TIMEOUT", "0")
+	if got := effectiveDeadline(data, now); !got.Equal(data.Deadline) {
+		t.Errorf("no route timeout: %v", got.Sub(now))
+	}
+}
+
+func TestSetDeadlineHeaders(t *testing.T) {
+	h := http.Header{}
+	setDeadlineHeaders(h, time.Now().Add(2*time.Second))
+	seconds, err := strconv.ParseFloat(h.Get(requestTimeoutHeader), 64)
+	if err != nil || seconds <= 1.9 || seconds > 2 {
+		t.Errorf("X-Request-Timeout = %q", h.Get(requestTimeoutHeader))
+	}
+	if h.Get(grpcTimeoutHeader) != "" {
+		t.Error("grpc-timeout added for a client that did not send it")
+	}
+
+	// O formato de quem mandou é mantido; prazo vencido ainda leva 1ms.
+	h.Set(grpcTimeoutHeader, "5S")
+	setDeadlineHeaders(h, time.Now().Add(-time.Second))
+	if h.Get(grpcTimeoutHeader) != "1m" || h.Get(requestTimeoutHeader) != "0.001" {
+		t.Errorf("expired: grpc-timeout %q, X-Request-Timeout %q",
+			h.Get(grpcTimeoutHeader), h.Get(requestTimeoutHeader))
+	}
+	setDeadlineHeaders(h, time.Now().Add(30*time.Hour))
+	if got := h.Get(grpcTimeoutHeader); got[len(got)-1] != 'S' {
+		t.Errorf("long deadline: grpc-timeout %q, want seconds", got)
+	}
+}
@@ -0,0 +1,153 @@
+package interceptor
+
+import (
+	"container/list"
+	"net"
+	"net/http"
+	"strings"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/tlsutil"
+)
+
+// fairQueue é a fila de uma classe: uma subfila FIFO por cliente, atendidas
+// por deficit round-robin — cada cliente ganha QUEUE_FAIRNESS_QUANTUM_KB de
+// crédito por volta e gasta o tamanho do corpo (mínimo 1 KiB) por request.
+// Um cliente barulhento não passa na frente dos outros e a ordem de cada
+// cliente é mantida. Com chave vazia há uma única subfila: FIFO puro.
+//
+// Com serialize, um cliente com request em andamento (pop sem done) fica de
+// fora até ele terminar: a ordem de chegada na aplicação também é mantida.
+type fairQueue struct {
+	flows     map[string]*flow
+	active    *list.List
+	length    int
+	serialize bool
+	busy      map[string]bool
+}
+
+type flow struct {
+	key     string
+	items   []queuedRequest
+	deficit int64
+	elem    *list.Element
+}
+
+func newFairQueue(serialize bool) *fairQueue {
+	return &fairQueue{flows: map[string]*flow{}, active: list.New(), serialize: serialize, busy: map[string]bool{}}
+}
+
+func (q *fairQueue) push(key string, item queuedRequest) {
+	f, ok := q.flows[key]
+	if !ok {
+		f = &flow{key: key}
+		f.elem = q.active.PushBack(f)
+		q.flows[key] = f
+	}
+	f.items = append(f.items, item)
+	q.length++
+}
+
+// pushFront devolve um item ao início da subfila do cliente.
+func (q *fairQueue) pushFront(key string, item queuedRequest) {
+	f, ok := q.flows[key]
+	if !ok {
+		f = &flow{key: key}
+		f.elem = q.active.PushFront(f)
+		q.flows[key] = f
+	}
+	f.items = append([]queuedRequest{item}, f.items...)
+	q.length++
+}
+
+// blocked: cliente com request em andamento sob serialize. A chave vazia
+// (sem QUEUE_FAIRNESS_KEY) não identifica cliente e nunca bloqueia.
+func (q *fairQueue) blocked(key string) bool {
+	return q.serialize && key != "" && q.busy[key]
+}
+
+// ready diz se há item que pop pode devolver agora.
+func (q *fairQueue) ready() bool {
+	for e := q.active.Front(); e != nil; e = e.Next() {
+		if !q.blocked(e.Value.(*flow).key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *fairQueue) pop() (queuedRequest, bool) {
+	if q.length == 0 || !q.ready() {
+		return queuedRequest{}, false
+	}
+	quantum := int64(config.GetQueueFairnessQuantumKB()) << 10
+	for {
+		f := q.active.Front().Value.(*flow)
+		if q.blocked(f.key) {
+			// Fora da vez sem ganhar crédito; ready garante que há outro.
+			q.active.MoveToBack(f.elem)
+			continue
+		}
+		cost := requestCost(f.items[0].request.Data)
+		if f.deficit < cost {
+			// Vez encerrada: crédito da próxima volta e vai pro fim.
+			f.deficit += quantum
+			q.active.MoveToBack(f.elem)
+			continue
+		}
+		item := f.items[0]
+		f.items[0] = queuedRequest{}
+		f.items = f.items[1:]
+		f.deficit -= cost
+		q.length--
+		if len(f.items) == 0 {
+			// Cliente sem fila não acumula crédito.
+			q.active.Remove(f.elem)
+			delete(q.flows, f.key)
+		}
+		if q.serialize && f.key != "" {
+			q.busy[f.key] = true
+		}
+		return item, true
+	}
+}
+
+// done libera o cliente de um item devolvido por pop.
+func (q *fairQueue) done(key string) {
+	delete(q.busy, key)
+}
+
+func (q *fairQueue) len() int { return q.length }
+
+func (q *fairQueue) flowCount() int { return len(q.flows) }
+
+func requestCost(data config.RequestData) int64 {
+	return max(data.BodyLength(), 1<<10)
+}
+
+// fairnessKey identifica o cliente de um request ao vivo (QUEUE_FAIRNESS_KEY).
+// O replay nunca passa por aqui: a ordem global do buffer tem que ser mantida.
+func fairnessKey(r *http.Request) string {
+	spec := config.GetQueueFairnessKey()
+	switch {
+	case spec == "":
+		return ""
+	case spec == "subject":
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			cert := r.TLS.PeerCertificates[0]
+			if id := tlsutil.SpiffeID(cert); id != "" {
+				return id
+			}
+			return cert.Subject.String()
+		}
+	case strings.HasPrefix(spec, "header:"):
+		if value := r.Header.Get(strings.TrimPrefix(spec, "header:")); value != "" {
+			return value
+		}
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
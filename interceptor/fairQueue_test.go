@@ -0,0 +1,96 @@
+package interceptor
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"interceptor-grpc/config"
+)
+
+func item(name string, size int) queuedRequest {
+	return queuedRequest{request: QueueHttpRequest{Data: config.RequestData{Path: name, Body: make([]byte, size)}}}
+}
+
+func drain(q *fairQueue) string {
+	var order []string
+	for {
+		next, ok := q.pop()
+		if !ok {
+			return strings.Join(order, " ")
+		}
+		order = append(order, next.request.Data.Path)
+	}
+}
+
+func TestFairQueueSingleKeyIsFIFO(t *testing.T) {
+	q := newFairQueue(false)
+	for _, name := range []string{"r1", "r2", "r3"} {
+		q.push("", item(name, 1<<20))
+	}
+	if got := drain(q); got != "r1 r2 r3" {
+		t.Errorf("order %q", got)
+	}
+	if q.len() != 0 || q.flowCount() != 0 {
+		t.Errorf("len %d flows %d after drain", q.len(), q.flowCount())
+	}
+}
+
+func TestFairQueueDeficitRoundRobin(t *testing.T) {
+	t.Setenv("QUEUE_FAIRNESS_QUANTUM_KB", "4")
+	q := newFairQueue(false)
+	// Corpos pequenos custam o mínimo de 1 KiB: 4 por volta.
+	for i := 1; i <= 6; i++ {
+		q.push("noisy", item("a"+strconv.Itoa(i), 10))
+	}
+	q.push("quiet", item("b1", 10))
+	q.push("quiet", item("b2", 10))
+	if got := drain(q); got != "a1 a2 a3 a4 b1 b2 a5 a6" {
+		t.Errorf("order %q", got)
+	}
+
+	// Um corpo de 8 KiB precisa de duas voltas de crédito.
+	q.push("big", item("big", 8<<10))
+	q.push("small", item("s1", 0))
+	q.push("small", item("s2", 0))
+	if got := drain(q); got != "s1 s2 big" {
+		t.Errorf("order %q", got)
+	}
+}
+
+func TestFairQueueSerialize(t *testing.T) {
+	q := newFairQueue(true)
+	q.push("a", item("a1", 0))
+	q.push("a", item("a2", 0))
+	q.push("b", item("b1", 0))
+	q.push("", item("anon1", 0))
+	q.push("", item("anon2", 0))
+
+	var order []string
+	for {
+		next, ok := q.pop()
+		if !ok {
+			break
+		}
+		order = append(order, next.request.Data.Path)
+	}
+	// a2 espera a1 terminar; a chave vazia nunca bloqueia.
+	if got := strings.Join(order, " "); got != "a1 b1 anon1 anon2" {
+		t.Errorf("order %q", got)
+	}
+	if q.ready() {
+		t.Error("ready with only a blocked client left")
+	}
+	q.done("a")
+	if got := drain(q); got != "a2" {
+		t.Errorf("after done: %q", got)
+	}
+
+	// Sem serialize o mesmo cliente sai em sequência.
+	q = newFairQueue(false)
+	q.push("a", item("a1", 0))
+	q.push("a", item("a2", 0))
+	if got := drain(q); got != "a1 a2" {
+		t.Errorf("unserialized order %q", got)
+	}
+}
@@ -1,15 +1,17 @@
 package interceptor
 
 import (
-	"bytes"
-	"crypto/tls"
+	"context"
 	"errors"
 	"interceptor-grpc/config"
 	"interceptor-grpc/crController"
 	"io"
 	"net/http"
+	"net/http/httptrace"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -18,10 +20,6 @@ import (
 var lock = &sync.RWMutex{}
 var singleInstance *http.Client
 
-// drainSlots limita a concorrência da drenagem da fila (replay pós-recuperação
-// pode ter dezenas de milhares de entradas; sem limite inundaria a aplicação).
-var drainSlots = make(chan struct{}, 32)
-
 // Tempo máximo que um request enfileirado espera o ciclo de recuperação
 // (snapshot/restore + drenagem da fila). Igual ao timeout do spin-gate.
 const queueWaitTimeout = 5 * time.Minute
@@ -29,10 +27,37 @@ const queueWaitTimeout = 5 * time.Minute
 // QueueHttpRequest carrega uma CÓPIA do request (nunca o *http.Request ou o
 // ResponseWriter vivos, que morrem quando o handler retorna). RespCh != nil
 // significa que há um handler bloqueado esperando o resultado pra responder
-// ao cliente; nil significa replay-only (cliente já foi respondido).
+// ao cliente; OnResult != nil é uma escrita assíncrona (o worker entrega o
+// resultado ao store sem goroutine esperando por request); os dois nil
+// significam replay-only (cliente já foi respondido).
+//
+// ClientKey identifica o cliente pro enfileiramento justo (QUEUE_FAIRNESS_KEY);
+// vazio no replay. ReplayAttempt conta as novas tentativas de um replay
+// recusado e RequestNumber é o número original dele, já fora do buffer (ver
+// handleReplayResult).
 type QueueHttpRequest struct {
-	Data   config.RequestData
-	RespCh chan config.Result
+	Data          config.RequestData
+	RespCh        chan config.Result
+	OnResult      func(config.Result)
+	ClientKey     string
+	ReplayAttempt int
+	RequestNumber uint64
+}
+
+// live: há um cliente (síncrono ou assíncrono) esperando o resultado.
+func (q QueueHttpRequest) live() bool {
+	return q.RespCh != nil || q.OnResult != nil
+}
+
+// deliver entrega o resultado de um request ao vivo a quem o espera.
+func (q QueueHttpRequest) deliver(res config.Result) {
+	if q.OnResult != nil {
+		q.OnResult(res)
+		return
+	}
+	// Canal buffered(1): se o handler já desistiu (timeout/desconexão), o
+	// send não bloqueia e o handler descarta.
+	q.RespCh <- res
 }
 
 func ProcessQueue() {
@@ -51,38 +76,69 @@ func ProcessQueue() {
 			continue
 		}
 
-		// Skip if snapshot or restore is in progress
-		if crController.IsDoingSnapshot.Load() || crController.IsRestoringSnapshot.Load() {
+		// Skip if snapshot or restore is in progress, or the upstream circuit
+		// is open (the breaker probe closes it when the application answers)
+		if crController.IsDoingSnapshot.Load() || crController.IsRestoringSnapshot.Load() ||
+			crController.UpstreamCircuitOpen.Load() {
 			continue
 		}
 
 		// Drena a fila inteira, não 1 item por tick: após uma recuperação o
 		// replay pode enfileirar dezenas de milhares de entradas, e 1/50ms
-		// (20/s) não escala. Concorrência limitada por drainSlots pra não
-		// inundar a aplicação.
+		// (20/s) não escala. Concorrência limitada pelo limite adaptativo e
+		// por classe (QUEUE_CLASS_CONCURRENCY) pra não inundar a aplicação.
 		for QueueLength.Load() > 0 {
 			if crController.IsDoingSnapshot.Load() || crController.IsRestoringSnapshot.Load() ||
-				crController.IsContainerUnavailable.Load() {
+				crController.IsContainerUnavailable.Load() || crController.UpstreamCircuitOpen.Load() {
 				break
 			}
-			request, err := GetRequestFromQueue()
-			if err != nil {
-				break
+			// Espera curta: com o slot demorando, volta a checar o gate.
+			if !upstreamLimiter.acquire(context.Background(), time.Now().Add(50*time.Millisecond), true) {
+				continue
+			}
+			request, class, ok := takeFromQueue()
+			if !ok {
+				upstreamLimiter.cancel()
+				// Toda classe com item está no limite: espera um slot.
+				select {
+				case <-queueSlotReleased:
+				case <-time.After(50 * time.Millisecond):
+				}
+				continue
 			}
 			crController.IsRunningPendingRequestQueue.Store(true)
 
-			drainSlots <- struct{}{}
 			crController.InFlightRequests.Add(1)
 			go func(item QueueHttpRequest) {
 				defer func() {
-					<-drainSlots
+					releaseQueueSlot(class, item.ClientKey)
 					crController.InFlightRequests.Done()
 				}()
-				res := forwardBuffered(item.Data)
-				if item.RespCh != nil {
-					// Canal buffered(1): se o handler já desistiu (timeout/
-					// desconexão), o send não bloqueia e o resultado é descartado.
-					item.RespCh <- res
+				if deadline := item.Data.Deadline; item.live() && !deadline.IsZero() && time.Now().After(deadline) {
+					// Leitura cujo cliente já desistiu (escritas enfileiradas
+					// não têm prazo): não vale a ida à aplicação.
+					upstreamLimiter.cancel()
+					config.ReleaseBody(item.Data)
+					item.deliver(errorResult(errDeadlineExceeded, true))
+					return
+				}
+				var res config.Result
+				if item.RequestNumber != 0 {
+					// Replay recusado devolvido à fila: fora do buffer, vai
+					// com o número original.
+					res = sendWithRetry(item.Data, item.RequestNumber)
+				} else {
+					res = forwardBuffered(item.Data)
+				}
+				upstreamLimiter.release(res)
+				if item.live() {
+					if res.NotDelivered {
+						// Saiu do buffer e o cliente recebe o erro.
+						config.ReleaseBody(item.Data)
+					}
+					item.deliver(res)
+				} else {
+					handleReplayResult(item, res)
 				}
 			}(request)
 		}
@@ -92,55 +148,233 @@ func ProcessQueue() {
 func Handler(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 	timeout := 5 * time.Minute
+	applyClientCertHeaders(r)
+	ensureCorrelationID(r)
+	// Prazo do cliente: o tempo no gate e na fila desconta do orçamento.
+	deadline := clientDeadline(r, startTime)
 
-	for crController.IsDoingSnapshot.Load() ||
-		crController.IsRestoringSnapshot.Load() ||
-		crController.IsContainerUnavailable.Load() {
-		if time.Since(startTime) > timeout {
-			http.Error(w, "request timed out while waiting for container to be available", http.StatusBadGateway)
+	// Escrita que aceita resposta assíncrona não espera o gate: recebe 202
+	// e a URL de status (ver async.go).
+	async := wantsAsync(r) && gateClosed()
+	var callbackURL string
+	if async {
+		var ok bool
+		if callbackURL, ok = asyncCallbackURL(r); !ok {
+			writeError(w, r, errAsyncCallback, false)
 			return
 		}
-		time.Sleep(50 * time.Millisecond)
+	}
+
+	var timing requestTiming
+	// Spin-gate: segura enquanto há snapshot, restore ou indisponibilidade.
+	// false = já respondeu com erro (prazo do cliente ou teto do gate).
+	waitGate := func() bool {
+		for crController.IsDoingSnapshot.Load() ||
+			crController.IsRestoringSnapshot.Load() ||
+			crController.IsContainerUnavailable.Load() {
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				timing.gateWait = time.Since(startTime)
+				setStatusHeaders(w, &timing)
+				writeError(w, r, errDeadlineExceeded, false)
+				return false
+			}
+			if time.Since(startTime) > timeout {
+				timing.gateWait = time.Since(startTime)
+				setStatusHeaders(w, &timing)
+				writeError(w, r, errGateTimeout, false)
+				return false
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		timing.gateWait = time.Since(startTime)
+		return true
+	}
+	if !async && !waitGate() {
+		return
+	}
+
+	// Upgrade (WebSocket etc.) vira túnel: não há corpo pra copiar nem
+	// resposta pra bufferizar.
+	if isUpgradeRequest(r) {
+		handleUpgrade(w, r)
+		return
+	}
+
+	// DURABILITY_HARD_MODE: com o snapshot atrasado além de
+	// MAX_UNSNAPSHOTTED_AGE, uma escrita aceita agora pode sumir num restore
+	// sem ninguém saber; melhor recusar explicitamente.
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && crController.WritesRejected() {
+		w.Header().Set("Retry-After", strconv.Itoa(config.GetSnapshotRetryBase()))
+		http.Error(w, "writes rejected: snapshot durability degraded", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !async && config.GetRampExcess() == "shed" && !rampAdmit() {
+		rampShedTotal.Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(rampRetryAfter()))
+		timing.gateWait = time.Since(startTime)
+		setStatusHeaders(w, &timing)
+		writeError(w, r, errWarmingUp, false)
+		return
 	}
 
 	// Copia o request inteiro aqui: o *http.Request e o ResponseWriter só são
 	// válidos enquanto este handler está vivo, então nada fora desta função
 	// (fila, buffer de reprocess) pode segurá-los.
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "error reading request body", http.StatusInternalServerError)
-		return
+	if maxBytes := config.GetBodyMaxBytes(); maxBytes > 0 {
+		if r.ContentLength > maxBytes {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
 	}
 	data := config.RequestData{
-		Method: r.Method,
-		Path:   r.URL.Path,
-		Query:  r.URL.RawQuery,
-		Header: r.Header.Clone(),
-		Body:   body,
-	}
-
-	if crController.IsUnavailable() {
-		// Fila de recuperação: o handler fica bloqueado esperando o resultado
-		// pelo canal — é ele quem escreve a resposta, nunca o worker. Sem isso
-		// o net/http finaliza a resposta como 200 vazio assim que o handler
-		// retorna, e o worker escreveria num writer morto.
-		respCh := make(chan config.Result, 1)
-		AddRequestToQueue(QueueHttpRequest{Data: data, RespCh: respCh})
-		select {
-		case res := <-respCh:
-			writeResult(w, res)
-		case <-r.Context().Done():
-			// Cliente desconectou. O worker ainda aplica o request (estado);
-			// o canal buffered absorve o resultado sem bloquear ninguém.
-		case <-time.After(queueWaitTimeout):
-			http.Error(w, "timed out waiting for recovery queue", http.StatusGatewayTimeout)
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		RawPath:    r.URL.RawPath,
+		Query:      r.URL.RawQuery,
+		Host:       r.Host,
+		RemoteAddr: r.RemoteAddr,
+		Scheme:     "http",
+		Proto:      r.Proto,
+		Header:     r.Header.Clone(),
+		Deadline:   deadline,
+	}
+	if r.TLS != nil {
+		data.Scheme = "https"
+	}
+	if err := config.ReadRequestBody(&data, r.Body); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		case errors.Is(err, config.ErrSpillBudgetExceeded):
+			http.Error(w, "interceptor body spool is full", http.StatusInsufficientStorage)
+		default:
+			http.Error(w, "error reading request body", http.StatusInternalServerError)
 		}
 		return
 	}
 
+	if async {
+		timing.queued = true
+		setStatusHeaders(w, &timing)
+		acceptAsync(w, r, data, callbackURL)
+		return
+	}
+
+	// O limite adaptativo pode segurar o request por minutos: se o gate
+	// fechou nesse meio tempo (snapshot, restore, fila), devolve o slot e
+	// volta pelo gate/fila em vez de ir à aplicação no meio do checkpoint.
+	// Vale também pra espera do ramp, que é o mesmo limite.
+	var limited bool
+	for {
+		if crController.IsUnavailable() {
+			serveFromQueue(w, r, data, deadline, &timing)
+			return
+		}
+
+		// Logo após o gate reabrir todo handler estacionado volta junto: passa
+		// pelo limite adaptativo em vez de cair de uma vez na aplicação.
+		limited = liveTrafficLimited()
+		if !limited {
+			break
+		}
+		until, code := time.Now().Add(queueWaitTimeout), errGateTimeout
+		if !deadline.IsZero() && deadline.Before(until) {
+			until, code = deadline, errDeadlineExceeded
+		}
+		acquired := upstreamLimiter.tryAcquire()
+		if !acquired {
+			if fraction, _ := rampProgress(time.Now()); fraction < 1 {
+				rampQueuedTotal.Inc()
+			}
+			acquired = upstreamLimiter.acquire(r.Context(), until, false)
+		}
+		if !acquired {
+			adaptiveRejectedTotal.Inc()
+			config.ReleaseBody(data)
+			timing.gateWait = time.Since(startTime)
+			setStatusHeaders(w, &timing)
+			writeError(w, r, code, false)
+			return
+		}
+		timing.gateWait = time.Since(startTime)
+		if !crController.IsDoingSnapshot.Load() && !crController.IsRestoringSnapshot.Load() &&
+			!crController.IsUnavailable() {
+			break
+		}
+		upstreamLimiter.cancel()
+		if !waitGate() {
+			config.ReleaseBody(data)
+			return
+		}
+	}
+
 	crController.InFlightRequests.Add(1)
-	defer crController.InFlightRequests.Done()
-	writeResult(w, forwardBuffered(data))
+	inFlightDone := sync.OnceFunc(crController.InFlightRequests.Done)
+	defer inFlightDone()
+	res := forwardBuffered(data)
+	if res.NotDelivered {
+		// Saiu do buffer e o cliente recebe o erro.
+		config.ReleaseBody(data)
+	}
+	if limited {
+		upstreamLimiter.release(res)
+	} else {
+		upstreamLimiter.observe(res)
+	}
+	if res.Stream != nil {
+		// Stream pode durar indefinidamente: sai da contagem de drenagem; já
+		// está no registro de conexões longas desde o newStreamBody.
+		inFlightDone()
+	}
+	timing.fromResult(res, time.Time{})
+	setStatusHeaders(w, &timing)
+	writeResult(w, r, res)
+}
+
+// serveFromQueue atende um request com o gate fechado pela fila de
+// recuperação.
+func serveFromQueue(w http.ResponseWriter, r *http.Request, data config.RequestData, deadline time.Time, timing *requestTiming) {
+	// Fila de recuperação: o handler fica bloqueado esperando o resultado
+	// pelo canal — é ele quem escreve a resposta, nunca o worker. Sem isso
+	// o net/http finaliza a resposta como 200 vazio assim que o handler
+	// retorna, e o worker escreveria num writer morto.
+	respCh := make(chan config.Result, 1)
+	queued := data
+	if data.Method != http.MethodGet && data.Method != http.MethodHead {
+		// Escrita enfileirada é aplicada mesmo que o cliente desista.
+		queued.Deadline = time.Time{}
+	}
+	timing.queued = true
+	enqueuedAt := time.Now()
+	AddRequestToQueue(QueueHttpRequest{Data: queued, RespCh: respCh, ClientKey: fairnessKey(r)})
+	wait, code := queueWaitTimeout, errQueueTimeout
+	if !deadline.IsZero() && time.Until(deadline) < wait {
+		wait, code = time.Until(deadline), errDeadlineExceeded
+		if queued.Deadline.IsZero() {
+			// A escrita continua na fila e vai ser aplicada: o cliente
+			// precisa saber disso, não que ela "não foi encaminhada".
+			code = errDeadlineQueuedWrite
+		}
+	}
+	select {
+	case res := <-respCh:
+		timing.fromResult(res, enqueuedAt)
+		setStatusHeaders(w, timing)
+		writeResult(w, r, res)
+		return
+	case <-r.Context().Done():
+		// Cliente desconectou. O worker ainda aplica o request (estado);
+		// o canal buffered absorve o resultado sem bloquear ninguém.
+	case <-time.After(wait):
+		timing.queueWait = time.Since(enqueuedAt)
+		setStatusHeaders(w, timing)
+		writeError(w, r, code, true)
+	}
+	// Um resultado em streaming que chegar depois precisa ser fechado.
+	go func() { discardResult(<-respCh) }()
 }
 
 // forwardBuffered registra o request no buffer de reprocess, encaminha pra
@@ -149,15 +383,39 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 // entre snapshots).
 func forwardBuffered(data config.RequestData) config.Result {
 	if data.Method == http.MethodGet || data.Method == http.MethodHead {
-		return sendRequest(data, 0)
+		// Fora do buffer ninguém mais vai ler o corpo.
+		defer config.ReleaseBody(data)
+		start := time.Now()
+		res := sendWithRetry(data, 0)
+		res.Upstream = time.Since(start)
+		return res
 	}
 	requestNumber := config.SaveRequestToBuffer(data)
-	res := sendRequest(data, requestNumber)
+	start := time.Now()
+	res := sendWithRetry(data, requestNumber)
+	res.RequestNumber, res.Upstream = requestNumber, time.Since(start)
+	if res.NotDelivered {
+		// Nunca chegou na aplicação e quem pediu recebe delivered:false: sai
+		// do buffer, senão um replay futuro aplicaria uma escrita que o
+		// cliente deu como não entregue (e a entrada Pending ficaria pra
+		// sempre). O corpo fica com quem chamou.
+		config.RemoveRequestFromBuffer(requestNumber)
+		return res
+	}
 	config.UpdateRequestToProcessed(requestNumber)
 	return res
 }
 
-func writeResult(w http.ResponseWriter, res config.Result) {
+func writeResult(w http.ResponseWriter, r *http.Request, res config.Result) {
+	if res.ErrorCode != "" {
+		writeError(w, r, res.ErrorCode, !res.NotDelivered)
+		return
+	}
+	if res.Stream != nil {
+		streamResult(w, r, res)
+		return
+	}
+	copyResponseHeader(w.Header(), res.Header, res.Proto)
 	w.WriteHeader(res.Status)
 	if len(res.Body) > 0 {
 		if _, err := w.Write(res.Body); err != nil {
@@ -166,6 +424,28 @@ func writeResult(w http.ResponseWriter, res config.Result) {
 	}
 }
 
+// copyResponseHeader copia os headers da resposta da aplicação, exceto os
+// hop-by-hop e o Content-Length: o net/http recalcula tamanho/encoding pro
+// corpo que escrevemos. proto é o protocolo da resposta da aplicação, pro Via.
+func copyResponseHeader(dst, src http.Header, proto string) {
+	src = src.Clone()
+	removeHopHeaders(src)
+	src.Del("Te")
+	src.Del("Content-Length")
+	// Com os headers de status ligados, Interceptor-* é do interceptor: a
+	// aplicação não pode duplicar nem forjar esses valores.
+	statusHeaders := config.GetStatusHeadersEnabled()
+	for name, values := range src {
+		if statusHeaders && strings.HasPrefix(name, statusHeaderPrefix) {
+			continue
+		}
+		for _, value := range values {
+			dst.Add(name, value)
+		}
+	}
+	dst.Add("Via", viaValue(proto))
+}
+
 func sendRequest(data config.RequestData, uuid uint64) config.Result {
 	client := getHttpClient()
 
@@ -173,36 +453,105 @@ func sendRequest(data config.RequestData, uuid uint64) config.Result {
 	if direct := config.GetDirectApplicationURL(); direct != "" {
 		baseURL = direct
 	}
-	fullPath := baseURL + data.Path + "?" + data.Query
+	target, err := upstreamURL(baseURL, data)
+	if err != nil {
+		log.Err(err).Str("url", baseURL).Msg("Error building upstream URL")
+		return errorResult(errInterceptor, true)
+	}
 
-	req, err := http.NewRequest(data.Method, fullPath, bytes.NewReader(data.Body))
+	body, err := data.BodyReader()
+	if err != nil {
+		log.Err(err).Msg("Error opening request body")
+		return errorResult(errInterceptor, true)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	// Timeout por timer (não WithDeadline): vale até os headers da resposta e
+	// a leitura do corpo, mas é desarmado quando a resposta é um stream.
+	deadline := effectiveDeadline(data, time.Now())
+	var timedOut atomic.Bool
+	stopTimer := func() bool { return false }
+	if !deadline.IsZero() {
+		timer := time.AfterFunc(time.Until(deadline), func() {
+			timedOut.Store(true)
+			cancel()
+		})
+		stopTimer = timer.Stop
+	}
+	// Sem WroteHeaders, nada do request saiu: a aplicação certamente não o
+	// aplicou (dial recusado, DNS, TLS).
+	var wroteHeaders atomic.Bool
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteHeaders: func() { wroteHeaders.Store(true) },
+	})
+	req, err := http.NewRequestWithContext(ctx, data.Method, target.String(), body)
 	if err != nil {
+		stopTimer()
+		cancel()
+		_ = body.Close()
 		log.Err(err).Msg("Error creating request")
-		return config.Result{Status: 500}
+		return errorResult(errInterceptor, true)
 	}
-	for name, values := range data.Header {
-		for _, value := range values {
-			req.Header.Add(name, value)
-		}
+	req.ContentLength = data.BodyLength()
+	if req.ContentLength == 0 {
+		req.Body = http.NoBody
+	}
+	// Clone: o Header bufferizado pode ser compartilhado (internHeader).
+	req.Header = data.Header.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	removeHopHeaders(req.Header)
+	setForwardedHeaders(req, data)
+	if deadline.IsZero() {
+		// Cópia bufferizada (replay) não herda o prazo do cliente original.
+		req.Header.Del(requestTimeoutHeader)
+		req.Header.Del(grpcTimeoutHeader)
+	} else {
+		setDeadlineHeaders(req.Header, deadline)
 	}
+	req.Host = upstreamHost(target, data)
 	req.Header.Set("Interceptor-Controller", strconv.FormatUint(uuid, 10))
 
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Err(err).Msg("Error sending request")
-		return config.Result{Status: 500}
+		stopTimer()
+		cancel()
+		code := classifyUpstreamError(err)
+		if timedOut.Load() {
+			code = errUpstreamTimeout
+		}
+		delivered := wroteHeaders.Load()
+		log.Err(err).Str("code", code).Bool("delivered", delivered).Uint64("request", uuid).
+			Str("correlation_id", data.Header.Get(config.GetCorrelationIDHeader())).Msg("Error sending request")
+		return errorResult(code, !delivered)
+	}
+	if isStreamingResponse(data.Method, resp) {
+		stopTimer()
+		return config.Result{
+			Status: resp.StatusCode,
+			Proto:  resp.Proto,
+			Header: resp.Header,
+			Stream: newStreamBody(resp.Body, cancel),
+		}
 	}
-	body, err := getBodyContent(resp)
+	defer cancel()
+	respBody, err := getBodyContent(resp)
 	closeErr := resp.Body.Close()
+	stopTimer()
+	if err != nil && timedOut.Load() {
+		log.Err(err).Uint64("request", uuid).Msg("Timed out reading response body")
+		return errorResult(errUpstreamTimeout, false)
+	}
 	if err != nil {
-		log.Err(err).Msg("Error getting body content")
-		return config.Result{Status: 500}
+		log.Err(err).Uint64("request", uuid).Str("correlation_id", data.Header.Get(config.GetCorrelationIDHeader())).
+			Msg("Error getting body content")
+		return errorResult(errUpstreamResponse, false)
 	}
 	if closeErr != nil {
-		log.Err(closeErr).Msg("Error closing response body")
-		return config.Result{Status: 500}
+		// O corpo já foi lido inteiro: a resposta vale.
+		log.Warn().Err(closeErr).Msg("Error closing response body")
 	}
-	return config.Result{Status: resp.StatusCode, Body: body}
+	return config.Result{Status: resp.StatusCode, Proto: resp.Proto, Header: resp.Header, Body: respBody}
 }
 
 func getHttpClient() *http.Client {
@@ -220,14 +569,20 @@ func getHttpClient() *http.Client {
 				MaxIdleConnsPerHost: 4096,
 				IdleConnTimeout:     90 * time.Second,
 				DisableCompression:  true,
-				TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+				TLSClientConfig:     config.UpstreamTLSConfig(),
 				// Keep-alive ativo durante operação normal para evitar overhead de
 				// TCP handshake por request. Conexões são drenadas explicitamente em
 				// DrainConnections() antes de cada checkpoint (CRIU requer zero
 				// conexões TCP abertas no momento do dump).
 				DisableKeepAlives: false,
 			}
-			singleInstance = &http.Client{Transport: tr}
+			singleInstance = &http.Client{
+				Transport: tr,
+				// Redirect é resposta pro cliente, não pro proxy seguir.
+				CheckRedirect: func(*http.Request, []*http.Request) error {
+					return http.ErrUseLastResponse
+				},
+			}
 		}
 		lock.Unlock()
 	}
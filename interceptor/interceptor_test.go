@@ -0,0 +1,41 @@
+package interceptor
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// onlyReader esconde o tamanho do corpo: o request chega sem Content-Length,
+// como um upload chunked.
+type onlyReader struct{ io.Reader }
+
+func TestHandlerRejectsOversizedBodies(t *testing.T) {
+	t.Setenv("BODY_SPILL_DIR", t.TempDir())
+	t.Setenv("BODY_MAX_BYTES", "64")
+	body := bytes.Repeat([]byte("x"), 100)
+
+	post := func(r io.Reader) int {
+		w := httptest.NewRecorder()
+		Handler(w, httptest.NewRequest(http.MethodPost, "/upload", r))
+		return w.Code
+	}
+	// Content-Length declarado acima do limite: recusa sem ler o corpo.
+	if code := post(bytes.NewReader(body)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("declared oversized body: %d", code)
+	}
+	// Sem Content-Length o limite vale durante a leitura.
+	if code := post(onlyReader{bytes.NewReader(body)}); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunked oversized body: %d", code)
+	}
+
+	// Dentro do limite mas acima do orçamento de disco: 507.
+	t.Setenv("BODY_MAX_BYTES", "0")
+	t.Setenv("BODY_MEMORY_THRESHOLD", "16")
+	t.Setenv("BODY_SPILL_DISK_BUDGET", "1")
+	if code := post(bytes.NewReader(body)); code != http.StatusInsufficientStorage {
+		t.Errorf("body over the spill budget: %d", code)
+	}
+}
@@ -0,0 +1,104 @@
+package interceptor
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Conexões de longa duração (upgrade/WebSocket, streams e as sessões RESP/TCP)
+// NÃO entram em InFlightRequests: o Wait() do snapshot/StopRequests ficaria
+// preso nelas pra sempre. Em vez disso ficam neste registro, e antes do checkpoint a
+// política LONG_LIVED_CONN_POLICY decide se fecha na hora ou espera um pouco.
+
+type longLivedConn struct {
+	kind  string
+	close func()
+	done  chan struct{}
+}
+
+var longLivedMutex sync.Mutex
+var longLivedConns = map[uint64]*longLivedConn{}
+var longLivedSeq atomic.Uint64
+
+// ActiveLongLivedConnections é o número de conexões de longa duração abertas.
+var ActiveLongLivedConnections atomic.Int64
+
+var longLivedCutTotal = metrics.NewCounter("interceptor_long_lived_connections_cut_total",
+	"Long-lived connections (upgrade, streams) closed by LONG_LIVED_CONN_POLICY before a checkpoint")
+
+func init() {
+	metrics.NewGaugeFunc("interceptor_long_lived_connections",
+		"Long-lived connections (upgrade, streams) currently open",
+		func() float64 { return float64(ActiveLongLivedConnections.Load()) })
+}
+
+// TrackLongLived registra uma conexão; closeFn deve derrubar os dois lados.
+// A função retornada tira do registro e precisa ser chamada quando a conexão
+// terminar por conta própria.
+func TrackLongLived(kind string, closeFn func()) func() {
+	id := longLivedSeq.Add(1)
+	c := &longLivedConn{kind: kind, close: closeFn, done: make(chan struct{})}
+
+	longLivedMutex.Lock()
+	longLivedConns[id] = c
+	longLivedMutex.Unlock()
+	ActiveLongLivedConnections.Add(1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			longLivedMutex.Lock()
+			delete(longLivedConns, id)
+			longLivedMutex.Unlock()
+			ActiveLongLivedConnections.Add(-1)
+			close(c.done)
+		})
+	}
+}
+
+// CloseLongLivedConnections aplica LONG_LIVED_CONN_POLICY antes de um
+// checkpoint. Chamado pelo snapshotter e, via callback, pelo StopRequests.
+func CloseLongLivedConnections() {
+	longLivedMutex.Lock()
+	conns := make([]*longLivedConn, 0, len(longLivedConns))
+	for _, c := range longLivedConns {
+		conns = append(conns, c)
+	}
+	longLivedMutex.Unlock()
+	if len(conns) == 0 {
+		return
+	}
+
+	if config.GetLongLivedConnPolicy() == "wait" {
+		timeout := time.Duration(config.GetLongLivedConnWaitTimeout()) * time.Second
+		deadline := time.After(timeout)
+	wait:
+		for _, c := range conns {
+			select {
+			case <-c.done:
+			case <-deadline:
+				break wait
+			}
+		}
+	}
+
+	closed := 0
+	for _, c := range conns {
+		select {
+		case <-c.done:
+		default:
+			c.close()
+			closed++
+			longLivedCutTotal.Inc()
+		}
+	}
+	log.Info().Int("tracked", len(conns)).Int("closed", closed).
+		Str("policy", config.GetLongLivedConnPolicy()).
+		Msg("Long-lived connections handled before checkpoint")
+}
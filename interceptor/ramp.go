@@ -0,0 +1,62 @@
+package interceptor
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+	"interceptor-grpc/metrics"
+)
+
+var rampQueuedTotal = metrics.NewCounter("interceptor_ramp_deferred_total",
+	"Requests above the slow-start fraction after the gate reopened, by action", "action", "queued")
+var rampShedTotal = metrics.NewCounter("interceptor_ramp_deferred_total",
+	"Requests above the slow-start fraction after the gate reopened, by action", "action", "shed")
+
+// Slow-start após cada reabertura do gate (LastTrafficRelease, setado pelo
+// Reply, pela liberação forçada do snapshotter e pelo heartbeat): a fração
+// admitida sobe linearmente de RAMP_INITIAL_PERCENT a 100% em RAMP_WINDOW.
+// Ela escala o teto do limite adaptativo (o excesso espera slot) e, com
+// RAMP_EXCESS=shed, o excesso é recusado com 503. O heartbeat estende a
+// graça de flush pela duração do ramp.
+
+// rampProgress devolve a fração admitida agora e quanto falta do ramp; fora
+// dele (ou com RAMP_WINDOW=0), 1.
+func rampProgress(now time.Time) (float64, time.Duration) {
+	window := time.Duration(config.GetRampWindow()) * time.Second
+	released := crController.LastTrafficRelease.Load()
+	if window == 0 || released == 0 {
+		return 1, 0
+	}
+	elapsed := now.Sub(time.Unix(0, released))
+	if elapsed >= window || elapsed < 0 {
+		return 1, 0
+	}
+	initial := float64(config.GetRampInitialPercent()) / 100
+	return initial + (1-initial)*float64(elapsed)/float64(window), window - elapsed
+}
+
+// rampAdmit sorteia, com RAMP_EXCESS=shed, se o request é admitido. Sorteio
+// em vez de contagem: handlers estacionados acordam juntos e não há janela
+// pra contar.
+func rampAdmit() bool {
+	fraction, _ := rampProgress(time.Now())
+	return fraction >= 1 || rand.Float64() < fraction
+}
+
+// rampRetryAfter: segundos até o fim do ramp, no mínimo 1.
+func rampRetryAfter() int {
+	_, remaining := rampProgress(time.Now())
+	return max(int(math.Ceil(remaining.Seconds())), 1)
+}
+
+func init() {
+	metrics.NewGaugeFunc("interceptor_ramp_admit_ratio",
+		"Fraction of traffic admitted directly by the slow-start ramp (1 outside it)",
+		func() float64 {
+			fraction, _ := rampProgress(time.Now())
+			return fraction
+		})
+}
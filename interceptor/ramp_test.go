@@ -0,0 +1,109 @@
+package interceptor
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"interceptor-grpc/crController"
+)
+
+// releasedAt simula uma reabertura do gate no instante dado.
+func releasedAt(t *testing.T, at time.Time) {
+	previous := crController.LastTrafficRelease.Load()
+	crController.LastTrafficRelease.Store(at.UnixNano())
+	t.Cleanup(func() { crController.LastTrafficRelease.Store(previous) })
+}
+
+func TestRampProgress(t *testing.T) {
+	release := time.Now()
+	releasedAt(t, release)
+
+	// Sem RAMP_WINDOW: sem ramp.
+	if fraction, remaining := rampProgress(release); fraction != 1 || remaining != 0 {
+		t.Errorf("ramp off: %v %v", fraction, remaining)
+	}
+
+	t.Setenv("RAMP_WINDOW", "10")
+	cases := []struct {
+		after     time.Duration
+		fraction  float64
+		remaining time.Duration
+	}{
+		{0, 0.1, 10 * time.Second},
+		{5 * time.Second, 0.55, 5 * time.Second},
+		{9 * time.Second, 0.91, time.Second},
+		{10 * time.Second, 1, 0},
+		{time.Hour, 1, 0},
+		// Relógio voltou: fora do ramp em vez de fração negativa.
+		{-time.Second, 1, 0},
+	}
+	for _, c := range cases {
+		fraction, remaining := rampProgress(release.Add(c.after))
+		if math.Abs(fraction-c.fraction) > 1e-9 || remaining != c.remaining {
+			t.Errorf("after %v: %v %v, want %v %v", c.after, fraction, remaining, c.fraction, c.remaining)
+		}
+	}
+
+	t.Setenv("RAMP_INITIAL_PERCENT", "50")
+	if fraction, _ := rampProgress(release.Add(5 * time.Second)); fraction != 0.75 {
+		t.Errorf("initial 50%%, halfway: %v", fraction)
+	}
+
+	// Nunca houve reabertura: sem ramp.
+	crController.LastTrafficRelease.Store(0)
+	if fraction, _ := rampProgress(release); fraction != 1 {
+		t.Errorf("never released: %v", fraction)
+	}
+}
+
+func TestRampAdmit(t *testing.T) {
+	t.Setenv("RAMP_WINDOW", "600")
+	t.Setenv("RAMP_INITIAL_PERCENT", "5")
+	releasedAt(t, time.Now())
+
+	admitted := 0
+	for range 4000 {
+		if rampAdmit() {
+			admitted++
+		}
+	}
+	// ~5% no início do ramp (média 200, desvio ~14).
+	if admitted < 100 || admitted > 300 {
+		t.Errorf("admitted %d of 4000 at 5%%", admitted)
+	}
+	if got := rampRetryAfter(); got < 599 || got > 600 {
+		t.Errorf("Retry-After %d at the start of a 600s ramp", got)
+	}
+
+	releasedAt(t, time.Now().Add(-time.Hour))
+	for range 100 {
+		if !rampAdmit() {
+			t.Fatal("request shed after the ramp ended")
+		}
+	}
+	if got := rampRetryAfter(); got != 1 {
+		t.Errorf("Retry-After %d outside the ramp", got)
+	}
+}
+
+func TestRampScalesAdaptiveLimit(t *testing.T) {
+	t.Setenv("ADAPTIVE_CONCURRENCY_INITIAL", "100")
+	t.Setenv("ADAPTIVE_CONCURRENCY_MIN", "20")
+	t.Setenv("RAMP_WINDOW", "100")
+	l := newAdaptiveLimiter()
+
+	releasedAt(t, time.Now().Add(-50*time.Second))
+	if got := l.effectiveLimitLocked(); got < 54 || got > 56 {
+		t.Errorf("halfway through the ramp: limit %v, want about 55", got)
+	}
+	// No começo a fração (10%) ficaria abaixo do mínimo.
+	releasedAt(t, time.Now())
+	if got := l.effectiveLimitLocked(); got != 20 {
+		t.Errorf("start of the ramp: limit %v, want the minimum 20", got)
+	}
+	releasedAt(t, time.Now().Add(-time.Hour))
+	if got := l.effectiveLimitLocked(); got != 100 {
+		t.Errorf("after the ramp: limit %v", got)
+	}
+}
@@ -1,25 +1,156 @@
 package interceptor
 
 import (
-	"errors"
+	"net/http"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"interceptor-grpc/config"
+	"interceptor-grpc/metrics"
 )
 
+// QueueLength é o total de itens na fila de recuperação, somando as classes.
 var QueueLength = atomic.Uint32{}
-var queue = make([]QueueHttpRequest, 0)
+
+// Classes da fila de recuperação, em ordem de prioridade: replay do buffer
+// primeiro (a aplicação precisa voltar ao estado de antes do restore antes
+// de aceitar escritas novas), depois escritas e leituras ao vivo.
+type queueClass int
+
+const (
+	classReplay queueClass = iota
+	classWrite
+	classRead
+	numQueueClasses
+)
+
+var queueClassNames = [numQueueClasses]string{"replay", "write", "read"}
+
+func classify(request QueueHttpRequest) queueClass {
+	switch {
+	case !request.live():
+		return classReplay
+	case request.Data.Method == http.MethodGet || request.Data.Method == http.MethodHead:
+		return classRead
+	}
+	return classWrite
+}
+
+type queuedRequest struct {
+	request    QueueHttpRequest
+	enqueuedAt time.Time
+}
+
+type classQueue struct {
+	items    *fairQueue
+	inFlight int
+	// current é o crédito do round-robin ponderado suave (o do nginx).
+	current int
+	// held conta replays recusados sendo refeitos no lugar: enquanto > 0 a
+	// classe não despacha (ver retryReplay).
+	held int
+
+	dispatched *metrics.Counter
+	waitMs     *metrics.Counter
+}
+
 var queueMutex sync.Mutex
+var classQueues [numQueueClasses]*classQueue
+
+// queueSlotReleased acorda o ProcessQueue quando uma classe no limite de
+// concorrência libera um slot.
+var queueSlotReleased = make(chan struct{}, 1)
+
+var queueClassWeights = sync.OnceValue(func() [numQueueClasses]int {
+	return perClass(config.GetQueueClassWeights())
+})
+
+var queueClassLimits = sync.OnceValue(func() [numQueueClasses]int {
+	return perClass(config.GetQueueClassConcurrency())
+})
+
+func perClass(configured map[string]int) [numQueueClasses]int {
+	var values [numQueueClasses]int
+	for class, name := range queueClassNames {
+		values[class] = configured[name]
+	}
+	return values
+}
+
+func init() {
+	for class, name := range queueClassNames {
+		cq := &classQueue{
+			// Só as escritas ao vivo precisam de ordem por cliente: leitura
+			// não muda estado e o replay tem chave única.
+			items: newFairQueue(queueClass(class) == classWrite && config.GetQueueSerializeClientWrites()),
+			dispatched: metrics.NewCounter("interceptor_queue_dispatched_total",
+				"Recovery queue entries sent to the application, by class", "class", name),
+			waitMs: metrics.NewCounter("interceptor_queue_wait_milliseconds_total",
+				"Time recovery queue entries waited before dispatch, by class", "class", name),
+		}
+		classQueues[class] = cq
+		metrics.NewGaugeFunc("interceptor_queue_length", "Recovery queue entries waiting, by class",
+			func() float64 {
+				queueMutex.Lock()
+				defer queueMutex.Unlock()
+				return float64(cq.items.len())
+			}, "class", name)
+		metrics.NewGaugeFunc("interceptor_queue_clients", "Clients with entries in the recovery queue, by class",
+			func() float64 {
+				queueMutex.Lock()
+				defer queueMutex.Unlock()
+				return float64(cq.items.flowCount())
+			}, "class", name)
+		metrics.NewGaugeFunc("interceptor_queue_in_flight", "Recovery queue entries being sent, by class",
+			func() float64 {
+				queueMutex.Lock()
+				defer queueMutex.Unlock()
+				return float64(cq.inFlight)
+			}, "class", name)
+	}
+}
 
 func AddRequestToQueue(queueRequest QueueHttpRequest) {
 	queueMutex.Lock()
 	defer queueMutex.Unlock()
 
-	queue = append(queue, queueRequest)
+	class := classify(queueRequest)
+	key := queueRequest.ClientKey
+	if class == classReplay {
+		key = ""
+	}
+	classQueues[class].items.push(key, queuedRequest{request: queueRequest, enqueuedAt: time.Now()})
 	QueueLength.Add(1)
 }
 
+// requeueReplayFront devolve um replay ao início da fila de replay: ele vem
+// antes das entradas que já estavam lá.
+func requeueReplayFront(request QueueHttpRequest) {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+
+	classQueues[classReplay].items.pushFront("", queuedRequest{request: request, enqueuedAt: time.Now()})
+	QueueLength.Add(1)
+}
+
+// holdReplay pausa o despacho da classe de replay; resumeReplay retoma.
+func holdReplay() {
+	queueMutex.Lock()
+	classQueues[classReplay].held++
+	queueMutex.Unlock()
+}
+
+func resumeReplay() {
+	queueMutex.Lock()
+	classQueues[classReplay].held--
+	queueMutex.Unlock()
+	select {
+	case queueSlotReleased <- struct{}{}:
+	default:
+	}
+}
+
 // AddToQueueForReprocess enqueues a buffered request copy for replay after
 // recovery. RespCh stays nil: the original client was already answered (or is
 // long gone), so the result is applied to the application and discarded.
@@ -27,15 +158,66 @@ func AddToQueueForReprocess(data config.RequestData) {
 	AddRequestToQueue(QueueHttpRequest{Data: data})
 }
 
-func GetRequestFromQueue() (QueueHttpRequest, error) {
+// takeFromQueue escolhe a próxima classe (QUEUE_PRIORITY_MODE) e já reserva
+// o slot; ok=false com a fila não vazia significa esperar um slot liberar
+// (releaseQueueSlot, com a ClientKey do item).
+func takeFromQueue() (QueueHttpRequest, queueClass, bool) {
 	queueMutex.Lock()
 	defer queueMutex.Unlock()
 
-	if len(queue) == 0 {
-		return QueueHttpRequest{}, errors.New("queue is empty")
+	limits := queueClassLimits()
+	chosen := queueClass(-1)
+	if config.GetQueuePriorityMode() == "weighted" {
+		// Round-robin ponderado suave: a classe com mais crédito sai e paga o
+		// total, o que intercala as classes na proporção dos pesos. Aqui uma
+		// escrita ao vivo pode passar na frente de replay pendente.
+		weights, total := queueClassWeights(), 0
+		for class, cq := range classQueues {
+			if !cq.items.ready() || cq.inFlight >= limits[class] || cq.held > 0 {
+				continue
+			}
+			cq.current += weights[class]
+			total += weights[class]
+			if chosen < 0 || cq.current > classQueues[chosen].current {
+				chosen = queueClass(class)
+			}
+		}
+		if chosen >= 0 {
+			classQueues[chosen].current -= total
+		}
+	} else {
+		// Estrito: classe menor só anda com as maiores vazias, mesmo que a
+		// da vez esteja no limite (ou com os clientes dela serializados) —
+		// escrita ao vivo nunca passa na frente de replay pendente.
+		for class, cq := range classQueues {
+			if cq.items.len() > 0 || cq.held > 0 {
+				if cq.inFlight < limits[class] && cq.items.ready() && cq.held == 0 {
+					chosen = queueClass(class)
+				}
+				break
+			}
+		}
+	}
+	if chosen < 0 {
+		return QueueHttpRequest{}, 0, false
+	}
+
+	cq := classQueues[chosen]
+	item, _ := cq.items.pop()
+	cq.inFlight++
+	QueueLength.Add(^uint32(0))
+	cq.dispatched.Inc()
+	cq.waitMs.Add(time.Since(item.enqueuedAt).Milliseconds())
+	return item.request, chosen, true
+}
+
+func releaseQueueSlot(class queueClass, key string) {
+	queueMutex.Lock()
+	classQueues[class].inFlight--
+	classQueues[class].items.done(key)
+	queueMutex.Unlock()
+	select {
+	case queueSlotReleased <- struct{}{}:
+	default:
 	}
-	request := queue[0]
-	queue = queue[1:]
-	QueueLength.Store(uint32(len(queue)))
-	return request, nil
 }
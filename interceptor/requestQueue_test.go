@@ -0,0 +1,145 @@
+package interceptor
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"interceptor-grpc/config"
+)
+
+// queueFromEnv faz os pesos e limites da fila seguirem o ambiente do teste e
+// zera o crédito do round-robin.
+func queueFromEnv(t *testing.T) {
+	weights, limits := queueClassWeights, queueClassLimits
+	queueClassWeights = func() [numQueueClasses]int { return perClass(config.GetQueueClassWeights()) }
+	queueClassLimits = func() [numQueueClasses]int { return perClass(config.GetQueueClassConcurrency()) }
+	for _, cq := range classQueues {
+		cq.current = 0
+	}
+	t.Cleanup(func() {
+		drainQueue(t)
+		queueClassWeights, queueClassLimits = weights, limits
+	})
+}
+
+func liveRequest(method, path string) QueueHttpRequest {
+	return QueueHttpRequest{
+		Data:   config.RequestData{Method: method, Path: path},
+		RespCh: make(chan config.Result, 1),
+	}
+}
+
+func replayRequest(path string) QueueHttpRequest {
+	return QueueHttpRequest{Data: config.RequestData{Method: http.MethodPost, Path: path}}
+}
+
+// takeOrder tira n itens liberando cada slot na hora; "-" = nada pronto.
+func takeOrder(n int) string {
+	var order []string
+	for range n {
+		request, class, ok := takeFromQueue()
+		if !ok {
+			order = append(order, "-")
+			continue
+		}
+		releaseQueueSlot(class, request.ClientKey)
+		order = append(order, request.Data.Path)
+	}
+	return strings.Join(order, " ")
+}
+
+func TestTakeFromQueueStrict(t *testing.T) {
+	t.Setenv("QUEUE_PRIORITY_MODE", "strict")
+	t.Setenv("QUEUE_CLASS_CONCURRENCY", "replay=1,write=1,read=1")
+	queueFromEnv(t)
+
+	AddRequestToQueue(liveRequest(http.MethodGet, "r1"))
+	AddRequestToQueue(liveRequest(http.MethodPut, "w1"))
+	AddToQueueForReprocess(config.RequestData{Method: http.MethodPost, Path: "p1"})
+	AddToQueueForReprocess(config.RequestData{Method: http.MethodPost, Path: "p2"})
+
+	// Replay no limite: a escrita ao vivo espera mesmo com slot livre.
+	first, class, ok := takeFromQueue()
+	if !ok || first.Data.Path != "p1" || class != classReplay {
+		t.Fatalf("first %q class %d", first.Data.Path, class)
+	}
+	if _, _, ok := takeFromQueue(); ok {
+		t.Error("took past the replay class at its limit")
+	}
+	releaseQueueSlot(class, first.ClientKey)
+	if got := takeOrder(3); got != "p2 w1 r1" {
+		t.Errorf("order %q", got)
+	}
+
+	// Replay segurado (retry no lugar): nada anda, nem com a classe vazia.
+	holdReplay()
+	AddRequestToQueue(liveRequest(http.MethodPut, "w2"))
+	if _, _, ok := takeFromQueue(); ok {
+		t.Error("write dispatched while replay is held")
+	}
+	requeueReplayFront(replayRequest("p3"))
+	resumeReplay()
+	if got := takeOrder(2); got != "p3 w2" {
+		t.Errorf("order after resume %q", got)
+	}
+}
+
+func TestTakeFromQueueWeighted(t *testing.T) {
+	t.Setenv("QUEUE_PRIORITY_MODE", "weighted")
+	t.Setenv("QUEUE_CLASS_WEIGHTS", "replay=2,write=1,read=1")
+	t.Setenv("QUEUE_CLASS_CONCURRENCY", "replay=8,write=8,read=1")
+	queueFromEnv(t)
+
+	for _, path := range []string{"p1", "p2", "p3", "p4"} {
+		AddRequestToQueue(replayRequest(path))
+	}
+	AddRequestToQueue(liveRequest(http.MethodPost, "w1"))
+	AddRequestToQueue(liveRequest(http.MethodPost, "w2"))
+	AddRequestToQueue(liveRequest(http.MethodGet, "r1"))
+	AddRequestToQueue(liveRequest(http.MethodHead, "r2"))
+	// Intercalado na proporção 2:1:1, com escrita passando na frente de
+	// replay pendente.
+	if got := takeOrder(8); got != "p1 w1 r1 p2 p3 w2 r2 p4" {
+		t.Errorf("order %q", got)
+	}
+
+	// Leitura no limite de 1: as outras classes seguem.
+	AddRequestToQueue(liveRequest(http.MethodGet, "r3"))
+	AddRequestToQueue(liveRequest(http.MethodGet, "r4"))
+	AddRequestToQueue(liveRequest(http.MethodPost, "w3"))
+	read, class, ok := takeFromQueue()
+	if !ok || class != classRead {
+		t.Fatalf("took %q class %d", read.Data.Path, class)
+	}
+	if got := takeOrder(2); got != "w3 -" {
+		t.Errorf("with reads at the limit: %q", got)
+	}
+	releaseQueueSlot(class, read.ClientKey)
+
+	// Replay segurado fica de fora; o resto anda.
+	holdReplay()
+	AddRequestToQueue(replayRequest("p5"))
+	if got := takeOrder(2); got != "r4 -" {
+		t.Errorf("with replay held: %q", got)
+	}
+	resumeReplay()
+	if got := takeOrder(1); got != "p5" {
+		t.Errorf("after resume: %q", got)
+	}
+}
+
+// drainQueue esvazia a fila de recuperação global e devolve os itens.
+func drainQueue(t *testing.T) []QueueHttpRequest {
+	t.Helper()
+	var items []QueueHttpRequest
+	for QueueLength.Load() > 0 {
+		request, class, ok := takeFromQueue()
+		if !ok {
+			t.Fatal("queue not empty but nothing to take")
+		}
+		releaseQueueSlot(class, request.ClientKey)
+		items = append(items, request)
+	}
+	return items
+}
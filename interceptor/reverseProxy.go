@@ -0,0 +1,144 @@
+package interceptor
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"interceptor-grpc/config"
+)
+
+// viaPseudonym identifica o interceptor no header Via.
+const viaPseudonym = "interceptor"
+
+// Headers hop-by-hop (RFC 9110 §7.6.1): valem só pra conexão atual e não
+// atravessam proxy. Te é tratado à parte ("trailers" pode passar).
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders tira os hop-by-hop fixos e os nomeados em Connection.
+func removeHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+	if te := h.Get("Te"); te != "" {
+		h.Del("Te")
+		if strings.Contains(strings.ToLower(te), "trailers") {
+			h.Set("Te", "trailers")
+		}
+	}
+}
+
+// upstreamURL junta o path do cliente ao de APPLICATION_URL preservando o
+// RawPath (%2F etc. não vira "/") e só põe "?" quando há query.
+func upstreamURL(baseURL string, data config.RequestData) (*url.URL, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	target := *base
+	clientPath := &url.URL{Path: data.Path, RawPath: data.RawPath}
+	target.Path = joinPath(base.Path, data.Path)
+	if data.RawPath != "" || base.RawPath != "" {
+		target.RawPath = joinPath(base.EscapedPath(), clientPath.EscapedPath())
+	}
+	switch {
+	case base.RawQuery == "":
+		target.RawQuery = data.Query
+	case data.Query != "":
+		target.RawQuery = base.RawQuery + "&" + data.Query
+	}
+	return &target, nil
+}
+
+// upstreamHost é o Host enviado à aplicação: o do cliente com PRESERVE_HOST,
+// senão o de APPLICATION_URL.
+func upstreamHost(target *url.URL, data config.RequestData) string {
+	if config.GetPreserveHost() && data.Host != "" {
+		return data.Host
+	}
+	return target.Host
+}
+
+func joinPath(a, b string) string {
+	if a == "" {
+		return b
+	}
+	if b == "" {
+		return a
+	}
+	aslash, bslash := strings.HasSuffix(a, "/"), strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// setForwardedHeaders acrescenta X-Forwarded-*, Forwarded e Via com o que o
+// cliente original mandou — no replay também, já que a cópia guarda tudo.
+func setForwardedHeaders(req *http.Request, data config.RequestData) {
+	clientIP, _, err := net.SplitHostPort(data.RemoteAddr)
+	if err != nil {
+		clientIP = data.RemoteAddr
+	}
+	scheme := data.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	if clientIP != "" {
+		if prior := req.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			req.Header.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+clientIP)
+		} else {
+			req.Header.Set("X-Forwarded-For", clientIP)
+		}
+	}
+	if data.Host != "" {
+		req.Header.Set("X-Forwarded-Host", data.Host)
+	}
+	req.Header.Set("X-Forwarded-Proto", scheme)
+
+	var forwarded []string
+	if clientIP != "" {
+		node := clientIP
+		if strings.Contains(clientIP, ":") {
+			node = `"[` + clientIP + `]"`
+		}
+		forwarded = append(forwarded, "for="+node)
+	}
+	if data.Host != "" {
+		forwarded = append(forwarded, `host="`+data.Host+`"`)
+	}
+	forwarded = append(forwarded, "proto="+scheme)
+	req.Header.Add("Forwarded", strings.Join(forwarded, ";"))
+
+	req.Header.Add("Via", viaValue(data.Proto))
+}
+
+// viaValue formata "<versão> interceptor" a partir de "HTTP/1.1", "HTTP/2.0".
+func viaValue(proto string) string {
+	version := strings.TrimPrefix(proto, "HTTP/")
+	if version == "" || version == proto {
+		version = "1.1"
+	}
+	return version + " " + viaPseudonym
+}
@@ -0,0 +1,159 @@
+package interceptor
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"interceptor-grpc/config"
+)
+
+func TestUpstreamURL(t *testing.T) {
+	cases := []struct {
+		name, base string
+		data       config.RequestData
+		want       string
+	}{
+		{"root", "http://app:8080", config.RequestData{Path: "/a"}, "/a"},
+		{"base without slash", "http://app/base", config.RequestData{Path: "/a"}, "/base/a"},
+		{"base with slash", "http://app/base/", config.RequestData{Path: "/a"}, "/base/a"},
+		{"empty client path", "http://app/base/", config.RequestData{}, "/base/"},
+		{"client path without slash", "http://app/base", config.RequestData{Path: "a"}, "/base/a"},
+		{"escaped slash", "http://app/base", config.RequestData{Path: "/chat/a", RawPath: "/chat%2Fa"}, "/base/chat%2Fa"},
+		{"escaped base", "http://app/a%2Fb/", config.RequestData{Path: "/c"}, "/a%2Fb/c"},
+		{"escaped on both", "http://app/a%2Fb", config.RequestData{Path: "/c/d", RawPath: "/c%2Fd"}, "/a%2Fb/c%2Fd"},
+		{"reserved characters", "http://app", config.RequestData{Path: "/a b/c?d"}, "/a%20b/c%3Fd"},
+		{"query", "http://app", config.RequestData{Path: "/a", Query: "x=1&y=%2F"}, "/a?x=1&y=%2F"},
+		{"base query", "http://app/?k=v", config.RequestData{Path: "/a"}, "/a?k=v"},
+		{"merged query", "http://app/?k=v", config.RequestData{Path: "/a", Query: "x=1"}, "/a?k=v&x=1"},
+	}
+	for _, c := range cases {
+		target, err := upstreamURL(c.base, c.data)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got := target.RequestURI(); got != c.want {
+			t.Errorf("%s: %q, want %q", c.name, got, c.want)
+		}
+	}
+	if _, err := upstreamURL("http://app/%zz", config.RequestData{}); err == nil {
+		t.Error("invalid APPLICATION_URL accepted")
+	}
+}
+
+func TestJoinPath(t *testing.T) {
+	cases := map[[2]string]string{
+		{"", "/a"}:       "/a",
+		{"/base", ""}:    "/base",
+		{"/base", "/a"}:  "/base/a",
+		{"/base/", "/a"}: "/base/a",
+		{"/base", "a"}:   "/base/a",
+		{"/base/", "a"}:  "/base/a",
+		{"/", "/"}:       "/",
+	}
+	for in, want := range cases {
+		if got := joinPath(in[0], in[1]); got != want {
+			t.Errorf("joinPath(%q, %q) = %q, want %q", in[0], in[1], got, want)
+		}
+	}
+}
+
+func TestRemoveHopHeaders(t *testing.T) {
+	cases := []struct {
+		name     string
+		in, want http.Header
+	}{
+		{
+			name: "fixed hop-by-hop",
+			in: http.Header{
+				"Connection": {"keep-alive"}, "Keep-Alive": {"timeout=5"}, "Transfer-Encoding": {"chunked"},
+				"Upgrade": {"h2c"}, "Proxy-Authorization": {"Basic x"}, "Trailer": {"X-Sum"},
+				"Content-Type": {"text/plain"},
+			},
+			want: http.Header{"Content-Type": {"text/plain"}},
+		},
+		{
+			name: "named in Connection",
+			in: http.Header{
+				"Connection": {"Foo, close", "x-bar"}, "Foo": {"1"}, "X-Bar": {"2"}, "X-Baz": {"3"},
+			},
+			want: http.Header{"X-Baz": {"3"}},
+		},
+		{
+			name: "te trailers kept",
+			in:   http.Header{"Te": {"gzip, Trailers"}},
+			want: http.Header{"Te": {"trailers"}},
+		},
+		{
+			name: "te without trailers",
+			in:   http.Header{"Te": {"gzip"}},
+			want: http.Header{},
+		},
+	}
+	for _, c := range cases {
+		removeHopHeaders(c.in)
+		if !reflect.DeepEqual(c.in, c.want) {
+			t.Errorf("%s: %v, want %v", c.name, c.in, c.want)
+		}
+	}
+}
+
+func TestSetForwardedHeaders(t *testing.T) {
+	cases := []struct {
+		name                 string
+		prior                http.Header
+		data                 config.RequestData
+		xff, forwarded, via  []string
+		forwardedHost, proto string
+	}{
+		{
+			name:          "first hop",
+			prior:         http.Header{},
+			data:          config.RequestData{RemoteAddr: "10.0.0.1:5000", Host: "api.example", Scheme: "https", Proto: "HTTP/2.0"},
+			xff:           []string{"10.0.0.1"},
+			forwarded:     []string{`for=10.0.0.1;host="api.example";proto=https`},
+			via:           []string{"2.0 interceptor"},
+			forwardedHost: "api.example",
+			proto:         "https",
+		},
+		{
+			name: "existing chain",
+			prior: http.Header{
+				"X-Forwarded-For": {"203.0.113.7, 198.51.100.2", "192.0.2.9"},
+				"Forwarded":       {"for=203.0.113.7"},
+				"Via":             {"1.1 edge"},
+			},
+			data:      config.RequestData{RemoteAddr: "[2001:db8::1]:443", Proto: "HTTP/1.1"},
+			xff:       []string{"203.0.113.7, 198.51.100.2, 192.0.2.9, 2001:db8::1"},
+			forwarded: []string{"for=203.0.113.7", `for="[2001:db8::1]";proto=http`},
+			via:       []string{"1.1 edge", "1.1 interceptor"},
+			proto:     "http",
+		},
+		{
+			name:      "no remote address",
+			prior:     http.Header{},
+			data:      config.RequestData{Proto: "bogus"},
+			forwarded: []string{"proto=http"},
+			via:       []string{"1.1 interceptor"},
+			proto:     "http",
+		},
+	}
+	for _, c := range cases {
+		req := &http.Request{Header: c.prior}
+		setForwardedHeaders(req, c.data)
+		h := req.Header
+		if got := h.Values("X-Forwarded-For"); !reflect.DeepEqual(got, c.xff) {
+			t.Errorf("%s: X-Forwarded-For %q, want %q", c.name, got, c.xff)
+		}
+		if got := h.Values("Forwarded"); !reflect.DeepEqual(got, c.forwarded) {
+			t.Errorf("%s: Forwarded %q, want %q", c.name, got, c.forwarded)
+		}
+		if got := h.Values("Via"); !reflect.DeepEqual(got, c.via) {
+			t.Errorf("%s: Via %q, want %q", c.name, got, c.via)
+		}
+		if h.Get("X-Forwarded-Host") != c.forwardedHost || h.Get("X-Forwarded-Proto") != c.proto {
+			t.Errorf("%s: X-Forwarded-Host %q, X-Forwarded-Proto %q", c.name, h.Get("X-Forwarded-Host"), h.Get("X-Forwarded-Proto"))
+		}
+	}
+}
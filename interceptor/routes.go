@@ -0,0 +1,36 @@
+package interceptor
+
+import "strings"
+
+// routePattern casa "[MÉTODO ]padrão": padrão terminado em * casa por
+// prefixo, senão o path exato; sem método casa qualquer um.
+type routePattern struct {
+	method  string
+	pattern string
+	prefix  bool
+}
+
+func parseRoutePattern(spec string) (routePattern, bool) {
+	var rp routePattern
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 1:
+		rp.pattern = fields[0]
+	case 2:
+		rp.method, rp.pattern = strings.ToUpper(fields[0]), fields[1]
+	default:
+		return rp, false
+	}
+	rp.pattern, rp.prefix = strings.CutSuffix(rp.pattern, "*")
+	return rp, true
+}
+
+func (rp routePattern) matches(method, path string) bool {
+	if rp.method != "" && rp.method != method {
+		return false
+	}
+	if rp.prefix {
+		return strings.HasPrefix(path, rp.pattern)
+	}
+	return path == rp.pattern
+}
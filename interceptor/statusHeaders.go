@@ -0,0 +1,56 @@
+package interceptor
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+)
+
+// Prefixo (canônico) dos headers de status; os da aplicação com o mesmo
+// prefixo são descartados em copyResponseHeader.
+const statusHeaderPrefix = "Interceptor-"
+
+// requestTiming acumula onde o request passou o tempo, pra responder com os
+// headers de status (STATUS_HEADERS_ENABLED): quem vê uma resposta lenta
+// distingue snapshot (gate), recuperação (fila) e a própria aplicação.
+type requestTiming struct {
+	gateWait      time.Duration
+	queueWait     time.Duration
+	upstream      time.Duration
+	queued        bool
+	requestNumber uint64
+}
+
+// fromResult separa, num request enfileirado, a espera na fila do envio.
+func (t *requestTiming) fromResult(res config.Result, enqueuedAt time.Time) {
+	t.upstream, t.requestNumber = res.Upstream, res.RequestNumber
+	if t.queued {
+		t.queueWait = max(time.Since(enqueuedAt)-res.Upstream, 0)
+	}
+}
+
+func setStatusHeaders(w http.ResponseWriter, t *requestTiming) {
+	if !config.GetStatusHeadersEnabled() {
+		return
+	}
+	h := w.Header()
+	if t.requestNumber != 0 {
+		h.Set("Interceptor-Request-Number", strconv.FormatUint(t.requestNumber, 10))
+	}
+	h.Set("Interceptor-Queued", strconv.FormatBool(t.queued))
+	h.Set("Interceptor-Gate-Wait-Ms", formatMs(t.gateWait))
+	h.Set("Interceptor-Queue-Wait-Ms", formatMs(t.queueWait))
+	h.Set("Interceptor-Upstream-Ms", formatMs(t.upstream))
+	h.Set("Interceptor-Snapshot-Generation", strconv.FormatUint(crController.SnapshotGeneration.Load(), 10))
+	// Add: a aplicação pode ter mandado o seu próprio Server-Timing.
+	h.Add("Server-Timing", fmt.Sprintf(`gate;desc="interceptor gate";dur=%s, queue;desc="recovery queue";dur=%s, upstream;desc="application";dur=%s`,
+		formatMs(t.gateWait), formatMs(t.queueWait), formatMs(t.upstream)))
+}
+
+func formatMs(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 1, 64)
+}
@@ -0,0 +1,96 @@
+package interceptor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+)
+
+func TestSetStatusHeaders(t *testing.T) {
+	timing := requestTiming{
+		gateWait: 1500 * time.Microsecond, queueWait: 20 * time.Millisecond,
+		upstream: 3 * time.Millisecond, queued: true, requestNumber: 42,
+	}
+
+	w := httptest.NewRecorder()
+	setStatusHeaders(w, &timing)
+	if len(w.Header()) != 0 {
+		t.Errorf("headers without STATUS_HEADERS_ENABLED: %v", w.Header())
+	}
+
+	t.Setenv("STATUS_HEADERS_ENABLED", "true")
+	generation := crController.SnapshotGeneration.Load()
+	crController.SnapshotGeneration.Store(7)
+	defer crController.SnapshotGeneration.Store(generation)
+	w = httptest.NewRecorder()
+	w.Header().Set("Server-Timing", "db;dur=2")
+	setStatusHeaders(w, &timing)
+	want := map[string]string{
+		"Interceptor-Request-Number":      "42",
+		"Interceptor-Queued":              "true",
+		"Interceptor-Gate-Wait-Ms":        "1.5",
+		"Interceptor-Queue-Wait-Ms":       "20.0",
+		"Interceptor-Upstream-Ms":         "3.0",
+		"Interceptor-Snapshot-Generation": "7",
+	}
+	for name, value := range want {
+		if got := w.Header().Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+	serverTiming := w.Header().Values("Server-Timing")
+	if len(serverTiming) != 2 || serverTiming[0] != "db;dur=2" ||
+		serverTiming[1] != `gate;desc="interceptor gate";dur=1.5, queue;desc="recovery queue";dur=20.0, upstream;desc="application";dur=3.0` {
+		t.Errorf("Server-Timing %q", serverTiming)
+	}
+
+	// Resposta sem número (gate recusou): o header não sai.
+	w = httptest.NewRecorder()
+	setStatusHeaders(w, &requestTiming{})
+	if _, ok := w.Header()["Interceptor-Request-Number"]; ok || w.Header().Get("Interceptor-Queued") != "false" {
+		t.Errorf("unnumbered response: %v", w.Header())
+	}
+}
+
+func TestRequestTimingFromResult(t *testing.T) {
+	res := config.Result{Upstream: 30 * time.Millisecond, RequestNumber: 9}
+	direct := requestTiming{}
+	direct.fromResult(res, time.Now().Add(-time.Second))
+	if direct.upstream != res.Upstream || direct.requestNumber != 9 || direct.queueWait != 0 {
+		t.Errorf("direct request: %+v", direct)
+	}
+
+	queued := requestTiming{queued: true}
+	queued.fromResult(res, time.Now().Add(-100*time.Millisecond))
+	if queued.queueWait < 70*time.Millisecond || queued.queueWait > time.Second {
+		t.Errorf("queue wait %v, want about 70ms", queued.queueWait)
+	}
+	// Relógio que não fecha (upstream maior que o total): nunca negativo.
+	queued.fromResult(config.Result{Upstream: time.Hour}, time.Now())
+	if queued.queueWait != 0 {
+		t.Errorf("negative queue wait clamped to %v", queued.queueWait)
+	}
+}
+
+func TestCopyResponseHeaderDropsForgedStatusHeaders(t *testing.T) {
+	src := http.Header{
+		"Interceptor-Queued": {"false"},
+		"Content-Type":       {"text/plain"},
+	}
+	dst := http.Header{}
+	copyResponseHeader(dst, src, "HTTP/1.1")
+	if dst.Get("Interceptor-Queued") != "false" {
+		t.Error("application header dropped with status headers off")
+	}
+
+	t.Setenv("STATUS_HEADERS_ENABLED", "true")
+	dst = http.Header{}
+	copyResponseHeader(dst, src, "HTTP/1.1")
+	if _, ok := dst["Interceptor-Queued"]; ok || dst.Get("Content-Type") != "text/plain" {
+		t.Errorf("copied headers %v", dst)
+	}
+}
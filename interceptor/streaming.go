@@ -0,0 +1,126 @@
+package interceptor
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+var activeStreams = metrics.NewGauge("interceptor_active_streams",
+	"Streaming responses (SSE, chunked) currently being relayed to clients")
+var streamsTotal = metrics.NewCounter("interceptor_streams_total",
+	"Streaming responses relayed since start")
+var streamBytesTotal = metrics.NewCounter("interceptor_stream_bytes_total",
+	"Bytes relayed through streaming responses")
+
+// isStreamingResponse: resposta que pode não terminar (SSE) ou terminar muito
+// depois (chunked longo). Essas não passam pelo io.ReadAll do getBodyContent.
+func isStreamingResponse(method string, resp *http.Response) bool {
+	if method == http.MethodHead || resp.StatusCode == http.StatusNoContent ||
+		resp.StatusCode == http.StatusNotModified {
+		return false
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		for _, contentType := range config.GetStreamContentTypes() {
+			if strings.EqualFold(mediaType, contentType) {
+				return true
+			}
+		}
+	}
+	return resp.ContentLength < 0 && config.GetStreamChunkedResponses()
+}
+
+// streamBody amarra o corpo ao cancelamento do request upstream: Close corta
+// a conexão mesmo com um Read bloqueado esperando o próximo evento. O stream
+// entra no registro de conexões longas já na criação, ainda dentro do
+// InFlightRequests de quem o encaminhou: não há janela em que um snapshot
+// não o veja.
+type streamBody struct {
+	io.ReadCloser
+	cancel  context.CancelFunc
+	untrack func()
+	once    sync.Once
+}
+
+func newStreamBody(body io.ReadCloser, cancel context.CancelFunc) *streamBody {
+	s := &streamBody{ReadCloser: body, cancel: cancel}
+	s.untrack = TrackLongLived("stream", func() { _ = s.Close() })
+	return s
+}
+
+func (s *streamBody) Close() error {
+	var err error
+	s.once.Do(func() {
+		s.cancel()
+		err = s.ReadCloser.Close()
+		s.untrack()
+	})
+	return err
+}
+
+// streamResult repassa um Result com Stream ao cliente, com flush a cada
+// leitura. O stream é de longa duração: está no registro de conexões longas
+// (LONG_LIVED_CONN_POLICY decide se o checkpoint espera ou corta) e NÃO em
+// InFlightRequests — quem chamou já deve ter liberado o slot.
+func streamResult(w http.ResponseWriter, r *http.Request, res config.Result) {
+	defer res.Stream.Close()
+
+	copyResponseHeader(w.Header(), res.Header, res.Proto)
+	w.WriteHeader(res.Status)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	activeStreams.Inc()
+	defer activeStreams.Dec()
+	streamsTotal.Inc()
+
+	// Cliente desconectado com o upstream ocioso (SSE entre eventos): sem
+	// isso o Read ficaria bloqueado até o próximo evento.
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			_ = res.Stream.Close()
+		case <-finished:
+		}
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := res.Stream.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return
+			}
+			streamBytesTotal.Add(int64(n))
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			if err != io.EOF && r.Context().Err() == nil {
+				log.Debug().Err(err).Msg("Streaming response ended with error")
+			}
+			return
+		}
+	}
+}
+
+// discardResult libera um Result que ninguém vai escrever (replay, ou
+// handler que já desistiu).
+func discardResult(res config.Result) {
+	if res.Stream != nil {
+		_ = res.Stream.Close()
+	}
+}
@@ -0,0 +1,139 @@
+package interceptor
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"interceptor-grpc/config"
+)
+
+func TestIsStreamingResponse(t *testing.T) {
+	response := func(status int, contentType string, length int64) *http.Response {
+		return &http.Response{StatusCode: status, Header: http.Header{"Content-Type": {contentType}}, ContentLength: length}
+	}
+	cases := []struct {
+		name    string
+		method  string
+		resp    *http.Response
+		chunked bool
+		want    bool
+	}{
+		{"sse", http.MethodGet, response(200, "text/event-stream", -1), false, true},
+		{"sse with params", http.MethodGet, response(200, "Text/Event-Stream; charset=utf-8", 100), false, true},
+		{"ndjson", http.MethodPost, response(200, "application/x-ndjson", -1), false, true},
+		{"json", http.MethodGet, response(200, "application/json", -1), false, false},
+		{"chunked json", http.MethodGet, response(200, "application/json", -1), true, true},
+		{"sized json", http.MethodGet, response(200, "application/json", 10), true, false},
+		{"head", http.MethodHead, response(200, "text/event-stream", -1), true, false},
+		{"no content", http.MethodGet, response(http.StatusNoContent, "text/event-stream", -1), true, false},
+		{"not modified", http.MethodGet, response(http.StatusNotModified, "text/event-stream", -1), true, false},
+		{"bad content type", http.MethodGet, response(200, ";;", 10), false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.chunked {
+				t.Setenv("STREAM_CHUNKED_RESPONSES", "true")
+			}
+			if got := isStreamingResponse(c.method, c.resp); got != c.want {
+				t.Errorf("isStreamingResponse = %v, want %v", got, c.want)
+			}
+		})
+	}
+
+	t.Setenv("STREAM_CONTENT_TYPES", "application/grpc-web")
+	if isStreamingResponse(http.MethodGet, response(200, "text/event-stream", 10)) ||
+		!isStreamingResponse(http.MethodGet, response(200, "application/grpc-web", 10)) {
+		t.Error("STREAM_CONTENT_TYPES not honoured")
+	}
+}
+
+// O checkpoint corta um stream parado no Read esperando o próximo evento.
+func TestStreamBodyTrackedUntilClosed(t *testing.T) {
+	t.Setenv("LONG_LIVED_CONN_POLICY", "close")
+	upstream, events := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	before := ActiveLongLivedConnections.Load()
+	body := newStreamBody(upstream, cancel)
+	if ActiveLongLivedConnections.Load() != before+1 {
+		t.Fatal("stream not registered on creation")
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := body.Read(make([]byte, 1))
+		readErr <- err
+	}()
+	CloseLongLivedConnections()
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Error("read returned data after the cut")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked read not released by the checkpoint")
+	}
+	if ctx.Err() == nil || ActiveLongLivedConnections.Load() != before {
+		t.Errorf("after cut: ctx %v, %d long-lived", ctx.Err(), ActiveLongLivedConnections.Load()-before)
+	}
+	if err := body.Close(); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+	if _, err := events.Write([]byte("x")); err == nil {
+		t.Error("upstream still writable after close")
+	}
+}
+
+func TestStreamResultFlushesEachRead(t *testing.T) {
+	upstream, events := io.Pipe()
+	_, cancel := context.WithCancel(context.Background())
+	body := newStreamBody(upstream, cancel)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		streamResult(w, r, config.Result{
+			Status: http.StatusOK, Proto: "HTTP/1.1",
+			Header: http.Header{"Content-Type": {"text/event-stream"}},
+			Stream: body,
+		})
+	}))
+	defer server.Close()
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Via") == "" || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("headers %v", resp.Header)
+	}
+
+	// Cada evento chega antes do próximo ser escrito.
+	lines := bufio.NewReader(resp.Body)
+	for _, event := range []string{"data: 1\n", "data: 2\n"} {
+		go func() { _, _ = events.Write([]byte(event)) }()
+		line, err := lines.ReadString('\n')
+		if err != nil || line != event {
+			t.Fatalf("read %q, %v", line, err)
+		}
+	}
+
+	// Cliente vai embora com o upstream ocioso: o stream fecha.
+	stop()
+	written := make(chan error, 1)
+	go func() {
+		var err error
+		for deadline := time.Now().Add(time.Second); err == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			_, err = events.Write([]byte(": keepalive\n"))
+		}
+		written <- err
+	}()
+	if err := <-written; err == nil {
+		t.Error("upstream stream kept open after the client left")
+	}
+}
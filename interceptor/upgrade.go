@@ -0,0 +1,254 @@
+package interceptor
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+
+	"github.com/rs/zerolog/log"
+)
+
+// isUpgradeRequest: Connection lista o token "upgrade" e há um Upgrade
+// (WebSocket, h2c...). Esses requests não cabem no modelo buffered do
+// Handler — a resposta nunca "termina".
+func isUpgradeRequest(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dialApplication abre uma conexão crua com a aplicação (mesma URL base do
+// sendRequest) e devolve a URL base parseada.
+func dialApplication() (net.Conn, *url.URL, error) {
+	baseURL := config.GetApplicationURL()
+	if direct := config.GetDirectApplicationURL(); direct != "" {
+		baseURL = direct
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	secure := base.Scheme == "https" || base.Scheme == "wss"
+	host := base.Host
+	if base.Port() == "" {
+		if secure {
+			host = net.JoinHostPort(base.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(base.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if secure {
+		tlsConfig := config.UpstreamTLSConfig()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = base.Hostname()
+		}
+		tlsConfig.NextProtos = []string{"http/1.1"}
+		conn, err := tls.DialWithDialer(dialer, "tcp", host, tlsConfig)
+		return conn, base, err
+	}
+	conn, err := dialer.Dial("tcp", host)
+	return conn, base, err
+}
+
+// upgradeRequest monta o handshake pra aplicação com o mesmo path e Host de
+// um request comum (upstreamURL, PRESERVE_HOST).
+func upgradeRequest(method string, base *url.URL, data config.RequestData, header http.Header) (*http.Request, error) {
+	target, err := upstreamURL(base.String(), data)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Request{
+		Method:     method,
+		URL:        &url.URL{Path: target.Path, RawPath: target.RawPath, RawQuery: target.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Host:       upstreamHost(target, data),
+	}, nil
+}
+
+// waitWebSocketReplay segura enquanto há mensagens WebSocket gravadas
+// esperando replay. Retorna false se estourou o teto do gate.
+func waitWebSocketReplay() bool {
+	start := time.Now()
+	for WebSocketReplayQueueLength.Load() > 0 {
+		if time.Since(start) > 5*time.Minute {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
+
+// handleUpgrade faz o passthrough de um request de upgrade: repassa o
+// handshake, e se a aplicação responder 101 sequestra a conexão do cliente e
+// vira túnel bidirecional. A conexão entra no registro de longa duração (não
+// em InFlightRequests) pra que o checkpoint aplique LONG_LIVED_CONN_POLICY.
+// Até o registro o handshake conta em InFlightRequests: um snapshot que
+// começasse entre o gate e o registro não veria a conexão.
+func handleUpgrade(w http.ResponseWriter, r *http.Request) {
+	webSocket := strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+	// Mensagens novas não podem chegar na aplicação antes das gravadas que
+	// ainda estão no replay (como o waitGate do RESP e do TCP).
+	if webSocket && !waitWebSocketReplay() {
+		writeError(w, r, errGateTimeout, false)
+		return
+	}
+
+	crController.InFlightRequests.Add(1)
+	inFlightDone := sync.OnceFunc(crController.InFlightRequests.Done)
+	defer inFlightDone()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection upgrade not supported", http.StatusInternalServerError)
+		return
+	}
+
+	record := webSocket && config.GetWebSocketRecordEnabled()
+	if record && crController.WritesRejected() {
+		// Mesma recusa das escritas HTTP: as mensagens seriam gravadas.
+		w.Header().Set("Retry-After", strconv.Itoa(config.GetSnapshotRetryBase()))
+		http.Error(w, "writes rejected: snapshot durability degraded", http.StatusServiceUnavailable)
+		return
+	}
+
+	upstream, base, err := dialApplication()
+	if err != nil {
+		log.Err(err).Msg("Error connecting to application for upgrade")
+		writeError(w, r, classifyUpstreamError(err), false)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	data := config.RequestData{
+		Path: r.URL.Path, RawPath: r.URL.RawPath, Query: r.URL.RawQuery,
+		Host: r.Host, RemoteAddr: r.RemoteAddr, Scheme: scheme, Proto: r.Proto,
+	}
+	outReq, err := upgradeRequest(r.Method, base, data, r.Header.Clone())
+	if err != nil {
+		_ = upstream.Close()
+		log.Err(err).Msg("Error building upgrade request")
+		writeError(w, r, errInterceptor, false)
+		return
+	}
+	// Hop-by-hop fica: Connection/Upgrade são o próprio handshake.
+	setForwardedHeaders(outReq, data)
+	if record {
+		// permessage-deflate comprimiria os payloads (RSV1): sem extensão o
+		// gravador vê as mensagens em claro.
+		outReq.Header.Del("Sec-WebSocket-Extensions")
+	}
+	if err := outReq.Write(upstream); err != nil {
+		_ = upstream.Close()
+		log.Err(err).Msg("Error sending upgrade request to application")
+		writeError(w, r, classifyUpstreamError(err), false)
+		return
+	}
+
+	upstreamR := bufio.NewReader(upstream)
+	resp, err := http.ReadResponse(upstreamR, outReq)
+	if err != nil {
+		_ = upstream.Close()
+		log.Err(err).Msg("Error reading upgrade response from application")
+		writeError(w, r, errUpstreamResponse, true)
+		return
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// Aplicação recusou o upgrade: resposta HTTP comum.
+		defer upstream.Close()
+		defer resp.Body.Close()
+		copyResponseHeader(w.Header(), resp.Header, resp.Proto)
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+		return
+	}
+
+	client, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		_ = upstream.Close()
+		log.Err(err).Msg("Error hijacking client connection")
+		return
+	}
+	resp.Header.Add("Via", viaValue(resp.Proto))
+	_, err = fmt.Fprintf(client, "HTTP/1.1 %s\r\n", resp.Status)
+	if err == nil {
+		err = resp.Header.Write(client)
+	}
+	if err == nil {
+		_, err = io.WriteString(client, "\r\n")
+	}
+	if err != nil {
+		_ = client.Close()
+		_ = upstream.Close()
+		return
+	}
+
+	kind := "upgrade"
+	if webSocket {
+		kind = "websocket"
+	}
+	// Fechar os sockets é o único corte seguro: escrever um close frame daqui
+	// competiria com o túnel upstream->cliente e poderia cair no meio de um
+	// frame.
+	untrack := TrackLongLived(kind, func() {
+		_ = client.Close()
+		_ = upstream.Close()
+	})
+	defer untrack()
+	// Registrado: se o snapshot começou no meio do handshake, o
+	// CloseLongLivedConnections dele pode já ter passado.
+	closing := crController.IsDoingSnapshot.Load() || crController.IsRestoringSnapshot.Load()
+	inFlightDone()
+	if closing {
+		_ = client.Close()
+		_ = upstream.Close()
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(client, upstreamR)
+		done <- struct{}{}
+	}()
+	go func() {
+		if record {
+			recorder := newWebSocketRecorder(r, webSocketConnSeq.Add(1))
+			if err := copyWebSocketFrames(upstream, clientBuf.Reader, recorder); err != nil && err != io.EOF {
+				log.Debug().Err(err).Msg("WebSocket recording stopped")
+			}
+		} else {
+			_, _ = io.Copy(upstream, clientBuf.Reader)
+		}
+		done <- struct{}{}
+	}()
+	<-done
+	_ = client.Close()
+	_ = upstream.Close()
+	<-done
+}
@@ -0,0 +1,174 @@
+package interceptor
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Códigos de erro devolvidos no corpo JSON; o status HTTP sai de cada um.
+const (
+	errInterceptor         = "interceptor_error"
+	errUpstreamUnavailable = "upstream_unavailable"
+	errUpstreamTimeout     = "upstream_timeout"
+	errUpstreamDNS         = "upstream_dns_error"
+	errUpstreamTLS         = "upstream_tls_error"
+	errUpstreamReset       = "upstream_connection_reset"
+	errUpstreamResponse    = "upstream_response_error"
+	errUpstream            = "upstream_error"
+	errGateTimeout         = "gate_timeout"
+	errQueueTimeout        = "queue_timeout"
+	errDeadlineExceeded    = "deadline_exceeded"
+	errDeadlineQueuedWrite = "deadline_exceeded_write_queued"
+	errAsyncCallback       = "async_callback_rejected"
+	errAsyncNotFound       = "async_not_found"
+	errWarmingUp           = "warming_up"
+)
+
+var errorStatus = map[string]int{
+	errInterceptor:         http.StatusInternalServerError,
+	errUpstreamUnavailable: http.StatusServiceUnavailable,
+	errUpstreamTimeout:     http.StatusGatewayTimeout,
+	errUpstreamDNS:         http.StatusBadGateway,
+	errUpstreamTLS:         http.StatusBadGateway,
+	errUpstreamReset:       http.StatusBadGateway,
+	errUpstreamResponse:    http.StatusBadGateway,
+	errUpstream:            http.StatusBadGateway,
+	errGateTimeout:         http.StatusServiceUnavailable,
+	errQueueTimeout:        http.StatusGatewayTimeout,
+	errDeadlineExceeded:    http.StatusGatewayTimeout,
+	errDeadlineQueuedWrite: http.StatusGatewayTimeout,
+	errAsyncCallback:       http.StatusBadRequest,
+	errAsyncNotFound:       http.StatusNotFound,
+	errWarmingUp:           http.StatusServiceUnavailable,
+}
+
+var errorMessages = map[string]string{
+	errInterceptor:         "the interceptor failed to forward the request",
+	errUpstreamUnavailable: "the application is not accepting connections",
+	errUpstreamTimeout:     "the application did not respond in time",
+	errUpstreamDNS:         "the application address could not be resolved",
+	errUpstreamTLS:         "the TLS handshake with the application failed",
+	errUpstreamReset:       "the connection to the application was closed unexpectedly",
+	errUpstreamResponse:    "the application response could not be read",
+	errUpstream:            "the request to the application failed",
+	errGateTimeout:         "timed out waiting for the application to become available",
+	errQueueTimeout:        "timed out waiting for the recovery queue",
+	errDeadlineExceeded:    "the client deadline expired before the request could be forwarded",
+	errDeadlineQueuedWrite: "the client deadline expired while the write was queued; it is still queued and will be applied",
+	errAsyncCallback:       "the async callback URL is not allowed",
+	errAsyncNotFound:       "unknown or expired async request",
+	errWarmingUp:           "the application is warming up after a recovery, retry shortly",
+}
+
+var upstreamErrorsTotal = map[string]*metrics.Counter{}
+
+func init() {
+	for code := range errorStatus {
+		upstreamErrorsTotal[code] = metrics.NewCounter("interceptor_request_errors_total",
+			"Requests answered with an interceptor error body, by cause", "code", code)
+	}
+}
+
+// classifyUpstreamError mapeia o erro do client.Do pra um código.
+func classifyUpstreamError(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var recordErr tls.RecordHeaderError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return errUpstreamTimeout
+	case errors.As(err, &dnsErr):
+		return errUpstreamDNS
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EHOSTUNREACH),
+		errors.Is(err, syscall.ENETUNREACH):
+		return errUpstreamUnavailable
+	case errors.As(err, &recordErr), errors.As(err, &certErr), errors.As(err, &unknownAuthority),
+		errors.As(err, &hostnameErr):
+		return errUpstreamTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return errUpstreamTimeout
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return errUpstreamReset
+	}
+	return errUpstream
+}
+
+// errorResult monta o Result de uma falha; notDelivered indica que nada do
+// request chegou na aplicação (ver forwardBuffered).
+func errorResult(code string, notDelivered bool) config.Result {
+	return config.Result{
+		Status:       errorStatus[code],
+		ErrorCode:    code,
+		NotDelivered: notDelivered,
+	}
+}
+
+// ensureCorrelationID garante o header de correlação no request do cliente
+// (e portanto na cópia encaminhada à aplicação) e o devolve.
+func ensureCorrelationID(r *http.Request) string {
+	header := config.GetCorrelationIDHeader()
+	if id := r.Header.Get(header); id != "" {
+		return id
+	}
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	id := hex.EncodeToString(b)
+	r.Header.Set(header, id)
+	return id
+}
+
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Status        int    `json:"status"`
+	Code          string `json:"code"`
+	Message       string `json:"message"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	Delivered     bool   `json:"delivered"`
+}
+
+// writeError responde com o corpo JSON estruturado, distinguível de um erro
+// da própria aplicação (que passa intocado).
+func writeError(w http.ResponseWriter, r *http.Request, code string, delivered bool) {
+	status := errorStatus[code]
+	if counter := upstreamErrorsTotal[code]; counter != nil {
+		counter.Inc()
+	}
+	header := config.GetCorrelationIDHeader()
+	correlationID := r.Header.Get(header)
+	body, _ := json.Marshal(errorBody{Error: errorDetail{
+		Status:        status,
+		Code:          code,
+		Message:       errorMessages[code],
+		CorrelationID: correlationID,
+		Delivered:     delivered,
+	}})
+	if correlationID != "" {
+		w.Header().Set(header, correlationID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if _, err := w.Write(append(body, '\n')); err != nil {
+		log.Err(err).Msg("Error writing error response")
+	}
+}
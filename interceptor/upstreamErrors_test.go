@@ -0,0 +1,116 @@
+package interceptor
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyUpstreamError(t *testing.T) {
+	// Como o client.Do entrega: *url.Error em volta de *net.OpError.
+	wrap := func(err error) error {
+		return &url.Error{Op: "Post", URL: "http://app/", Err: &net.OpError{Op: "dial", Net: "tcp", Err: err}}
+	}
+	cases := []struct {
+		name string
+		err  error
+		code string
+		want int
+	}{
+		{"refused", wrap(&os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}), errUpstreamUnavailable, http.StatusServiceUnavailable},
+		{"host unreachable", wrap(syscall.EHOSTUNREACH), errUpstreamUnavailable, http.StatusServiceUnavailable},
+		{"network unreachable", wrap(syscall.ENETUNREACH), errUpstreamUnavailable, http.StatusServiceUnavailable},
+		{"context deadline", &url.Error{Op: "Get", URL: "http://app/", Err: context.DeadlineExceeded}, errUpstreamTimeout, http.StatusGatewayTimeout},
+		{"i/o timeout", wrap(os.ErrDeadlineExceeded), errUpstreamTimeout, http.StatusGatewayTimeout},
+		{"dns", wrap(&net.DNSError{Err: "no such host", Name: "app", IsNotFound: true}), errUpstreamDNS, http.StatusBadGateway},
+		{"dns timeout", wrap(&net.DNSError{Err: "timeout", Name: "app", IsTimeout: true}), errUpstreamDNS, http.StatusBadGateway},
+		{"plain http to tls", wrap(tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}), errUpstreamTLS, http.StatusBadGateway},
+		{"unknown authority", wrap(&tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}), errUpstreamTLS, http.StatusBadGateway},
+		{"hostname", wrap(x509.HostnameError{Host: "app"}), errUpstreamTLS, http.StatusBadGateway},
+		{"reset", wrap(&os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}), errUpstreamReset, http.StatusBadGateway},
+		{"eof", &url.Error{Op: "Get", URL: "http://app/", Err: io.EOF}, errUpstreamReset, http.StatusBadGateway},
+		{"unexpected eof", fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF), errUpstreamReset, http.StatusBadGateway},
+		{"other", errors.New("boom"), errUpstream, http.StatusBadGateway},
+	}
+	for _, c := range cases {
+		code := classifyUpstreamError(c.err)
+		if code != c.code || errorStatus[code] != c.want {
+			t.Errorf("%s: %q (%d), want %q (%d)", c.name, code, errorStatus[code], c.code, c.want)
+		}
+	}
+
+	// Um dial real numa porta fechada.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	_, err = http.Get("http://" + addr + "/")
+	if code := classifyUpstreamError(err); code != errUpstreamUnavailable {
+		t.Errorf("closed port: %q (%v)", code, err)
+	}
+}
+
+func TestErrorCodesComplete(t *testing.T) {
+	for code, status := range errorStatus {
+		if status < 400 || errorMessages[code] == "" || upstreamErrorsTotal[code] == nil {
+			t.Errorf("%q: status %d, message %q", code, status, errorMessages[code])
+		}
+	}
+	if len(errorMessages) != len(errorStatus) {
+		t.Errorf("%d messages for %d codes", len(errorMessages), len(errorStatus))
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	t.Setenv("CORRELATION_ID_HEADER", "X-Request-Id")
+	r := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	r.Header.Set("X-Request-Id", "abc123")
+	w := httptest.NewRecorder()
+	before := upstreamErrorsTotal[errUpstreamTimeout].Value()
+	writeError(w, r, errUpstreamTimeout, true)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("status %d", w.Code)
+	}
+	if w.Header().Get("Content-Type") != "application/json" || w.Header().Get("X-Request-Id") != "abc123" {
+		t.Errorf("headers %v", w.Header())
+	}
+	var body errorBody
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := errorDetail{
+		Status: http.StatusGatewayTimeout, Code: errUpstreamTimeout, Message: errorMessages[errUpstreamTimeout],
+		CorrelationID: "abc123", Delivered: true,
+	}
+	if body.Error != want {
+		t.Errorf("body %+v", body.Error)
+	}
+	if got := upstreamErrorsTotal[errUpstreamTimeout].Value() - before; got != 1 {
+		t.Errorf("counter moved by %d", got)
+	}
+
+	// Sem correlação: o campo some do JSON.
+	w = httptest.NewRecorder()
+	writeError(w, httptest.NewRequest(http.MethodGet, "/", nil), errUpstreamDNS, false)
+	var raw map[string]map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := raw["error"]["correlation_id"]; ok || raw["error"]["delivered"] != false || w.Code != http.StatusBadGateway {
+		t.Errorf("body without correlation id: %d %v", w.Code, raw)
+	}
+}
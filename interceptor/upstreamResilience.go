@@ -0,0 +1,229 @@
+package interceptor
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+	"interceptor-grpc/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+var upstreamRetriesTotal = metrics.NewCounter("interceptor_upstream_retries_total",
+	"Requests re-sent to the application after a transport failure")
+var retryBudgetExhaustedTotal = metrics.NewCounter("interceptor_upstream_retry_budget_exhausted_total",
+	"Retries skipped because the retry budget was exhausted")
+var circuitTripsTotal = metrics.NewCounter("interceptor_upstream_circuit_trips_total",
+	"Times the upstream circuit breaker opened")
+
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// retryMethods: nil = todos (UPSTREAM_RETRY_METHODS=all).
+var retryMethods = sync.OnceValue(func() map[string]bool {
+	spec := config.GetUpstreamRetryMethods()
+	switch spec {
+	case "all":
+		return nil
+	case "idempotent":
+		return idempotentMethods
+	}
+	methods := map[string]bool{}
+	for _, method := range strings.Split(spec, ",") {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			methods[method] = true
+		}
+	}
+	return methods
+})
+
+// retryable: o que nunca chegou na aplicação sempre pode ser repetido; o
+// que chegou só quando o método permite e a falha foi no meio da resposta.
+func retryable(method string, res config.Result) bool {
+	switch {
+	case res.ErrorCode == "" || res.ErrorCode == errInterceptor:
+		return false
+	case res.NotDelivered:
+		return true
+	case res.ErrorCode != errUpstreamReset && res.ErrorCode != errUpstreamResponse:
+		return false
+	}
+	methods := retryMethods()
+	return methods == nil || methods[method]
+}
+
+// retryBudget é um balde de fichas: cada request deposita
+// UPSTREAM_RETRY_BUDGET_RATIO, cada repetição saca uma, e
+// UPSTREAM_RETRY_MIN_PER_SECOND entram por segundo. Com a aplicação caída o
+// retry fica limitado a uma fração do tráfego em vez de multiplicá-lo.
+type retryBudget struct {
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+var upstreamRetryBudget = &retryBudget{}
+
+// Teto do balde: um pico de tráfego não acumula crédito ilimitado.
+const maxRetryTokens = 100
+
+func (b *retryBudget) refill(now time.Time) {
+	minPerSecond := float64(config.GetUpstreamRetryMinPerSecond())
+	if b.last.IsZero() {
+		// Começa com um segundo de crédito mínimo.
+		b.tokens = minPerSecond
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * minPerSecond
+	}
+	b.last = now
+	if b.tokens > maxRetryTokens {
+		b.tokens = maxRetryTokens
+	}
+}
+
+func (b *retryBudget) deposit() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(time.Now())
+	b.tokens += config.GetUpstreamRetryBudgetRatio()
+	if b.tokens > maxRetryTokens {
+		b.tokens = maxRetryTokens
+	}
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sendWithRetry envia com o mesmo número de request em todas as tentativas:
+// a aplicação vê o mesmo Interceptor-Controller e pode deduplicar.
+func sendWithRetry(data config.RequestData, requestNumber uint64) config.Result {
+	upstreamRetryBudget.deposit()
+	backoff := time.Duration(config.GetUpstreamRetryBackoffMs()) * time.Millisecond
+	maxBackoff := time.Duration(config.GetUpstreamRetryBackoffMaxMs()) * time.Millisecond
+	maxRetries := config.GetUpstreamRetryMax()
+
+	for attempt := 0; ; attempt++ {
+		res := sendRequest(data, requestNumber)
+		upstreamBreaker.record(res)
+		// Gate fechado ou circuito aberto: repetir só martelaria uma aplicação
+		// que já se sabe indisponível.
+		if attempt >= maxRetries || !retryable(data.Method, res) ||
+			crController.IsContainerUnavailable.Load() || crController.UpstreamCircuitOpen.Load() ||
+			(!data.Deadline.IsZero() && time.Now().Add(backoff).After(data.Deadline)) {
+			return res
+		}
+		if !upstreamRetryBudget.withdraw() {
+			retryBudgetExhaustedTotal.Inc()
+			return res
+		}
+		upstreamRetriesTotal.Inc()
+		// Jitter de até 50% pra repetições simultâneas não chegarem juntas.
+		time.Sleep(backoff/2 + rand.N(backoff/2+1))
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// circuitBreaker abre após UPSTREAM_BREAKER_THRESHOLD falhas de transporte
+// seguidas. Aberto, requests novos vão pra fila de recuperação (que não
+// drena) e uma sonda tenta conectar na aplicação a cada
+// UPSTREAM_BREAKER_COOLDOWN; a primeira conexão bem-sucedida fecha o
+// circuito e a fila drena.
+type circuitBreaker struct {
+	consecutiveFailures atomic.Int64
+}
+
+var upstreamBreaker = &circuitBreaker{}
+
+// Só falhas que indicam aplicação inalcançável contam; TLS mal configurado,
+// por exemplo, não se resolve segurando o tráfego.
+var breakerFailureCodes = map[string]bool{
+	errUpstreamUnavailable: true,
+	errUpstreamReset:       true,
+	errUpstreamTimeout:     true,
+	errUpstreamDNS:         true,
+}
+
+func (b *circuitBreaker) record(res config.Result) {
+	threshold := config.GetUpstreamBreakerThreshold()
+	if threshold == 0 {
+		return
+	}
+	if !breakerFailureCodes[res.ErrorCode] {
+		if res.ErrorCode == "" {
+			b.consecutiveFailures.Store(0)
+		}
+		return
+	}
+	failures := b.consecutiveFailures.Add(1)
+	if failures >= int64(threshold) && crController.UpstreamCircuitOpen.CompareAndSwap(false, true) {
+		circuitTripsTotal.Inc()
+		log.Warn().Int64("consecutive_failures", failures).Str("last_error", res.ErrorCode).
+			Msg("Upstream circuit breaker opened, routing requests to the recovery queue")
+		go b.probe()
+	}
+}
+
+func (b *circuitBreaker) probe() {
+	cooldown := time.Duration(config.GetUpstreamBreakerCooldown()) * time.Second
+	opened := time.Now()
+	for {
+		time.Sleep(cooldown)
+		// Com o gate de snapshot fechado nenhuma conexão nova pode abrir (o
+		// CRIU não faz dump com socket aberto). O probe conta em
+		// InFlightRequests, então um snapshot que comece depois da checagem
+		// espera o dial terminar.
+		crController.InFlightRequests.Add(1)
+		if crController.IsDoingSnapshot.Load() || crController.IsRestoringSnapshot.Load() {
+			crController.InFlightRequests.Done()
+			continue
+		}
+		conn, _, err := dialApplication()
+		if err == nil {
+			_ = conn.Close()
+		}
+		crController.InFlightRequests.Done()
+		if err != nil {
+			log.Debug().Err(err).Msg("Upstream circuit breaker probe failed")
+			continue
+		}
+		b.consecutiveFailures.Store(0)
+		crController.UpstreamCircuitOpen.Store(false)
+		log.Info().Dur("open_for", time.Since(opened).Round(time.Millisecond)).
+			Msg("Upstream circuit breaker closed, draining recovery queue")
+		return
+	}
+}
+
+func init() {
+	metrics.NewGaugeFunc("interceptor_upstream_circuit_open",
+		"1 while the upstream circuit breaker is open",
+		func() float64 {
+			if crController.UpstreamCircuitOpen.Load() {
+				return 1
+			}
+			return 0
+		})
+}
@@ -0,0 +1,145 @@
+package interceptor
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"interceptor-grpc/config"
+)
+
+func TestRetryable(t *testing.T) {
+	cases := []struct {
+		method string
+		res    config.Result
+		want   bool
+	}{
+		{http.MethodPost, config.Result{Status: http.StatusServiceUnavailable}, false},
+		{http.MethodPost, errorResult(errInterceptor, true), false},
+		// Nunca chegou na aplicação: qualquer método.
+		{http.MethodPost, errorResult(errUpstreamUnavailable, true), true},
+		// Chegou: só idempotente (o default) e só falha no meio da resposta.
+		{http.MethodPost, errorResult(errUpstreamReset, false), false},
+		{http.MethodPut, errorResult(errUpstreamReset, false), true},
+		{http.MethodGet, errorResult(errUpstreamResponse, false), true},
+		{http.MethodGet, errorResult(errUpstreamTimeout, false), false},
+	}
+	for _, c := range cases {
+		if got := retryable(c.method, c.res); got != c.want {
+			t.Errorf("retryable(%s, %+v) = %v", c.method, c.res, got)
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	t.Setenv("UPSTREAM_RETRY_MIN_PER_SECOND", "0")
+	t.Setenv("UPSTREAM_RETRY_BUDGET_RATIO", "0.5")
+	b := &retryBudget{}
+	if b.withdraw() {
+		t.Fatal("withdrew from an empty budget")
+	}
+	b.deposit()
+	b.deposit()
+	if !b.withdraw() {
+		t.Fatal("two requests at ratio 0.5 did not pay for one retry")
+	}
+	if b.withdraw() {
+		t.Fatal("withdrew twice from one token")
+	}
+
+	// O mínimo por segundo entra com o tempo, até o teto.
+	t.Setenv("UPSTREAM_RETRY_MIN_PER_SECOND", "10")
+	b.last = time.Now().Add(-2 * time.Second)
+	b.refill(time.Now())
+	if b.tokens < 19.9 || b.tokens > 20.1 {
+		t.Errorf("tokens after 2s at 10/s = %v", b.tokens)
+	}
+	b.last = time.Now().Add(-time.Hour)
+	b.refill(time.Now())
+	if b.tokens != maxRetryTokens {
+		t.Errorf("tokens after an hour = %v, want the cap", b.tokens)
+	}
+}
+
+// flakyApplication derruba a conexão nos primeiros failures requests, depois
+// da aplicação já tê-los lido, e grava o Interceptor-Controller de cada um.
+func flakyApplication(t *testing.T, failures int) (*httptest.Server, func() []string) {
+	var mutex sync.Mutex
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		seen = append(seen, r.Header.Get("Interceptor-Controller"))
+		fail := len(seen) <= failures
+		mutex.Unlock()
+		if fail {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), seen...)
+	}
+}
+
+func TestSendWithRetry(t *testing.T) {
+	t.Setenv("UPSTREAM_BREAKER_THRESHOLD", "0")
+	t.Setenv("UPSTREAM_RETRY_BACKOFF_MS", "1")
+	t.Setenv("UPSTREAM_RETRY_MAX", "2")
+	upstreamRetryBudget = &retryBudget{}
+
+	server, seen := flakyApplication(t, 1)
+	t.Setenv("APPLICATION_URL", server.URL)
+	res := sendWithRetry(config.RequestData{Method: http.MethodPut, Path: "/x"}, 77)
+	if res.Status != http.StatusNoContent {
+		t.Fatalf("PUT after one reset: %+v", res)
+	}
+	if got := seen(); len(got) != 2 || got[0] != "77" || got[1] != "77" {
+		t.Errorf("attempts carried Interceptor-Controller %q, want the same number twice", got)
+	}
+
+	// POST que chegou na aplicação não se repete.
+	server, seen = flakyApplication(t, 10)
+	t.Setenv("APPLICATION_URL", server.URL)
+	res = sendWithRetry(config.RequestData{Method: http.MethodPost, Path: "/x"}, 78)
+	if res.ErrorCode == "" || res.NotDelivered || len(seen()) != 1 {
+		t.Errorf("POST after reset: %+v, %d attempts", res, len(seen()))
+	}
+
+	// Aplicação fora do ar: nada foi entregue, repete até UPSTREAM_RETRY_MAX.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := "http://" + lis.Addr().String()
+	_ = lis.Close()
+	t.Setenv("APPLICATION_URL", closed)
+	before := upstreamRetriesTotal.Value()
+	res = sendWithRetry(config.RequestData{Method: http.MethodPost, Path: "/x"}, 79)
+	if res.ErrorCode != errUpstreamUnavailable || !res.NotDelivered {
+		t.Errorf("refused connection: %+v", res)
+	}
+	if retries := upstreamRetriesTotal.Value() - before; retries != 2 {
+		t.Errorf("%d retries, want UPSTREAM_RETRY_MAX", retries)
+	}
+
+	// Sem orçamento não há repetição.
+	t.Setenv("UPSTREAM_RETRY_MIN_PER_SECOND", "0")
+	t.Setenv("UPSTREAM_RETRY_BUDGET_RATIO", "0")
+	upstreamRetryBudget = &retryBudget{}
+	before = upstreamRetriesTotal.Value()
+	sendWithRetry(config.RequestData{Method: http.MethodPost, Path: "/x"}, 80)
+	if retries := upstreamRetriesTotal.Value() - before; retries != 0 {
+		t.Errorf("%d retries with an empty budget", retries)
+	}
+	upstreamRetryBudget = &retryBudget{}
+}
@@ -0,0 +1,392 @@
+package interceptor
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+
+	"github.com/rs/zerolog/log"
+)
+
+// WebSocketProtocol é o valor de config.RequestData.Protocol das mensagens
+// WebSocket gravadas (WEBSOCKET_RECORD).
+const WebSocketProtocol = "websocket"
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+)
+
+// Header interno das mensagens gravadas com o id da conexão de origem: o
+// replay abre uma conexão por conexão original. Não vai no handshake.
+const wsConnectionHeader = "Interceptor-Websocket-Connection"
+
+var webSocketConnSeq atomic.Uint64
+
+var errWritesRejected = errors.New("writes rejected: snapshot durability degraded")
+
+// Headers do handshake que não fazem sentido numa conexão nova de replay.
+var wsHandshakeOnlyHeaders = []string{
+	"Connection", "Upgrade", "Sec-Websocket-Key", "Sec-Websocket-Version",
+	"Sec-Websocket-Extensions", "Content-Length",
+}
+
+type wsFrameHeader struct {
+	fin    bool
+	opcode byte
+	masked bool
+	mask   [4]byte
+	length uint64
+	raw    []byte
+}
+
+func readWebSocketFrameHeader(r *bufio.Reader) (wsFrameHeader, error) {
+	var h wsFrameHeader
+	head := make([]byte, 2, 14)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return h, err
+	}
+	h.fin = head[0]&0x80 != 0
+	h.opcode = head[0] & 0x0F
+	h.masked = head[1]&0x80 != 0
+	h.length = uint64(head[1] & 0x7F)
+
+	switch h.length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return h, err
+		}
+		head = append(head, ext...)
+		h.length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return h, err
+		}
+		head = append(head, ext...)
+		h.length = binary.BigEndian.Uint64(ext)
+	}
+	if h.masked {
+		if _, err := io.ReadFull(r, h.mask[:]); err != nil {
+			return h, err
+		}
+		head = append(head, h.mask[:]...)
+	}
+	h.raw = head
+	return h, nil
+}
+
+func writeWebSocketFrame(w io.Writer, opcode byte, payload []byte) error {
+	// Frames cliente->servidor precisam ser mascarados (RFC 6455 5.3).
+	header := []byte{0x80 | opcode, 0x80}
+	switch {
+	case len(payload) < 126:
+		header[1] |= byte(len(payload))
+	case len(payload) <= 0xFFFF:
+		header[1] |= 126
+		header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	default:
+		header[1] |= 127
+		header = binary.BigEndian.AppendUint64(header, uint64(len(payload)))
+	}
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	header = append(header, mask[:]...)
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(masked)
+	return err
+}
+
+// webSocketRecorder remonta mensagens (frames + continuações) do sentido
+// cliente->aplicação e grava cada mensagem de dados completa no buffer.
+type webSocketRecorder struct {
+	path    string
+	rawPath string
+	query   string
+	host    string
+	header  http.Header
+	max     int
+
+	opcode byte
+	buf    []byte
+	skip   bool
+}
+
+func newWebSocketRecorder(r *http.Request, connID uint64) *webSocketRecorder {
+	header := r.Header.Clone()
+	for _, name := range wsHandshakeOnlyHeaders {
+		header.Del(name)
+	}
+	header.Set(wsConnectionHeader, strconv.FormatUint(connID, 10))
+	return &webSocketRecorder{
+		path:    r.URL.Path,
+		rawPath: r.URL.RawPath,
+		query:   r.URL.RawQuery,
+		host:    r.Host,
+		header:  header,
+		max:     config.GetWebSocketRecordMaxMessage(),
+	}
+}
+
+// copyWebSocketFrames repassa os frames do cliente pra aplicação sem
+// alterá-los, gravando as mensagens de dados pelo caminho. Payloads acima do
+// limite são repassados em streaming e a mensagem inteira fica sem registro.
+func copyWebSocketFrames(dst io.Writer, src *bufio.Reader, rec *webSocketRecorder) error {
+	for {
+		h, err := readWebSocketFrameHeader(src)
+		if err != nil {
+			return err
+		}
+		// Mensagem nova com DURABILITY_HARD_MODE: não chega na aplicação sem
+		// gravação; a conexão é encerrada antes do frame.
+		if h.opcode == wsOpText || h.opcode == wsOpBinary {
+			if crController.WritesRejected() {
+				return errWritesRejected
+			}
+		}
+		if _, err := dst.Write(h.raw); err != nil {
+			return err
+		}
+		control := h.opcode >= wsOpClose
+		if control || h.length > uint64(rec.max) {
+			if _, err := io.CopyN(dst, src, int64(h.length)); err != nil {
+				return err
+			}
+			if !control {
+				rec.discard(h)
+			}
+			continue
+		}
+		payload := make([]byte, h.length)
+		if _, err := io.ReadFull(src, payload); err != nil {
+			return err
+		}
+		if _, err := dst.Write(payload); err != nil {
+			return err
+		}
+		if h.masked {
+			for i := range payload {
+				payload[i] ^= h.mask[i%4]
+			}
+		}
+		rec.frame(h, payload)
+	}
+}
+
+func (rec *webSocketRecorder) discard(h wsFrameHeader) {
+	if h.opcode != wsOpContinuation {
+		rec.opcode = h.opcode
+	}
+	rec.buf, rec.skip = nil, true
+	if h.fin {
+		rec.skip = false
+	}
+}
+
+func (rec *webSocketRecorder) frame(h wsFrameHeader, payload []byte) {
+	if h.opcode != wsOpContinuation {
+		rec.opcode, rec.buf, rec.skip = h.opcode, nil, false
+	}
+	if !rec.skip {
+		rec.buf = append(rec.buf, payload...)
+		if len(rec.buf) > rec.max {
+			log.Warn().Str("path", rec.path).Int("max", rec.max).
+				Msg("WebSocket message exceeds WEBSOCKET_RECORD_MAX_MESSAGE, not recorded")
+			rec.buf, rec.skip = nil, true
+		}
+	}
+	if !h.fin {
+		return
+	}
+	if !rec.skip {
+		method := "BINARY"
+		if rec.opcode == wsOpText {
+			method = "TEXT"
+		}
+		requestNumber := config.SaveRequestToBuffer(config.RequestData{
+			Protocol: WebSocketProtocol,
+			Method:   method,
+			Path:     rec.path,
+			RawPath:  rec.rawPath,
+			Query:    rec.query,
+			Host:     rec.host,
+			Header:   rec.header,
+			Body:     rec.buf,
+		})
+		// O frame já foi repassado: não há resposta a esperar.
+		config.UpdateRequestToProcessed(requestNumber)
+	}
+	rec.buf, rec.skip = nil, false
+}
+
+var WebSocketReplayQueueLength = atomic.Uint32{}
+var webSocketReplayQueue = make([]config.RequestData, 0)
+var webSocketReplayMutex sync.Mutex
+
+// AddWebSocketToReplayQueue enfileira uma mensagem WebSocket gravada pra
+// replay. Registrada via crController.RegisterProtocolReprocessCallback.
+func AddWebSocketToReplayQueue(data config.RequestData) {
+	webSocketReplayMutex.Lock()
+	defer webSocketReplayMutex.Unlock()
+
+	webSocketReplayQueue = append(webSocketReplayQueue, data)
+	WebSocketReplayQueueLength.Add(1)
+}
+
+func takeWebSocketReplayQueue() []config.RequestData {
+	webSocketReplayMutex.Lock()
+	defer webSocketReplayMutex.Unlock()
+
+	batch := webSocketReplayQueue
+	webSocketReplayQueue = make([]config.RequestData, 0)
+	return batch
+}
+
+func requeueWebSocketFront(batch []config.RequestData) {
+	webSocketReplayMutex.Lock()
+	defer webSocketReplayMutex.Unlock()
+
+	webSocketReplayQueue = append(batch, webSocketReplayQueue...)
+	WebSocketReplayQueueLength.Store(uint32(len(webSocketReplayQueue)))
+}
+
+// ProcessWebSocketReplayQueue reenvia as mensagens gravadas, em ordem. Cada
+// conexão original ganha uma conexão de replay nova, aberta na primeira
+// mensagem dela e mantida até o fim do lote: mensagens de conexões
+// intercaladas não se misturam e a ordem global é mantida.
+func ProcessWebSocketReplayQueue() {
+	for {
+		time.Sleep(50 * time.Millisecond)
+
+		if WebSocketReplayQueueLength.Load() == 0 {
+			continue
+		}
+		if crController.IsDoingSnapshot.Load() || crController.IsRestoringSnapshot.Load() ||
+			crController.IsContainerUnavailable.Load() {
+			continue
+		}
+
+		batch := takeWebSocketReplayQueue()
+		crController.InFlightRequests.Add(1)
+		sent := replayWebSocketBatch(batch)
+		crController.InFlightRequests.Done()
+		requeueWebSocketFront(batch[sent:])
+		log.Info().Int("replayed", sent).Msg("WebSocket replay: recorded messages re-sent")
+	}
+}
+
+func replayWebSocketBatch(batch []config.RequestData) int {
+	conns := map[string]net.Conn{}
+	defer func() {
+		for _, conn := range conns {
+			_ = writeWebSocketFrame(conn, wsOpClose, []byte{0x03, 0xE8})
+		}
+		if len(conns) > 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	for i, data := range batch {
+		// Entradas sem id (gravadas antes dele existir) caem no path/query.
+		key := data.Header.Get(wsConnectionHeader)
+		if key == "" {
+			key = data.Path + "?" + data.Query
+		}
+		conn, ok := conns[key]
+		if !ok {
+			c, err := openWebSocket(data)
+			if err != nil {
+				log.Err(err).Str("path", data.Path).Msg("WebSocket replay: handshake failed, will retry")
+				return i
+			}
+			conn = c
+			conns[key] = conn
+		}
+		opcode := byte(wsOpBinary)
+		if data.Method == "TEXT" {
+			opcode = wsOpText
+		}
+		requestNumber := config.SaveRequestToBuffer(data)
+		if err := writeWebSocketFrame(conn, opcode, data.Body); err != nil {
+			config.RemoveRequestFromBuffer(requestNumber)
+			log.Err(err).Msg("WebSocket replay: upstream error, will retry")
+			_ = conn.Close()
+			delete(conns, key)
+			return i
+		}
+		config.UpdateRequestToProcessed(requestNumber)
+	}
+	return len(batch)
+}
+
+// openWebSocket faz o handshake de uma conexão de replay com os headers
+// gravados e passa a descartar o que a aplicação enviar.
+func openWebSocket(data config.RequestData) (net.Conn, error) {
+	conn, base, err := dialApplication()
+	if err != nil {
+		return nil, err
+	}
+	keyBytes := make([]byte, 16)
+	if _, err := rand.Read(keyBytes); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	header := data.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Del(wsConnectionHeader)
+	header.Set("Connection", "Upgrade")
+	header.Set("Upgrade", "websocket")
+	header.Set("Sec-WebSocket-Version", "13")
+	header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(keyBytes))
+
+	req, err := upgradeRequest(http.MethodGet, base, data, header)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		_ = conn.Close()
+		return nil, fmt.Errorf("websocket handshake rejected: %s", resp.Status)
+	}
+	go func() { _, _ = io.Copy(io.Discard, r) }()
+	return conn, nil
+}
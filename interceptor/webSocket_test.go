@@ -0,0 +1,302 @@
+package interceptor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+)
+
+// clientFrame codifica um frame cliente->servidor, com fin e opcode livres
+// (writeWebSocketFrame só escreve mensagens de um frame).
+func clientFrame(fin bool, opcode byte, payload []byte) []byte {
+	var frame bytes.Buffer
+	_ = writeWebSocketFrame(&frame, opcode, payload)
+	raw := frame.Bytes()
+	if !fin {
+		raw[0] &^= 0x80
+	}
+	return raw
+}
+
+func readFrame(t *testing.T, r *bufio.Reader) (wsFrameHeader, []byte) {
+	t.Helper()
+	h, err := readWebSocketFrameHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, h.length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	for i := range payload {
+		payload[i] ^= h.mask[i%4]
+	}
+	return h, payload
+}
+
+func TestWebSocketFrameRoundTrip(t *testing.T) {
+	// Tamanho curto, com extensão de 16 e de 64 bits.
+	for _, size := range []int{5, 125, 126, 300, 0xFFFF, 70000} {
+		payload := bytes.Repeat([]byte{'x'}, size)
+		raw := clientFrame(true, wsOpBinary, payload)
+		h, got := readFrame(t, bufio.NewReader(bytes.NewReader(raw)))
+		if !h.fin || h.opcode != wsOpBinary || !h.masked || h.length != uint64(size) {
+			t.Errorf("size %d: header %+v", size, h)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("size %d: payload not unmasked", size)
+		}
+		if len(h.raw)+size != len(raw) {
+			t.Errorf("size %d: raw header %d bytes", size, len(h.raw))
+		}
+	}
+
+	// Frame do servidor, sem máscara e com extensão de 16 bits.
+	raw := []byte{0x81, 126}
+	raw = binary.BigEndian.AppendUint16(raw, 200)
+	h, err := readWebSocketFrameHeader(bufio.NewReader(bytes.NewReader(append(raw, make([]byte, 200)...))))
+	if err != nil || h.masked || h.length != 200 || len(h.raw) != 4 {
+		t.Errorf("unmasked frame: %+v, %v", h, err)
+	}
+	if _, err := readWebSocketFrameHeader(bufio.NewReader(bytes.NewReader([]byte{0x82, 127, 0}))); err == nil {
+		t.Error("truncated extended length accepted")
+	}
+}
+
+func TestWebSocketRecorder(t *testing.T) {
+	t.Setenv("WEBSOCKET_RECORD_MAX_MESSAGE", "8")
+	r := httptest.NewRequest(http.MethodGet, "http://app.example/chat%2Fa?room=1", nil)
+	r.Header.Set("Sec-WebSocket-Key", "abc")
+	r.Header.Set("Authorization", "Bearer x")
+
+	var stream bytes.Buffer
+	stream.Write(clientFrame(false, wsOpText, []byte("hel")))
+	stream.Write(clientFrame(true, 0x9, []byte("ping")))
+	stream.Write(clientFrame(true, wsOpContinuation, []byte("lo")))
+	stream.Write(clientFrame(true, wsOpBinary, []byte("too large!")))
+	stream.Write(clientFrame(false, wsOpText, []byte("abcde")))
+	stream.Write(clientFrame(true, wsOpContinuation, []byte("fghij")))
+	stream.Write(clientFrame(true, wsOpBinary, []byte{1, 2}))
+	sent := append([]byte(nil), stream.Bytes()...)
+
+	before := config.GetLatestRequestNumber()
+	var forwarded bytes.Buffer
+	err := copyWebSocketFrames(&forwarded, bufio.NewReader(&stream), newWebSocketRecorder(r, 42))
+	if err != io.EOF {
+		t.Fatalf("copy ended with %v", err)
+	}
+	// Repassado sem alteração, gravado ou não.
+	if !bytes.Equal(forwarded.Bytes(), sent) {
+		t.Error("frames altered on the way to the application")
+	}
+
+	var recorded []*config.BufferedRequest
+	for _, entry := range config.GetReprocessableRequests() {
+		if entry.RequestNumber > before {
+			recorded = append(recorded, entry)
+			config.RemoveRequestFromBuffer(entry.RequestNumber)
+		}
+	}
+	// Controle não entra; acima de WEBSOCKET_RECORD_MAX_MESSAGE (num frame ou
+	// somando as continuações) a mensagem inteira fica fora.
+	if len(recorded) != 2 {
+		t.Fatalf("recorded %d messages, want 2", len(recorded))
+	}
+	// Entradas de protocolo não são comprimidas: o corpo é a mensagem.
+	text, bin := recorded[0].Data, recorded[1].Data
+	if text.Method != "TEXT" || string(text.Body) != "hello" {
+		t.Errorf("text message %q %q", text.Method, text.Body)
+	}
+	if bin.Method != "BINARY" || !bytes.Equal(bin.Body, []byte{1, 2}) {
+		t.Errorf("binary message %q %v", bin.Method, bin.Body)
+	}
+	if text.Protocol != WebSocketProtocol || text.Path != "/chat/a" || text.RawPath != "/chat%2Fa" ||
+		text.Query != "room=1" || text.Host != "app.example" {
+		t.Errorf("message target %+v", text)
+	}
+	if text.Header.Get(wsConnectionHeader) != "42" || text.Header.Get("Sec-WebSocket-Key") != "" ||
+		text.Header.Get("Authorization") != "Bearer x" {
+		t.Errorf("message headers %v", text.Header)
+	}
+}
+
+func TestUpgradeRequestTarget(t *testing.T) {
+	base, _ := url.Parse("http://app:8080/base/")
+	data := config.RequestData{Path: "/chat/a", RawPath: "/chat%2Fa", Query: "room=1", Host: "public.example"}
+	req, err := upgradeRequest(http.MethodGet, base, data, http.Header{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.URL.RequestURI(); got != "/base/chat%2Fa?room=1" {
+		t.Errorf("request URI %q", got)
+	}
+	if req.Host != "app:8080" {
+		t.Errorf("Host %q without PRESERVE_HOST", req.Host)
+	}
+	t.Setenv("PRESERVE_HOST", "true")
+	if req, _ = upgradeRequest(http.MethodGet, base, data, http.Header{}); req.Host != "public.example" {
+		t.Errorf("Host %q with PRESERVE_HOST", req.Host)
+	}
+}
+
+// webSocketApplication aceita handshakes e grava, por conexão, o alvo do
+// handshake e as mensagens recebidas.
+type webSocketApplication struct {
+	mutex    sync.Mutex
+	targets  []string
+	messages [][]string
+}
+
+func newWebSocketApplication(t *testing.T) (*webSocketApplication, *httptest.Server) {
+	app := &webSocketApplication{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-WebSocket-Key") == "" {
+			http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		app.mutex.Lock()
+		index := len(app.targets)
+		app.targets = append(app.targets, r.Host+" "+r.RequestURI)
+		app.messages = append(app.messages, nil)
+		app.mutex.Unlock()
+		_, _ = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		for {
+			h, err := readWebSocketFrameHeader(rw.Reader)
+			if err != nil {
+				return
+			}
+			payload := make([]byte, h.length)
+			if _, err := io.ReadFull(rw.Reader, payload); err != nil {
+				return
+			}
+			for i := range payload {
+				payload[i] ^= h.mask[i%4]
+			}
+			if h.opcode == wsOpClose {
+				return
+			}
+			app.mutex.Lock()
+			app.messages[index] = append(app.messages[index], string(payload))
+			app.mutex.Unlock()
+		}
+	}))
+	t.Cleanup(server.Close)
+	return app, server
+}
+
+func TestReplayWebSocketBatch(t *testing.T) {
+	app, server := newWebSocketApplication(t)
+	t.Setenv("APPLICATION_URL", server.URL+"/base")
+	t.Setenv("PRESERVE_HOST", "true")
+
+	message := func(conn, body string) config.RequestData {
+		return config.RequestData{
+			Protocol: WebSocketProtocol, Method: "TEXT", Path: "/chat/a", RawPath: "/chat%2Fa",
+			Host: "public.example", Header: http.Header{wsConnectionHeader: {conn}}, Body: []byte(body),
+		}
+	}
+	// Duas conexões originais intercaladas: cada uma ganha a sua, em ordem.
+	batch := []config.RequestData{message("1", "a1"), message("2", "b1"), message("1", "a2")}
+	before := config.GetLatestRequestNumber()
+	if sent := replayWebSocketBatch(batch); sent != 3 {
+		t.Fatalf("sent %d of 3", sent)
+	}
+	// A aplicação lê as mensagens em paralelo: espera as três chegarem.
+	var targets []string
+	var messages [][]string
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		app.mutex.Lock()
+		targets, messages = app.targets, app.messages
+		total := 0
+		for _, m := range messages {
+			total += len(m)
+		}
+		app.mutex.Unlock()
+		if total == 3 {
+			break
+		}
+	}
+	if len(targets) != 2 || targets[0] != "public.example /base/chat%2Fa" {
+		t.Errorf("handshakes %q", targets)
+	}
+	if len(messages) != 2 || strings.Join(messages[0], ",") != "a1,a2" || strings.Join(messages[1], ",") != "b1" {
+		t.Errorf("messages per connection %q", messages)
+	}
+	for _, entry := range config.GetReprocessableRequests() {
+		if entry.RequestNumber > before {
+			if entry.State != config.Processed {
+				t.Errorf("re-recorded entry %d state %d", entry.RequestNumber, entry.State)
+			}
+			config.RemoveRequestFromBuffer(entry.RequestNumber)
+		}
+	}
+
+	// Handshake recusado: nada enviado, o lote volta inteiro pra fila.
+	refusing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusForbidden)
+	}))
+	defer refusing.Close()
+	t.Setenv("APPLICATION_URL", refusing.URL)
+	before = config.GetLatestRequestNumber()
+	if sent := replayWebSocketBatch([]config.RequestData{message("3", "c1")}); sent != 0 {
+		t.Errorf("sent %d through a refused handshake", sent)
+	}
+	if n := config.GetLatestRequestNumber(); n != before {
+		t.Errorf("unsent message re-recorded (%d entries)", n-before)
+	}
+}
+
+func TestWaitWebSocketReplay(t *testing.T) {
+	WebSocketReplayQueueLength.Store(1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		WebSocketReplayQueueLength.Store(0)
+	}()
+	start := time.Now()
+	if !waitWebSocketReplay() || time.Since(start) < 100*time.Millisecond {
+		t.Errorf("upgrade released after %v with replay pending", time.Since(start))
+	}
+}
+
+// Com DURABILITY_HARD_MODE e a durabilidade degradada a mensagem de dados não
+// chega na aplicação nem no buffer; controle continua passando.
+func TestWebSocketRejectsMessagesWhenDurabilityDegraded(t *testing.T) {
+	t.Setenv("DURABILITY_HARD_MODE", "true")
+	crController.DurabilityDegraded.Store(true)
+	defer crController.DurabilityDegraded.Store(false)
+
+	r := httptest.NewRequest(http.MethodGet, "http://app.example/chat", nil)
+	ping := clientFrame(true, 0x9, []byte("ping"))
+	var stream bytes.Buffer
+	stream.Write(ping)
+	stream.Write(clientFrame(true, wsOpText, []byte("hello")))
+
+	before := config.GetLatestRequestNumber()
+	var forwarded bytes.Buffer
+	err := copyWebSocketFrames(&forwarded, bufio.NewReader(&stream), newWebSocketRecorder(r, 1))
+	if err != errWritesRejected {
+		t.Fatalf("copy ended with %v", err)
+	}
+	if !bytes.Equal(forwarded.Bytes(), ping) {
+		t.Errorf("forwarded %q, want only the ping", forwarded.Bytes())
+	}
+	if n := config.GetLatestRequestNumber(); n != before {
+		t.Errorf("rejected message recorded (%d entries)", n-before)
+	}
+}
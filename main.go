@@ -10,7 +10,11 @@ import (
 	"interceptor-grpc/crController"
 	"interceptor-grpc/heartbeat"
 	"interceptor-grpc/interceptor"
+	"interceptor-grpc/metrics"
+	"interceptor-grpc/resp"
 	"interceptor-grpc/snapshotter"
+	"interceptor-grpc/tcpproxy"
+	"interceptor-grpc/tlsutil"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
@@ -24,17 +28,48 @@ func main() {
 	// Register the reprocess callback for recovery mechanism
 	crController.RegisterReprocessCallback(interceptor.AddToQueueForReprocess)
 	crController.RegisterDrainConnectionsCallback(interceptor.DrainConnections)
+	crController.RegisterLongLivedConnectionsCallback(interceptor.CloseLongLivedConnections)
+	if config.GetWebSocketRecordEnabled() {
+		crController.RegisterProtocolReprocessCallback(interceptor.WebSocketProtocol, interceptor.AddWebSocketToReplayQueue)
+	}
+	if config.GetRespEnabled() {
+		crController.RegisterProtocolReprocessCallback(resp.Protocol, resp.AddToReplayQueue)
+	}
+	if config.GetTcpProxyEnabled() {
+		crController.RegisterProtocolReprocessCallback(tcpproxy.Protocol, tcpproxy.AddToReplayQueue)
+	}
 
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go startListener()
+	if config.GetAdminPort() != "" {
+		wg.Add(1)
+		go startAdminListener()
+	}
 	wg.Add(1)
 	go interceptor.ProcessQueue()
+	if config.GetWebSocketRecordEnabled() {
+		wg.Add(1)
+		go interceptor.ProcessWebSocketReplayQueue()
+	}
 	wg.Add(1)
 	go crController.RunGRPCServer()
 	wg.Add(1)
 	go config.ClearRequestsMap()
 
+	if config.GetRespEnabled() {
+		wg.Add(1)
+		go resp.Serve()
+		wg.Add(1)
+		go resp.ProcessReplayQueue()
+	}
+	if config.GetTcpProxyEnabled() {
+		wg.Add(1)
+		go tcpproxy.Serve()
+		wg.Add(1)
+		go tcpproxy.ProcessReplayQueue()
+	}
+
 	if config.GetHeartBeatEnabled() {
 		wg.Add(1)
 		go heartbeat.Monitor()
@@ -49,14 +84,79 @@ func main() {
 }
 
 func startListener() {
-	// Disable SSL validation, because some client may have invalid certificates
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	router := mux.NewRouter()
-	router.PathPrefix("/_internal/pod/restart/start").HandlerFunc(crController.PodBeganRestarting)
-	router.PathPrefix("/_internal/pod/restart/end").HandlerFunc(crController.PodEndedRestarting)
+	if config.GetAdminPort() == "" {
+		registerAdminRoutes(router)
+	}
+	// Nada sob /_internal chega na aplicação, nem rota admin desconhecida
+	// nem as conhecidas quando estão no listener admin (ou desligadas).
+	router.Path("/_internal").HandlerFunc(http.NotFound)
+	router.PathPrefix("/_internal/").HandlerFunc(http.NotFound)
+	router.PathPrefix(config.GetAsyncStatusPrefix()).HandlerFunc(interceptor.AsyncStatusHandler)
 	router.PathPrefix("/").HandlerFunc(interceptor.Handler)
 
-	if err := http.ListenAndServe(config.GetInterceptorPort(), router); err != nil {
-		log.Fatal().Err(err).Msg("Failed to start HTTP server")
+	server := &http.Server{Addr: config.GetInterceptorPort(), Handler: router}
+	if config.GetListenerTLSCertFile() == "" {
+		if err := server.ListenAndServe(); err != nil {
+			log.Fatal().Err(err).Msg("Failed to start HTTP server")
+		}
+		return
+	}
+	server.TLSConfig = listenerTLSConfig()
+	if err := server.ListenAndServeTLS("", ""); err != nil {
+		log.Fatal().Err(err).Msg("Failed to start HTTPS server")
+	}
+}
+
+// listenerTLSConfig monta o TLS do listener dos clientes: certificado
+// recarregado quando o arquivo muda e, com LISTENER_TLS_CLIENT_CA_FILE,
+// verificação de certificado de cliente.
+func listenerTLSConfig() *tls.Config {
+	cert, err := tlsutil.NewCertReloader(config.GetListenerTLSCertFile(), config.GetListenerTLSKeyFile())
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load listener TLS certificate")
+	}
+	var clientCA *tlsutil.CAReloader
+	if caFile := config.GetListenerTLSClientCAFile(); caFile != "" {
+		if clientCA, err = tlsutil.NewCAReloader(caFile); err != nil {
+			log.Fatal().Err(err).Msg("Failed to load listener client CA")
+		}
+	}
+	clientAuth := map[string]tls.ClientAuthType{
+		"request":         tls.RequestClientCert,
+		"verify-if-given": tls.VerifyClientCertIfGiven,
+		"require":         tls.RequireAndVerifyClientCert,
+	}[config.GetListenerTLSClientAuth()]
+	tlsConfig := tlsutil.ServerConfig(cert, clientCA, clientAuth)
+	// Explícito: o Config devolvido por GetConfigForClient não herda o h2 que
+	// o http.Server acrescenta só na cópia dele.
+	tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	log.Info().Bool("client_certs", clientCA != nil).Str("client_auth", config.GetListenerTLSClientAuth()).
+		Msg("Listener TLS enabled")
+	return tlsConfig
+}
+
+// startAdminListener serve os endpoints /_internal/ numa porta própria
+// (ADMIN_PORT), fora do alcance de quem só enxerga a porta da aplicação.
+func startAdminListener() {
+	router := mux.NewRouter()
+	registerAdminRoutes(router)
+	if err := http.ListenAndServe(config.GetAdminPort(), router); err != nil {
+		log.Fatal().Err(err).Msg("Failed to start admin HTTP server")
+	}
+}
+
+// registerAdminRoutes registra os endpoints admin — inclusive a API do
+// dead-letter, que lista e reenvia escritas — só com ADMIN_PORT ou alguma
+// credencial. Fail closed: sem nenhum dos dois eles ficariam abertos a
+// qualquer cliente da aplicação.
+func registerAdminRoutes(router *mux.Router) {
+	if config.GetAdminPort() == "" && !crController.AdminCredentialsConfigured() {
+		log.Error().Msg("/_internal/ endpoints disabled: set ADMIN_PORT, ADMIN_TOKEN, ADMIN_HMAC_SECRET or ADMIN_ALLOWED_CIDRS")
+		return
 	}
+	router.PathPrefix("/_internal/pod/restart/start").HandlerFunc(crController.AdminAuth(crController.PodBeganRestarting))
+	router.PathPrefix("/_internal/pod/restart/end").HandlerFunc(crController.AdminAuth(crController.PodEndedRestarting))
+	router.Path("/_internal/metrics").HandlerFunc(crController.AdminAuth(metrics.Handler))
+	router.PathPrefix("/_internal/dead-letters").HandlerFunc(crController.AdminAuth(interceptor.DeadLetterHandler))
 }
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registro mínimo de métricas no formato texto do Prometheus. Sem dependência
+// externa: o interceptor só precisa de contadores e gauges inteiros, e o
+// endpoint é raspado pelo mesmo scrape do pod.
+
+type Counter struct {
+	v atomic.Int64
+}
+
+func (c *Counter) Inc()         { c.v.Add(1) }
+func (c *Counter) Add(n int64)  { c.v.Add(n) }
+func (c *Counter) Value() int64 { return c.v.Load() }
+
+type Gauge struct {
+	v atomic.Int64
+}
+
+func (g *Gauge) Set(n int64)  { g.v.Store(n) }
+func (g *Gauge) Add(n int64)  { g.v.Add(n) }
+func (g *Gauge) Inc()         { g.v.Add(1) }
+func (g *Gauge) Dec()         { g.v.Add(-1) }
+func (g *Gauge) Value() int64 { return g.v.Load() }
+
+type series struct {
+	labels string
+	value  func() float64
+}
+
+type family struct {
+	help   string
+	kind   string
+	series []series
+}
+
+var registryMutex sync.Mutex
+var registry = map[string]*family{}
+
+// labelString formata pares chave/valor ("class", "replay") como
+// {class="replay"}.
+func labelString(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func register(name, help, kind string, labels []string, value func() float64) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	f, ok := registry[name]
+	if !ok {
+		f = &family{help: help, kind: kind}
+		registry[name] = f
+	}
+	f.series = append(f.series, series{labels: labelString(labels), value: value})
+}
+
+// NewCounter registra um contador. labels são pares chave, valor.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{}
+	register(name, help, "counter", labels, func() float64 { return float64(c.Value()) })
+	return c
+}
+
+// NewGauge registra um gauge. labels são pares chave, valor.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{}
+	register(name, help, "gauge", labels, func() float64 { return float64(g.Value()) })
+	return g
+}
+
+// NewGaugeFunc registra um gauge lido sob demanda (ex.: tamanho de fila que
+// já existe como atomic em outro pacote).
+func NewGaugeFunc(name, help string, fn func() float64, labels ...string) {
+	register(name, help, "gauge", labels, fn)
+}
+
+// Handler expõe o registro no formato texto do Prometheus.
+func Handler(w http.ResponseWriter, _ *http.Request) {
+	registryMutex.Lock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		f := registry[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.kind)
+		for _, s := range f.series {
+			fmt.Fprintf(&b, "%s%s %v\n", name, s.labels, s.value())
+		}
+	}
+	registryMutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write([]byte(b.String()))
+}
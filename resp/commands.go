@@ -0,0 +1,84 @@
+package resp
+
+// writeCommands são os comandos que mutam o dataset e portanto entram no
+// buffer de replay. Leituras (GET, HGETALL, SCAN...) passam direto, como
+// GET/HEAD no HTTP. Scripts e functions entram por precaução: não dá pra
+// saber se o script escreve sem executá-lo.
+var writeCommands = map[string]bool{
+	"APPEND": true, "BITFIELD": true, "BITOP": true, "BLMOVE": true, "BLMPOP": true,
+	"BLPOP": true, "BRPOP": true, "BRPOPLPUSH": true, "BZMPOP": true, "BZPOPMAX": true,
+	"BZPOPMIN": true, "COPY": true, "DECR": true, "DECRBY": true, "DEL": true,
+	"EVAL": true, "EVALSHA": true, "EXPIRE": true, "EXPIREAT": true, "FCALL": true,
+	"FLUSHALL": true, "FLUSHDB": true, "GEOADD": true, "GEORADIUS": true,
+	"GEORADIUSBYMEMBER": true, "GEOSEARCHSTORE": true, "GETDEL": true, "GETEX": true,
+	"GETSET": true, "HDEL": true, "HINCRBY": true, "HINCRBYFLOAT": true, "HMSET": true,
+	"HSET": true, "HSETNX": true, "INCR": true, "INCRBY": true, "INCRBYFLOAT": true,
+	"LINSERT": true, "LMOVE": true, "LMPOP": true, "LPOP": true, "LPUSH": true,
+	"LPUSHX": true, "LREM": true, "LSET": true, "LTRIM": true, "MOVE": true,
+	"MSET": true, "MSETNX": true, "PERSIST": true, "PEXPIRE": true, "PEXPIREAT": true,
+	"PFADD": true, "PFMERGE": true, "PSETEX": true, "RENAME": true, "RENAMENX": true,
+	"RESTORE": true, "RPOP": true, "RPOPLPUSH": true, "RPUSH": true, "RPUSHX": true,
+	"SADD": true, "SDIFFSTORE": true, "SET": true, "SETBIT": true, "SETEX": true,
+	"SETNX": true, "SETRANGE": true, "SINTERSTORE": true, "SMOVE": true, "SORT": true,
+	"SPOP": true, "SREM": true, "SUNIONSTORE": true, "SWAPDB": true, "UNLINK": true,
+	"XACK": true, "XADD": true, "XAUTOCLAIM": true, "XCLAIM": true, "XDEL": true,
+	"XGROUP": true, "XSETID": true, "XTRIM": true, "ZADD": true, "ZDIFFSTORE": true,
+	"ZINCRBY": true, "ZINTERSTORE": true, "ZMPOP": true, "ZPOPMAX": true, "ZPOPMIN": true,
+	"ZRANGESTORE": true, "ZREM": true, "ZREMRANGEBYLEX": true, "ZREMRANGEBYRANK": true,
+	"ZREMRANGEBYSCORE": true, "ZUNIONSTORE": true,
+}
+
+// sessionCommands alteram o estado da CONEXÃO (banco selecionado,
+// credenciais). O replay roda numa conexão nova, então precisa re-executá-los
+// antes das escritas bufferizadas daquela sessão.
+var sessionCommands = map[string]bool{
+	"AUTH":   true,
+	"SELECT": true,
+}
+
+// passthroughCommands tiram a conexão do modelo request/response: depois
+// deles o servidor empurra mensagens sem comando correspondente, então o
+// proxy vira um túnel cru até a conexão fechar.
+var passthroughCommands = map[string]bool{
+	"MONITOR":    true,
+	"PSUBSCRIBE": true,
+	"SSUBSCRIBE": true,
+	"SUBSCRIBE":  true,
+	"SYNC":       true,
+	"PSYNC":      true,
+}
+
+func isWrite(name string) bool {
+	return writeCommands[name]
+}
+
+// blockingCommands podem ficar parados no servidor até o timeout (0 = pra
+// sempre) esperando um elemento. O valor é a posição do argumento de timeout;
+// negativo conta a partir do fim. Dentro de MULTI eles não bloqueiam.
+var blockingCommands = map[string]int{
+	"BLMOVE": -1, "BLPOP": -1, "BRPOP": -1, "BRPOPLPUSH": -1,
+	"BZPOPMAX": -1, "BZPOPMIN": -1, "BLMPOP": 1, "BZMPOP": 1,
+}
+
+func isBlocking(name string) bool {
+	_, ok := blockingCommands[name]
+	return ok
+}
+
+// boundTimeout troca o timeout de um comando bloqueante por timeout. Comandos
+// que não bloqueiam voltam como estão.
+func boundTimeout(cmd Command, timeout string) Command {
+	pos, ok := blockingCommands[cmd.Name()]
+	if !ok {
+		return cmd
+	}
+	if pos < 0 {
+		pos += len(cmd.Args)
+	}
+	if pos <= 0 || pos >= len(cmd.Args) {
+		return cmd
+	}
+	args := append([][]byte(nil), cmd.Args...)
+	args[pos] = []byte(timeout)
+	return Command{Args: args, Raw: encodeCommand(args)}
+}
@@ -0,0 +1,199 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Limites defensivos: um cliente (ou upstream) quebrado não pode fazer o
+// parser alocar gigabytes a partir de um header de tamanho forjado.
+const (
+	maxBulkLength      = 512 * 1024 * 1024
+	maxAggregateLength = 1024 * 1024
+)
+
+var errProtocol = errors.New("resp: protocol error")
+
+// Command é um comando já separado em argumentos, junto com os bytes crus
+// exatamente como serão encaminhados (inline é re-codificado como array).
+type Command struct {
+	Args [][]byte
+	Raw  []byte
+}
+
+// Name retorna o nome do comando em maiúsculas.
+func (c Command) Name() string {
+	if len(c.Args) == 0 {
+		return ""
+	}
+	return strings.ToUpper(string(c.Args[0]))
+}
+
+// readLine lê até \r\n e devolve a linha sem o terminador.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errProtocol
+	}
+	return line[:len(line)-2], nil
+}
+
+func parseLength(b []byte, max int) (int, error) {
+	n, err := strconv.Atoi(string(b))
+	if err != nil || n < -1 || n > max {
+		return 0, errProtocol
+	}
+	return n, nil
+}
+
+// readValue lê um valor RESP2/RESP3 completo e o anexa cru em buf. Serve pra
+// achar a fronteira das respostas do upstream sem interpretá-las.
+func readValue(r *bufio.Reader, buf []byte) ([]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return buf, err
+	}
+	if len(line) == 0 {
+		return buf, errProtocol
+	}
+	buf = append(buf, line...)
+	buf = append(buf, '\r', '\n')
+
+	switch line[0] {
+	case '+', '-', ':', '_', ',', '(', '#':
+		return buf, nil
+	case '$', '!', '=':
+		n, err := parseLength(line[1:], maxBulkLength)
+		if err != nil || n < 0 {
+			return buf, err
+		}
+		start := len(buf)
+		buf = append(buf, make([]byte, n+2)...)
+		if _, err := io.ReadFull(r, buf[start:]); err != nil {
+			return buf, err
+		}
+		return buf, nil
+	case '*', '~', '>', '%', '|':
+		n, err := parseLength(line[1:], maxAggregateLength)
+		if err != nil || n < 0 {
+			return buf, err
+		}
+		if line[0] == '%' || line[0] == '|' {
+			n *= 2
+		}
+		for i := 0; i < n; i++ {
+			if buf, err = readValue(r, buf); err != nil {
+				return buf, err
+			}
+		}
+		if line[0] == '|' {
+			// Atributo RESP3: é metadado do valor que vem logo depois.
+			return readValue(r, buf)
+		}
+		return buf, nil
+	}
+	return buf, errProtocol
+}
+
+// readCommand lê um comando do cliente: array de bulk strings ou comando
+// inline (linha de texto, como o redis-cli via telnet manda).
+func readCommand(r *bufio.Reader) (Command, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return Command{}, err
+	}
+	if first[0] != '*' {
+		line, err := readLine(r)
+		if err != nil {
+			return Command{}, err
+		}
+		fields := bytes.Fields(line)
+		if len(fields) == 0 {
+			return readCommand(r)
+		}
+		return Command{Args: fields, Raw: encodeCommand(fields)}, nil
+	}
+
+	raw, err := readValue(r, nil)
+	if err != nil {
+		return Command{}, err
+	}
+	args, err := parseArgs(raw)
+	if err != nil {
+		return Command{}, err
+	}
+	return Command{Args: args, Raw: raw}, nil
+}
+
+// parseArgs extrai os argumentos de um array de bulk strings já lido por inteiro.
+func parseArgs(raw []byte) ([][]byte, error) {
+	r := bufio.NewReader(bytes.NewReader(raw))
+	header, err := readLine(r)
+	if err != nil || len(header) == 0 || header[0] != '*' {
+		return nil, errProtocol
+	}
+	n, err := parseLength(header[1:], maxAggregateLength)
+	if err != nil || n <= 0 {
+		return nil, errProtocol
+	}
+	args := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil || len(line) == 0 || line[0] != '$' {
+			return nil, errProtocol
+		}
+		size, err := parseLength(line[1:], maxBulkLength)
+		if err != nil || size < 0 {
+			return nil, errProtocol
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+// splitCommands separa uma sequência de comandos codificados (ex.: um bloco
+// MULTI/EXEC bufferizado) nos comandos individuais.
+func splitCommands(raw []byte) ([]Command, error) {
+	r := bufio.NewReader(bytes.NewReader(raw))
+	var cmds []Command
+	for {
+		if _, err := r.Peek(1); err != nil {
+			return cmds, nil
+		}
+		cmd, err := readCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+}
+
+func encodeCommand(args [][]byte) []byte {
+	var b bytes.Buffer
+	b.WriteByte('*')
+	b.WriteString(strconv.Itoa(len(args)))
+	b.WriteString("\r\n")
+	for _, arg := range args {
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(len(arg)))
+		b.WriteString("\r\n")
+		b.Write(arg)
+		b.WriteString("\r\n")
+	}
+	return b.Bytes()
+}
+
+func errorReply(msg string) []byte {
+	return []byte("-" + msg + "\r\n")
+}
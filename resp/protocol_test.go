@@ -0,0 +1,133 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func reader(s string) *bufio.Reader {
+	return bufio.NewReader(strings.NewReader(s))
+}
+
+func TestReadValue(t *testing.T) {
+	cases := []string{
+		"+OK\r\n",
+		"-ERR wrong type\r\n",
+		":42\r\n",
+		"$5\r\nhello\r\n",
+		"$-1\r\n",
+		"*-1\r\n",
+		"*2\r\n$3\r\nfoo\r\n*1\r\n:1\r\n",
+		"%1\r\n+key\r\n:1\r\n",
+		"_\r\n",
+		">2\r\n+message\r\n$2\r\nhi\r\n",
+		// Atributo RESP3: vem colado no valor que descreve.
+		"|1\r\n+ttl\r\n:3600\r\n$1\r\nv\r\n",
+	}
+	for _, raw := range cases {
+		r := reader(raw + "+NEXT\r\n")
+		got, err := readValue(r, nil)
+		if err != nil {
+			t.Fatalf("readValue(%q): %v", raw, err)
+		}
+		if string(got) != raw {
+			t.Errorf("readValue(%q) = %q", raw, got)
+		}
+		// O próximo valor fica intacto no reader.
+		if next, _ := readValue(r, nil); string(next) != "+NEXT\r\n" {
+			t.Errorf("after %q, next value = %q", raw, next)
+		}
+	}
+}
+
+func TestReadValueRejectsMalformed(t *testing.T) {
+	cases := []string{
+		"+OK\n",
+		"\r\n",
+		"?what\r\n",
+		"$abc\r\n",
+		"$536870913\r\n",
+		"*1048577\r\n",
+	}
+	for _, raw := range cases {
+		if _, err := readValue(reader(raw), nil); err != errProtocol {
+			t.Errorf("readValue(%q) error = %v, want errProtocol", raw, err)
+		}
+	}
+}
+
+func TestReadCommand(t *testing.T) {
+	raw := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\nva\r\nl\r\n"
+	cmd, err := readCommand(reader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Name() != "SET" || len(cmd.Args) != 3 || string(cmd.Args[2]) != "va\r\nl" {
+		t.Errorf("args = %q", cmd.Args)
+	}
+	if string(cmd.Raw) != raw {
+		t.Errorf("raw = %q, want the bytes as sent", cmd.Raw)
+	}
+}
+
+func TestReadCommandInline(t *testing.T) {
+	// Linhas vazias são ignoradas; inline vira array no Raw.
+	cmd, err := readCommand(reader("\r\nincr  counter\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Name() != "INCR" {
+		t.Errorf("name = %q", cmd.Name())
+	}
+	if want := "*2\r\n$4\r\nincr\r\n$7\r\ncounter\r\n"; string(cmd.Raw) != want {
+		t.Errorf("raw = %q, want %q", cmd.Raw, want)
+	}
+}
+
+func TestParseArgsRejectsNonBulk(t *testing.T) {
+	for _, raw := range []string{"*0\r\n", "*1\r\n:1\r\n", "+OK\r\n"} {
+		if _, err := parseArgs([]byte(raw)); err == nil {
+			t.Errorf("parseArgs(%q) accepted", raw)
+		}
+	}
+}
+
+func TestSplitCommands(t *testing.T) {
+	var block []byte
+	for _, args := range [][]string{{"MULTI"}, {"SET", "a", "1"}, {"INCR", "a"}, {"EXEC"}} {
+		var encoded [][]byte
+		for _, arg := range args {
+			encoded = append(encoded, []byte(arg))
+		}
+		block = append(block, encodeCommand(encoded)...)
+	}
+	cmds, err := splitCommands(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	var joined []byte
+	for _, cmd := range cmds {
+		names = append(names, cmd.Name())
+		joined = append(joined, cmd.Raw...)
+	}
+	if strings.Join(names, " ") != "MULTI SET INCR EXEC" {
+		t.Errorf("names = %v", names)
+	}
+	if !bytes.Equal(joined, block) {
+		t.Error("raw commands do not add up to the block")
+	}
+}
+
+func TestHasSelect(t *testing.T) {
+	auth := string(encodeCommand([][]byte{[]byte("AUTH"), []byte("secret")}))
+	sel := string(encodeCommand([][]byte{[]byte("select"), []byte("2")}))
+	if hasSelect([]string{auth}) {
+		t.Error("AUTH alone reported as SELECT")
+	}
+	if !hasSelect([]string{auth, sel}) {
+		t.Error("lowercase select not found")
+	}
+}
@@ -0,0 +1,326 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+	"interceptor-grpc/interceptor"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Protocol é o valor de config.RequestData.Protocol das entradas RESP.
+const Protocol = "resp"
+
+// Header das entradas bufferizadas que guarda os comandos de sessão
+// (AUTH/SELECT) crus, na ordem em que devem ser re-executados no replay.
+const preludeHeader = "Resp-Prelude"
+
+// Mesmo teto do spin-gate do Handler HTTP.
+const gateTimeout = 5 * time.Minute
+
+var errTunnelClosed = errors.New("resp: passthrough tunnel closed")
+
+// Resposta às escritas com DURABILITY_HARD_MODE e durabilidade degradada.
+const writesRejectedMessage = "interceptor: writes rejected, snapshot durability degraded"
+
+// session é o estado de UMA conexão de cliente: cada cliente ganha sua
+// própria conexão upstream, então SELECT/AUTH/MULTI continuam valendo do
+// lado do servidor exatamente como o cliente espera.
+type session struct {
+	client    net.Conn
+	upstream  net.Conn
+	clientR   *bufio.Reader
+	clientW   *bufio.Writer
+	upstreamR *bufio.Reader
+
+	auth     []byte
+	selectDB []byte
+
+	inTx     bool
+	tx       []Command
+	txWrites bool
+}
+
+// Serve abre o listener RESP e atende cada conexão numa goroutine. Deve ser
+// chamada numa goroutine, como o listener HTTP.
+func Serve() {
+	lis, err := net.Listen("tcp", config.GetRespPort())
+	if err != nil {
+		log.Fatal().Err(err).Str("port", config.GetRespPort()).Msg("Failed to start RESP listener")
+	}
+	log.Info().Str("port", config.GetRespPort()).Str("upstream", config.GetRespUpstreamAddr()).
+		Msg("RESP listener started")
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			log.Err(err).Msg("Error accepting RESP connection")
+			continue
+		}
+		go handleConn(conn)
+	}
+}
+
+func handleConn(client net.Conn) {
+	defer client.Close()
+
+	// Gate também no estabelecimento: não abre conexão upstream nova com o
+	// backend congelado/restaurando.
+	if !waitGate() {
+		_, _ = client.Write(errorReply("TRYAGAIN interceptor: timed out waiting for container to be available"))
+		return
+	}
+	// A conexão upstream é persistente: fica no registro de longa duração
+	// (LONG_LIVED_CONN_POLICY) pra ser fechada antes do checkpoint. Até o
+	// registro ela conta em InFlightRequests, senão um snapshot que começasse
+	// no meio do dial não a veria.
+	crController.InFlightRequests.Add(1)
+	upstream, err := net.DialTimeout("tcp", config.GetRespUpstreamAddr(), 10*time.Second)
+	if err != nil {
+		crController.InFlightRequests.Done()
+		log.Err(err).Msg("Error connecting to RESP upstream")
+		_, _ = client.Write(errorReply("ERR interceptor: upstream unavailable"))
+		return
+	}
+	defer upstream.Close()
+	untrack := interceptor.TrackLongLived("resp", func() {
+		_ = client.Close()
+		_ = upstream.Close()
+	})
+	defer untrack()
+	closing := crController.IsDoingSnapshot.Load() || crController.IsRestoringSnapshot.Load()
+	crController.InFlightRequests.Done()
+	if closing {
+		_, _ = client.Write(errorReply("TRYAGAIN interceptor: checkpoint in progress"))
+		return
+	}
+
+	s := &session{
+		client:    client,
+		upstream:  upstream,
+		clientR:   bufio.NewReader(client),
+		clientW:   bufio.NewWriter(client),
+		upstreamR: bufio.NewReader(upstream),
+	}
+	s.serve()
+}
+
+func (s *session) serve() {
+	for {
+		cmd, err := readCommand(s.clientR)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Debug().Err(err).Msg("RESP client connection closed")
+			}
+			return
+		}
+
+		if !waitGate() {
+			_, _ = s.clientW.Write(errorReply("TRYAGAIN interceptor: timed out waiting for container to be available"))
+		} else {
+			// Comando bloqueante fica fora do InFlightRequests: pode esperar o
+			// timeout inteiro (0 = pra sempre) e travaria a drenagem do
+			// snapshot. A conexão upstream já está no registro de longa
+			// duração, que a fecha antes do checkpoint.
+			blocking := isBlocking(cmd.Name()) && !s.inTx
+			if !blocking {
+				crController.InFlightRequests.Add(1)
+			}
+			err = s.dispatch(cmd)
+			if !blocking {
+				crController.InFlightRequests.Done()
+			}
+			if err != nil {
+				if !errors.Is(err, errTunnelClosed) {
+					log.Err(err).Msg("Error forwarding RESP command")
+				}
+				return
+			}
+		}
+
+		// Pipelining: só faz flush quando o cliente não tem mais comandos
+		// já enviados esperando no buffer.
+		if s.clientR.Buffered() == 0 {
+			if err := s.clientW.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *session) dispatch(cmd Command) error {
+	name := cmd.Name()
+	switch {
+	case passthroughCommands[name] && !s.inTx:
+		return s.tunnel(cmd)
+	case name == "MULTI":
+		s.inTx, s.tx, s.txWrites = true, nil, false
+		_, err := s.forward(cmd)
+		return err
+	case name == "DISCARD":
+		s.inTx, s.tx, s.txWrites = false, nil, false
+		_, err := s.forward(cmd)
+		return err
+	case name == "EXEC" && s.inTx:
+		// A transação entra no buffer como UMA entrada (MULTI..EXEC): o
+		// replay precisa da mesma atomicidade que o cliente pediu.
+		tx, writes := s.tx, s.txWrites
+		s.inTx, s.tx, s.txWrites = false, nil, false
+		if !writes {
+			_, err := s.forward(cmd)
+			return err
+		}
+		if crController.WritesRejected() {
+			// A transação já está enfileirada no servidor: descarta lá e
+			// recusa o EXEC inteiro.
+			if err := s.discardUpstream(); err != nil {
+				return err
+			}
+			_, err := s.clientW.Write(errorReply("EXECABORT " + writesRejectedMessage))
+			return err
+		}
+		block := encodeCommand([][]byte{[]byte("MULTI")})
+		for _, queued := range tx {
+			block = append(block, queued.Raw...)
+		}
+		block = append(block, cmd.Raw...)
+		return s.forwardBuffered(cmd, "MULTI", block)
+	case s.inTx:
+		// WATCH não vai pro bloco: no replay a transação roda incondicional.
+		if name != "WATCH" {
+			s.tx = append(s.tx, cmd)
+			s.txWrites = s.txWrites || isWrite(name)
+		}
+		_, err := s.forward(cmd)
+		return err
+	case sessionCommands[name]:
+		reply, err := s.forward(cmd)
+		if err == nil && len(reply) > 0 && reply[0] != '-' {
+			if name == "AUTH" {
+				s.auth = cmd.Raw
+			} else {
+				s.selectDB = cmd.Raw
+			}
+		}
+		return err
+	case isWrite(name):
+		if crController.WritesRejected() {
+			_, err := s.clientW.Write(errorReply("TRYAGAIN " + writesRejectedMessage))
+			return err
+		}
+		return s.forwardBuffered(cmd, name, cmd.Raw)
+	default:
+		_, err := s.forward(cmd)
+		return err
+	}
+}
+
+// forwardBuffered registra a escrita no buffer de reprocess, encaminha e
+// marca como processada — mesmo ciclo de vida do forwardBuffered HTTP.
+func (s *session) forwardBuffered(cmd Command, name string, raw []byte) error {
+	requestNumber := config.SaveRequestToBuffer(config.RequestData{
+		Protocol: Protocol,
+		Method:   name,
+		Header:   s.preludeHeader(),
+		Body:     raw,
+	})
+	if _, err := s.upstream.Write(cmd.Raw); err != nil {
+		// Nunca chegou no upstream e o cliente recebe erro: sai do buffer,
+		// senão o replay aplicaria uma escrita fantasma.
+		config.RemoveRequestFromBuffer(requestNumber)
+		return err
+	}
+	_, err := s.relayReply()
+	config.UpdateRequestToProcessed(requestNumber)
+	return err
+}
+
+// discardUpstream manda DISCARD sem repassar a resposta ao cliente.
+func (s *session) discardUpstream() error {
+	if _, err := s.upstream.Write(encodeCommand([][]byte{[]byte("DISCARD")})); err != nil {
+		return err
+	}
+	_, err := readValue(s.upstreamR, nil)
+	return err
+}
+
+func (s *session) preludeHeader() http.Header {
+	header := http.Header{}
+	if s.auth != nil {
+		header.Add(preludeHeader, string(s.auth))
+	}
+	if s.selectDB != nil {
+		header.Add(preludeHeader, string(s.selectDB))
+	}
+	return header
+}
+
+// forward envia o comando ao upstream, lê UMA resposta completa e a repassa
+// ao cliente. Push messages RESP3 ('>') que chegam antes da resposta são
+// repassadas e não contam como resposta.
+func (s *session) forward(cmd Command) ([]byte, error) {
+	if _, err := s.upstream.Write(cmd.Raw); err != nil {
+		return nil, err
+	}
+	return s.relayReply()
+}
+
+func (s *session) relayReply() ([]byte, error) {
+	for {
+		reply, err := readValue(s.upstreamR, nil)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.clientW.Write(reply); err != nil {
+			return nil, err
+		}
+		if reply[0] != '>' {
+			return reply, nil
+		}
+	}
+}
+
+// tunnel entrega a conexão a um túnel cru (pub/sub, MONITOR, replicação):
+// o servidor passa a empurrar mensagens sem comando correspondente. Nada
+// mais dessa conexão entra no buffer.
+func (s *session) tunnel(cmd Command) error {
+	if err := s.clientW.Flush(); err != nil {
+		return err
+	}
+	if _, err := s.upstream.Write(cmd.Raw); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(s.client, s.upstreamR)
+		_ = s.client.Close()
+		close(done)
+	}()
+	_, _ = io.Copy(s.upstream, s.clientR)
+	_ = s.upstream.Close()
+	<-done
+	return errTunnelClosed
+}
+
+// waitGate segura o comando enquanto há snapshot, restore, indisponibilidade
+// ou replay RESP pendente (escritas novas não podem ultrapassar o replay).
+// Retorna false se estourou o teto.
+func waitGate() bool {
+	start := time.Now()
+	for crController.IsDoingSnapshot.Load() ||
+		crController.IsRestoringSnapshot.Load() ||
+		crController.IsContainerUnavailable.Load() ||
+		ReplayQueueLength.Load() > 0 {
+		if time.Since(start) > gateTimeout {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
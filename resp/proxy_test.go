@@ -0,0 +1,370 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+)
+
+// fakeRedis é um servidor RESP mínimo em processo: responde o suficiente pro
+// proxy (+OK, QUEUED, EXEC, GET vazio) e grava os comandos recebidos.
+type fakeRedis struct {
+	lis      net.Listener
+	mutex    sync.Mutex
+	commands []string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{lis: lis}
+	t.Cleanup(func() { _ = lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var queued []string
+	inTx := false
+	for {
+		cmd, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range cmd.Args {
+			args = append(args, string(arg))
+		}
+		f.mutex.Lock()
+		f.commands = append(f.commands, strings.Join(args, " "))
+		f.mutex.Unlock()
+
+		var reply string
+		switch name := cmd.Name(); {
+		case name == "MULTI":
+			inTx, queued, reply = true, nil, "+OK\r\n"
+		case name == "EXEC":
+			reply = "*" + strconv.Itoa(len(queued)) + "\r\n" + strings.Join(queued, "")
+			inTx = false
+		case inTx:
+			queued = append(queued, "+OK\r\n")
+			reply = "+QUEUED\r\n"
+		case name == "GET":
+			reply = "$-1\r\n"
+		case name == "AUTH" && args[1] != "secret":
+			reply = "-WRONGPASS invalid password\r\n"
+		default:
+			reply = "+OK\r\n"
+		}
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) received() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+func command(args ...string) []byte {
+	var encoded [][]byte
+	for _, arg := range args {
+		encoded = append(encoded, []byte(arg))
+	}
+	return encodeCommand(encoded)
+}
+
+// bufferedSince devolve as entradas do buffer de reprocess criadas depois de
+// after (o buffer é global ao processo).
+func bufferedSince(after uint64) []*config.BufferedRequest {
+	var entries []*config.BufferedRequest
+	for _, entry := range config.GetReprocessableRequests() {
+		if entry.RequestNumber > after {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func TestProxyBuffersWritesOnly(t *testing.T) {
+	server := newFakeRedis(t)
+	t.Setenv("RESP_UPSTREAM_ADDR", server.lis.Addr().String())
+	before := config.GetLatestRequestNumber()
+
+	client, proxySide := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		handleConn(proxySide)
+		close(done)
+	}()
+	r := bufio.NewReader(client)
+	roundTrip := func(args ...string) string {
+		t.Helper()
+		if _, err := client.Write(command(args...)); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := readValue(r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(reply)
+	}
+
+	if got := roundTrip("AUTH", "wrong"); !strings.HasPrefix(got, "-WRONGPASS") {
+		t.Errorf("AUTH wrong = %q", got)
+	}
+	roundTrip("AUTH", "secret")
+	roundTrip("SELECT", "3")
+	if got := roundTrip("GET", "k"); got != "$-1\r\n" {
+		t.Errorf("GET = %q", got)
+	}
+	roundTrip("SET", "k", "v")
+	roundTrip("MULTI")
+	roundTrip("WATCH", "k")
+	roundTrip("INCR", "n")
+	roundTrip("SET", "k", "w")
+	if got := roundTrip("EXEC"); got != "*3\r\n+OK\r\n+OK\r\n+OK\r\n" {
+		t.Errorf("EXEC = %q", got)
+	}
+	_ = client.Close()
+	<-done
+
+	entries := bufferedSince(before)
+	if len(entries) != 2 {
+		t.Fatalf("buffered %d entries, want SET and the MULTI block", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Data.Protocol != Protocol || entry.State != config.Processed {
+			t.Errorf("entry %d: protocol %q state %d", entry.RequestNumber, entry.Data.Protocol, entry.State)
+		}
+		// Só o AUTH aceito entra no prelúdio, seguido do SELECT.
+		prelude := entry.Data.Header.Values(preludeHeader)
+		if len(prelude) != 2 || prelude[0] != string(command("AUTH", "secret")) ||
+			prelude[1] != string(command("SELECT", "3")) {
+			t.Errorf("entry %d: prelude %q", entry.RequestNumber, prelude)
+		}
+	}
+	if entries[0].Data.Method != "SET" {
+		t.Errorf("first entry method = %q", entries[0].Data.Method)
+	}
+	block := entries[1]
+	cmds, err := splitCommands(block.Data.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, cmd := range cmds {
+		names = append(names, cmd.Name())
+	}
+	// WATCH não vai pro bloco: no replay a transação é incondicional.
+	if block.Data.Method != "MULTI" || strings.Join(names, " ") != "MULTI INCR SET EXEC" {
+		t.Errorf("block %q = %v", block.Data.Method, names)
+	}
+	for _, entry := range entries {
+		config.RemoveRequestFromBuffer(entry.RequestNumber)
+	}
+}
+
+func TestReplayOneReappliesSession(t *testing.T) {
+	server := newFakeRedis(t)
+	conn, err := net.Dial("tcp", server.lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	withDB := config.RequestData{Protocol: Protocol, Method: "SET", Body: command("SET", "a", "1")}
+	withDB.Header = map[string][]string{preludeHeader: {string(command("SELECT", "5"))}}
+	plain := config.RequestData{Protocol: Protocol, Method: "DEL", Body: command("DEL", "a")}
+
+	before := config.GetLatestRequestNumber()
+	var lastPrelude string
+	for _, data := range []config.RequestData{withDB, withDB, plain} {
+		if sent, err := replayOne(conn, r, data, &lastPrelude); err != nil || !sent {
+			t.Fatalf("sent %v, err %v", sent, err)
+		}
+	}
+
+	// O prelúdio só é reenviado quando muda, e a sessão sem SELECT volta
+	// explicitamente pro banco 0.
+	want := []string{"SELECT 5", "SET a 1", "SET a 1", "SELECT 0", "DEL a"}
+	if got := server.received(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("upstream received %q, want %q", got, want)
+	}
+	entries := bufferedSince(before)
+	if len(entries) != 3 {
+		t.Fatalf("re-buffered %d entries, want 3", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.State != config.Processed {
+			t.Errorf("entry %d state %d, want Processed", entry.RequestNumber, entry.State)
+		}
+		config.RemoveRequestFromBuffer(entry.RequestNumber)
+	}
+}
+
+func TestBoundTimeout(t *testing.T) {
+	cases := map[string][]string{
+		"BLPOP a b 1":             {"BLPOP", "a", "b", "0"},
+		"BLMOVE s d LEFT RIGHT 1": {"BLMOVE", "s", "d", "LEFT", "RIGHT", "0"},
+		"BZMPOP 1 2 a b MIN":      {"BZMPOP", "0", "2", "a", "b", "MIN"},
+		"LPUSH a 0":               {"LPUSH", "a", "0"},
+	}
+	for want, args := range cases {
+		cmd, err := readCommand(bufio.NewReader(bytes.NewReader(command(args...))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bounded := boundTimeout(cmd, "1")
+		if got := string(bytes.Join(bounded.Args, []byte(" "))); got != want {
+			t.Errorf("boundTimeout(%v) = %q", args, got)
+		}
+		if parsed, err := parseArgs(bounded.Raw); err != nil || len(parsed) != len(args) {
+			t.Errorf("boundTimeout(%v) raw not re-encoded: %q", args, bounded.Raw)
+		}
+	}
+}
+
+// Escrita que não chega no upstream não fica no buffer pra virar escrita
+// fantasma no replay.
+func TestForwardBufferedDropsUnsentWrite(t *testing.T) {
+	upstream, other := net.Pipe()
+	_ = other.Close()
+	var out bytes.Buffer
+	s := &session{upstream: upstream, upstreamR: bufio.NewReader(upstream), clientW: bufio.NewWriter(&out)}
+	before := config.GetLatestRequestNumber()
+	cmd := Command{Args: [][]byte{[]byte("INCR"), []byte("n")}, Raw: command("INCR", "n")}
+	if err := s.forwardBuffered(cmd, "INCR", cmd.Raw); err == nil {
+		t.Fatal("write to a closed upstream succeeded")
+	}
+	if entries := bufferedSince(before); len(entries) != 0 {
+		t.Errorf("unsent write kept in the buffer: %+v", entries[0])
+	}
+}
+
+// Um upstream que recebe o comando e não responde: o prazo por entrada
+// destrava a drenagem e o comando não é reenviado.
+func TestReplayNotResentAfterSend(t *testing.T) {
+	t.Setenv("RESP_REPLAY_TIMEOUT", "1")
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	received := make(chan string, 4)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			cmd, err := readCommand(r)
+			if err != nil {
+				return
+			}
+			if cmd.Name() == "SELECT" {
+				_, _ = conn.Write([]byte("+OK\r\n"))
+				continue
+			}
+			received <- string(bytes.Join(cmd.Args, []byte(" ")))
+		}
+	}()
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	before := config.GetLatestRequestNumber()
+	AddToReplayQueue(config.RequestData{Protocol: Protocol, Method: "BLPOP", Body: command("BLPOP", "q", "0")})
+	if replayed := drainReplayQueue(conn); replayed != 0 {
+		t.Errorf("replayed %d without a reply", replayed)
+	}
+	if got := <-received; got != "BLPOP q "+replayBlockTimeout {
+		t.Errorf("upstream received %q, want the bounded timeout", got)
+	}
+	if n := ReplayQueueLength.Load(); n != 0 {
+		t.Errorf("entry sent without reply requeued (%d queued)", n)
+	}
+	entries := bufferedSince(before)
+	if len(entries) != 1 || entries[0].State != config.Processed {
+		t.Fatalf("buffer after unanswered replay: %d entries", len(entries))
+	}
+	config.RemoveRequestFromBuffer(entries[0].RequestNumber)
+}
+
+func TestProxyRejectsWritesWhenDurabilityDegraded(t *testing.T) {
+	server := newFakeRedis(t)
+	t.Setenv("RESP_UPSTREAM_ADDR", server.lis.Addr().String())
+	t.Setenv("DURABILITY_HARD_MODE", "true")
+	crController.DurabilityDegraded.Store(true)
+	defer crController.DurabilityDegraded.Store(false)
+	before := config.GetLatestRequestNumber()
+
+	client, proxySide := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		handleConn(proxySide)
+		close(done)
+	}()
+	r := bufio.NewReader(client)
+	roundTrip := func(args ...string) string {
+		t.Helper()
+		if _, err := client.Write(command(args...)); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := readValue(r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(reply)
+	}
+
+	if got := roundTrip("SET", "k", "v"); !strings.HasPrefix(got, "-TRYAGAIN") {
+		t.Errorf("SET = %q", got)
+	}
+	if got := roundTrip("GET", "k"); got != "$-1\r\n" {
+		t.Errorf("GET = %q", got)
+	}
+	roundTrip("MULTI")
+	roundTrip("INCR", "n")
+	if got := roundTrip("EXEC"); !strings.HasPrefix(got, "-EXECABORT") {
+		t.Errorf("EXEC = %q", got)
+	}
+	_ = client.Close()
+	<-done
+
+	// A transação é descartada no servidor, não executada.
+	want := []string{"GET k", "MULTI", "INCR n", "DISCARD"}
+	if got := server.received(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("upstream received %q, want %q", got, want)
+	}
+	if entries := bufferedSince(before); len(entries) != 0 {
+		t.Errorf("rejected writes buffered: %d entries", len(entries))
+	}
+}
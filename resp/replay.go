@@ -0,0 +1,193 @@
+package resp
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+
+	"github.com/rs/zerolog/log"
+)
+
+var ReplayQueueLength = atomic.Uint32{}
+var replayQueue = make([]config.RequestData, 0)
+var replayQueueMutex sync.Mutex
+
+// AddToReplayQueue enfileira uma escrita RESP bufferizada pra replay após
+// uma regressão detectada. Registrada via
+// crController.RegisterProtocolReprocessCallback.
+func AddToReplayQueue(data config.RequestData) {
+	replayQueueMutex.Lock()
+	defer replayQueueMutex.Unlock()
+
+	replayQueue = append(replayQueue, data)
+	ReplayQueueLength.Add(1)
+}
+
+func pushFront(data config.RequestData) {
+	replayQueueMutex.Lock()
+	defer replayQueueMutex.Unlock()
+
+	replayQueue = append([]config.RequestData{data}, replayQueue...)
+	ReplayQueueLength.Add(1)
+}
+
+func popReplay() (config.RequestData, bool) {
+	replayQueueMutex.Lock()
+	defer replayQueueMutex.Unlock()
+
+	if len(replayQueue) == 0 {
+		return config.RequestData{}, false
+	}
+	data := replayQueue[0]
+	replayQueue = replayQueue[1:]
+	ReplayQueueLength.Store(uint32(len(replayQueue)))
+	return data, true
+}
+
+// ProcessReplayQueue drena a fila de replay RESP. Ao contrário da fila HTTP a
+// drenagem é SEQUENCIAL numa única conexão: comandos Redis sobre a mesma
+// chave não comutam (INCR depois de SET ≠ SET depois de INCR), então a ordem
+// original precisa ser preservada.
+func ProcessReplayQueue() {
+	for {
+		time.Sleep(50 * time.Millisecond)
+
+		if ReplayQueueLength.Load() == 0 {
+			continue
+		}
+		if crController.IsDoingSnapshot.Load() || crController.IsRestoringSnapshot.Load() ||
+			crController.IsContainerUnavailable.Load() {
+			continue
+		}
+
+		conn, err := net.DialTimeout("tcp", config.GetRespUpstreamAddr(), 10*time.Second)
+		if err != nil {
+			log.Err(err).Msg("RESP replay: error connecting to upstream")
+			continue
+		}
+		replayed := drainReplayQueue(conn)
+		_ = conn.Close()
+		if replayed > 0 {
+			log.Info().Int("replayed", replayed).Msg("RESP replay: buffered writes re-applied")
+		}
+	}
+}
+
+// Timeout que substitui o dos comandos bloqueantes no replay: com a lista
+// vazia um BLPOP com timeout 0 travaria a drenagem (e o InFlightRequests)
+// pra sempre.
+const replayBlockTimeout = "1"
+
+func drainReplayQueue(conn net.Conn) int {
+	r := bufio.NewReader(conn)
+	replayed := 0
+	var lastPrelude string
+	for {
+		if crController.IsDoingSnapshot.Load() || crController.IsRestoringSnapshot.Load() ||
+			crController.IsContainerUnavailable.Load() {
+			return replayed
+		}
+		data, ok := popReplay()
+		if !ok {
+			return replayed
+		}
+
+		crController.InFlightRequests.Add(1)
+		_ = conn.SetDeadline(time.Now().Add(time.Duration(config.GetRespReplayTimeout()) * time.Second))
+		sent, err := replayOne(conn, r, data, &lastPrelude)
+		crController.InFlightRequests.Done()
+		if err != nil && sent {
+			// O comando já foi escrito: o upstream pode tê-lo aplicado, e
+			// reenviar dobraria um INCR/LPUSH. Fica como processado e só é
+			// registrado; a conexão, com resposta pendente, é descartada.
+			log.Error().Err(err).Str("command", data.Method).
+				Msg("RESP replay: no reply after sending buffered entry, not re-sent; it may or may not have been applied")
+			return replayed
+		}
+		if err != nil {
+			// Conexão quebrou antes do envio: devolve pra frente da fila e
+			// tenta de novo no próximo tick com conexão nova.
+			log.Err(err).Str("command", data.Method).Msg("RESP replay: upstream error, will retry")
+			pushFront(data)
+			return replayed
+		}
+		replayed++
+	}
+}
+
+// replayOne re-registra a entrada sob número novo (como o replay HTTP via
+// forwardBuffered), reaplica os comandos de sessão se mudaram e envia o
+// comando (ou bloco MULTI/EXEC) lendo todas as respostas. sent diz se o
+// comando chegou a ser escrito na conexão.
+func replayOne(conn net.Conn, r *bufio.Reader, data config.RequestData, lastPrelude *string) (sent bool, err error) {
+	prelude := data.Header.Values(preludeHeader)
+	key := ""
+	for _, p := range prelude {
+		key += p
+	}
+	if key != *lastPrelude {
+		if !hasSelect(prelude) {
+			// A sessão anterior pode ter deixado outro banco selecionado.
+			prelude = append(prelude, string(encodeCommand([][]byte{[]byte("SELECT"), []byte("0")})))
+		}
+		for _, p := range prelude {
+			if _, err := conn.Write([]byte(p)); err != nil {
+				return false, err
+			}
+			reply, err := readValue(r, nil)
+			if err != nil {
+				return false, err
+			}
+			if reply[0] == '-' {
+				log.Warn().Str("reply", string(reply)).Msg("RESP replay: session command rejected")
+			}
+		}
+		*lastPrelude = key
+	}
+
+	cmds, err := splitCommands(data.Body)
+	if err != nil {
+		log.Err(err).Str("command", data.Method).Msg("RESP replay: unparseable buffered entry dropped")
+		return false, nil
+	}
+	body := data.Body
+	if len(cmds) == 1 && isBlocking(cmds[0].Name()) {
+		body = boundTimeout(cmds[0], replayBlockTimeout).Raw
+	}
+
+	requestNumber := config.SaveRequestToBuffer(data)
+	if _, err := conn.Write(body); err != nil {
+		// Um comando RESP incompleto não é executado.
+		config.RemoveRequestFromBuffer(requestNumber)
+		return false, err
+	}
+	// Daqui em diante vale o mesmo que no HTTP: chegou no upstream, fica
+	// como processado mesmo sem resposta.
+	defer config.UpdateRequestToProcessed(requestNumber)
+	for range cmds {
+		reply, err := readValue(r, nil)
+		if err != nil {
+			return true, err
+		}
+		if reply[0] == '-' || reply[0] == '!' {
+			log.Warn().Str("command", data.Method).Str("reply", string(reply)).
+				Msg("RESP replay: command rejected by upstream")
+		}
+	}
+	return true, nil
+}
+
+func hasSelect(prelude []string) bool {
+	for _, p := range prelude {
+		if args, err := parseArgs([]byte(p)); err == nil && len(args) > 0 &&
+			(Command{Args: args}).Name() == "SELECT" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package snapshotter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronMatches(t *testing.T) {
+	// 1º de março de 2026 é domingo.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.March, day, hour, minute, 0, 0, time.UTC)
+	}
+	cases := []struct {
+		expr string
+		at   time.Time
+		want bool
+	}{
+		{"0 2 * * *", at(1, 2, 0), true},
+		{"0 2 * * *", at(1, 2, 1), false},
+		{"*/15 9-17 * * 1-5", at(2, 9, 45), true},
+		{"*/15 9-17 * * 1-5", at(2, 9, 50), false},
+		{"*/15 9-17 * * 1-5", at(2, 18, 0), false},
+		{"*/15 9-17 * * 1-5", at(1, 9, 45), false},
+		{"0 0 1,15 6 *", time.Date(2026, time.June, 15, 0, 0, 0, 0, time.UTC), true},
+		{"0 0 1,15 6 *", at(15, 0, 0), false},
+		// Domingo como 0 ou 7.
+		{"0 0 * * 0", at(1, 0, 0), true},
+		{"0 0 * * 7", at(1, 0, 0), true},
+		// Dia do mês e da semana restritos: basta um casar.
+		{"0 0 1 * 1", at(1, 0, 0), true},
+		{"0 0 1 * 1", at(2, 0, 0), true},
+		{"0 0 1 * 1", at(3, 0, 0), false},
+		// Só um restrito: só ele conta.
+		{"0 0 1 * *", at(2, 0, 0), false},
+		{"0 0 * * 1", at(1, 0, 0), false},
+		// "*/1" e "*/2" começam com "*": não restringem a regra do OU.
+		{"0 0 */1 * 1", at(2, 0, 0), true},
+		{"0 0 */1 * 1", at(3, 0, 0), false},
+		{"0 0 1 * */1", at(2, 0, 0), false},
+		{"0 0 */2 * 1", at(5, 0, 0), false},
+	}
+	for _, c := range cases {
+		schedule, err := parseCron(c.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", c.expr, err)
+			continue
+		}
+		if got := schedule.matches(c.at); got != c.want {
+			t.Errorf("%q at %s: %v, want %v", c.expr, c.at.Format("Mon Jan 2 15:04"), got, c.want)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-x * * * *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) accepted", expr)
+		}
+	}
+}
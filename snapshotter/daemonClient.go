@@ -0,0 +1,205 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/metrics"
+	"interceptor-grpc/protos"
+	"interceptor-grpc/tlsutil"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
+)
+
+// Uma conexão só com o daemon, aberta no primeiro snapshot e reaproveitada
+// pelo resto do processo: antes cada ciclo discava e fechava, pagando
+// handshake justamente com o tráfego bloqueado.
+var daemonConnMutex sync.Mutex
+var daemonConn *grpc.ClientConn
+
+var daemonConnState = metrics.NewGauge("interceptor_daemon_connection_state",
+	"Daemon gRPC connection state (0 idle, 1 connecting, 2 ready, 3 transient failure, 4 shutdown)")
+
+var daemonRpcMetricsMutex sync.Mutex
+var daemonRpcCounters = map[string]*metrics.Counter{}
+var daemonRpcDurations = map[string]*metrics.Counter{}
+
+// daemonClient devolve o client do serviço de snapshot sobre a conexão
+// compartilhada, criando-a se preciso.
+func daemonClient() (protos.SnapshotRPCServiceClient, error) {
+	daemonConnMutex.Lock()
+	defer daemonConnMutex.Unlock()
+	if daemonConn != nil {
+		return protos.NewSnapshotRPCServiceClient(daemonConn), nil
+	}
+
+	endpoints := config.GetDaemonGrpcEndpoints()
+	if len(endpoints) == 0 {
+		return nil, errors.New("DAEMON_GRPC_URL is empty")
+	}
+	target := endpoints[0]
+	transportCredentials, err := daemonTransportCredentials()
+	if err != nil {
+		return nil, err
+	}
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(transportCredentials),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                time.Duration(config.GetDaemonKeepaliveTime()) * time.Second,
+			Timeout:             time.Duration(config.GetDaemonKeepaliveTimeout()) * time.Second,
+			PermitWithoutStream: true,
+		}),
+		grpc.WithChainUnaryInterceptor(daemonDeadlineInterceptor, daemonLoggingInterceptor),
+	}
+	if token := config.GetDaemonAuthToken(); token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerToken{
+			token:         token,
+			requireSecure: config.GetDaemonTLSEnabled(),
+		}))
+	}
+	if len(endpoints) > 1 {
+		// pick_first (default) percorre os endereços na ordem: conecta no
+		// primeiro que responder e, se ele cair, volta a tentar a lista.
+		r := manual.NewBuilderWithScheme("daemon")
+		addresses := make([]resolver.Address, 0, len(endpoints))
+		for _, endpoint := range endpoints {
+			// ServerName por endereço: sem ele o TLS verificaria contra a
+			// authority sintética do resolver ("a:1,b:2").
+			host, _, err := net.SplitHostPort(endpoint)
+			if err != nil {
+				host = endpoint
+			}
+			addresses = append(addresses, resolver.Address{Addr: endpoint, ServerName: host})
+		}
+		r.InitialState(resolver.State{Addresses: addresses})
+		target = r.Scheme() + ":///" + strings.Join(endpoints, ",")
+		opts = append(opts, grpc.WithResolvers(r))
+	}
+
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	conn.Connect()
+	daemonConn = conn
+	go monitorDaemonConnection(conn, endpoints)
+	return protos.NewSnapshotRPCServiceClient(conn), nil
+}
+
+func daemonTransportCredentials() (credentials.TransportCredentials, error) {
+	if !config.GetDaemonTLSEnabled() {
+		return insecure.NewCredentials(), nil
+	}
+	var cert *tlsutil.CertReloader
+	var ca *tlsutil.CAReloader
+	var err error
+	if certFile := config.GetDaemonTLSCertFile(); certFile != "" {
+		if cert, err = tlsutil.NewCertReloader(certFile, config.GetDaemonTLSKeyFile()); err != nil {
+			return nil, err
+		}
+	}
+	if caFile := config.GetDaemonTLSCAFile(); caFile != "" {
+		if ca, err = tlsutil.NewCAReloader(caFile); err != nil {
+			return nil, err
+		}
+	}
+	return credentials.NewTLS(tlsutil.ClientConfig(cert, ca, config.GetDaemonTLSServerName())), nil
+}
+
+// bearerToken envia "authorization: Bearer <token>" em cada RPC ao daemon.
+type bearerToken struct {
+	token         string
+	requireSecure bool
+}
+
+func (b bearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + b.token}, nil
+}
+
+func (b bearerToken) RequireTransportSecurity() bool {
+	return b.requireSecure
+}
+
+// monitorDaemonConnection loga as transições de estado e mantém o gauge. Sai
+// quando a conexão é fechada.
+func monitorDaemonConnection(conn *grpc.ClientConn, endpoints []string) {
+	state := conn.GetState()
+	for {
+		daemonConnState.Set(int64(state))
+		if state == connectivity.Shutdown {
+			return
+		}
+		if !conn.WaitForStateChange(context.Background(), state) {
+			return
+		}
+		previous := state
+		state = conn.GetState()
+		event := log.Info()
+		if state == connectivity.TransientFailure {
+			event = log.Warn()
+		}
+		event.Str("from", previous.String()).Str("to", state.String()).Strs("endpoints", endpoints).
+			Msg("Daemon gRPC connection state changed")
+	}
+}
+
+// daemonDeadlineInterceptor aplica DAEMON_RPC_TIMEOUT a chamadas sem deadline.
+func daemonDeadlineInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(config.GetDaemonRpcTimeout())*time.Second)
+		defer cancel()
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// daemonLoggingInterceptor loga e conta cada RPC por método e código.
+func daemonLoggingInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	elapsed := time.Since(start)
+	code := status.Code(err)
+	countDaemonRpc(method, code.String(), elapsed)
+	if err != nil {
+		log.Warn().Err(err).Str("method", method).Str("code", code.String()).Dur("elapsed", elapsed).
+			Msg("Daemon RPC failed")
+	} else {
+		log.Debug().Str("method", method).Dur("elapsed", elapsed).Msg("Daemon RPC completed")
+	}
+	return err
+}
+
+func countDaemonRpc(method, code string, elapsed time.Duration) {
+	daemonRpcMetricsMutex.Lock()
+	defer daemonRpcMetricsMutex.Unlock()
+	key := method + " " + code
+	counter, ok := daemonRpcCounters[key]
+	if !ok {
+		counter = metrics.NewCounter("interceptor_daemon_rpcs_total",
+			"RPCs sent to the snapshot daemon", "method", method, "code", code)
+		daemonRpcCounters[key] = counter
+	}
+	counter.Inc()
+	duration, ok := daemonRpcDurations[method]
+	if !ok {
+		duration = metrics.NewCounter("interceptor_daemon_rpc_duration_milliseconds_total",
+			"Total time spent in RPCs to the snapshot daemon", "method", method)
+		daemonRpcDurations[method] = duration
+	}
+	duration.Add(elapsed.Milliseconds())
+}
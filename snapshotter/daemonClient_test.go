@@ -0,0 +1,155 @@
+package snapshotter
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"interceptor-grpc/protos"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeDaemon grava o que chega em cada RPC; Reply falha com Unavailable.
+type fakeDaemon struct {
+	protos.UnimplementedSnapshotRPCServiceServer
+	mutex         sync.Mutex
+	authorization []string
+	deadlines     []time.Duration
+}
+
+func (d *fakeDaemon) Create(ctx context.Context, _ *protos.CreateSnapshotRequest) (*protos.AckResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	deadline, _ := ctx.Deadline()
+	d.mutex.Lock()
+	d.authorization = append(d.authorization, md.Get("authorization")...)
+	d.deadlines = append(d.deadlines, time.Until(deadline))
+	d.mutex.Unlock()
+	return &protos.AckResponse{Response: true}, nil
+}
+
+func (d *fakeDaemon) Reply(context.Context, *protos.ReplySnapshotRequest) (*protos.AckResponse, error) {
+	return nil, status.Error(codes.Unavailable, "daemon busy")
+}
+
+func startFakeDaemon(t *testing.T) (*fakeDaemon, string) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon := &fakeDaemon{}
+	server := grpc.NewServer()
+	protos.RegisterSnapshotRPCServiceServer(server, daemon)
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+	return daemon, lis.Addr().String()
+}
+
+// closedAddr é um endereço sem ninguém escutando.
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	return addr
+}
+
+// resetDaemonConn descarta a conexão compartilhada no fim do teste.
+func resetDaemonConn(t *testing.T) {
+	t.Cleanup(func() {
+		daemonConnMutex.Lock()
+		defer daemonConnMutex.Unlock()
+		if daemonConn != nil {
+			_ = daemonConn.Close()
+			daemonConn = nil
+		}
+	})
+}
+
+func daemonRpcCount(method, code string) int64 {
+	daemonRpcMetricsMutex.Lock()
+	defer daemonRpcMetricsMutex.Unlock()
+	if counter := daemonRpcCounters[method+" "+code]; counter != nil {
+		return counter.Value()
+	}
+	return 0
+}
+
+func TestDaemonClientFailoverTokenAndDeadline(t *testing.T) {
+	daemon, addr := startFakeDaemon(t)
+	// O primeiro endereço não responde: pick_first segue pro segundo.
+	t.Setenv("DAEMON_GRPC_URL", closedAddr(t)+", "+addr)
+	t.Setenv("DAEMON_AUTH_TOKEN", "t0k")
+	t.Setenv("DAEMON_RPC_TIMEOUT", "7")
+	resetDaemonConn(t)
+
+	client, err := daemonClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := daemonRpcCount(protos.SnapshotRPCService_Create_FullMethodName, "OK")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Create(ctx, &protos.CreateSnapshotRequest{}, grpc.WaitForReady(true)); err != nil {
+		t.Fatal(err)
+	}
+	// Sem deadline do chamador vale DAEMON_RPC_TIMEOUT.
+	if _, err := client.Create(context.Background(), &protos.CreateSnapshotRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	daemon.mutex.Lock()
+	authorization, deadlines := daemon.authorization, daemon.deadlines
+	daemon.mutex.Unlock()
+	if len(authorization) != 2 || authorization[0] != "Bearer t0k" || authorization[1] != "Bearer t0k" {
+		t.Errorf("authorization %q", authorization)
+	}
+	if len(deadlines) != 2 || deadlines[0] > 5*time.Second || deadlines[1] <= 5*time.Second || deadlines[1] > 7*time.Second {
+		t.Errorf("deadlines %v", deadlines)
+	}
+	if got := daemonRpcCount(protos.SnapshotRPCService_Create_FullMethodName, "OK") - created; got != 2 {
+		t.Errorf("Create OK counted %d times", got)
+	}
+
+	// Falha contada pelo código.
+	failed := daemonRpcCount(protos.SnapshotRPCService_Reply_FullMethodName, "Unavailable")
+	if _, err := client.Reply(context.Background(), &protos.ReplySnapshotRequest{}); status.Code(err) != codes.Unavailable {
+		t.Errorf("Reply error %v", err)
+	}
+	if got := daemonRpcCount(protos.SnapshotRPCService_Reply_FullMethodName, "Unavailable") - failed; got != 1 {
+		t.Errorf("Reply Unavailable counted %d times", got)
+	}
+
+	// A conexão é compartilhada entre chamadas.
+	conn := daemonConn
+	if _, err := daemonClient(); err != nil || daemonConn != conn {
+		t.Error("second daemonClient opened a new connection")
+	}
+}
+
+func TestDaemonClientRequiresEndpoint(t *testing.T) {
+	t.Setenv("DAEMON_GRPC_URL", " , ")
+	resetDaemonConn(t)
+	if _, err := daemonClient(); err == nil {
+		t.Error("empty DAEMON_GRPC_URL accepted")
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	md, err := bearerToken{token: "t0k"}.GetRequestMetadata(context.Background())
+	if err != nil || md["authorization"] != "Bearer t0k" {
+		t.Errorf("metadata %v, %v", md, err)
+	}
+	if (bearerToken{}).RequireTransportSecurity() || !(bearerToken{requireSecure: true}).RequireTransportSecurity() {
+		t.Error("RequireTransportSecurity does not follow DAEMON_TLS_ENABLED")
+	}
+}
@@ -0,0 +1,165 @@
+package snapshotter
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+	"interceptor-grpc/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+// nextRetryAt (unix nano) é quando a próxima tentativa após falha pode
+// começar; só vale enquanto ConsecutiveSnapshotFailures > 0.
+var nextRetryAt atomic.Int64
+
+var snapshotFailuresTotal = metrics.NewCounter("interceptor_snapshot_failures_total",
+	"Snapshot attempts that failed (connection, rejected Create or missing Reply)")
+var durabilityAlertsTotal = metrics.NewCounter("interceptor_durability_alerts_total",
+	"Durability alerts emitted because buffered writes exceeded MAX_UNSNAPSHOTTED_AGE")
+
+var webhookClient = &http.Client{Timeout: 5 * time.Second}
+
+// retryBackoff: SNAPSHOT_RETRY_BASE dobrando a cada falha seguida, até
+// SNAPSHOT_RETRY_MAX.
+func retryBackoff(failures int64) time.Duration {
+	base := time.Duration(config.GetSnapshotRetryBase()) * time.Second
+	ceiling := time.Duration(config.GetSnapshotRetryMax()) * time.Second
+	backoff := base
+	for i := int64(1); i < failures && backoff < ceiling; i++ {
+		backoff *= 2
+	}
+	if backoff > ceiling {
+		backoff = ceiling
+	}
+	return backoff
+}
+
+// recordSnapshotFailure libera os locks e agenda o retry. Sem isso a próxima
+// tentativa esperava o intervalo cheio e o buffer crescia sem sinal.
+func recordSnapshotFailure(cause string) {
+	releaseSnapshotLocks()
+	failures := crController.ConsecutiveSnapshotFailures.Add(1)
+	snapshotFailuresTotal.Inc()
+	backoff := retryBackoff(failures)
+	nextRetryAt.Store(time.Now().Add(backoff).UnixNano())
+	log.Error().Str("cause", cause).Int64("consecutive_failures", failures).Dur("retry_in", backoff).
+		Msg("Snapshot failed, retrying with backoff")
+}
+
+// retryDue diz se há falha pendente e, nesse caso, se o backoff já passou.
+// Enquanto há falha pendente, a política normal não é consultada.
+func retryDue(now time.Time) (pending, due bool) {
+	if crController.ConsecutiveSnapshotFailures.Load() == 0 {
+		return false, false
+	}
+	return true, now.UnixNano() >= nextRetryAt.Load()
+}
+
+type durabilityAlert struct {
+	Alert               string  `json:"alert"`
+	Status              string  `json:"status"`
+	Service             string  `json:"service"`
+	Namespace           string  `json:"namespace"`
+	AgeSeconds          float64 `json:"age_seconds"`
+	MaxAgeSeconds       int     `json:"max_age_seconds"`
+	ConsecutiveFailures int64   `json:"consecutive_failures"`
+	LastSnapshotSuccess string  `json:"last_snapshot_success,omitempty"`
+	HardMode            bool    `json:"hard_mode"`
+}
+
+// durabilityMonitor observa a idade da escrita mais antiga fora de snapshot e
+// alerta (log, métrica, webhook) quando passa de MAX_UNSNAPSHOTTED_AGE,
+// reemitindo a cada ALERT_REPEAT_INTERVAL e avisando quando normaliza.
+type durabilityMonitor struct {
+	lastAlert time.Time
+}
+
+func (m *durabilityMonitor) check(now time.Time) {
+	maxAge := config.GetMaxUnsnapshottedAge()
+	if maxAge == 0 {
+		return
+	}
+	age := config.GetUnsnapshottedAge()
+	degraded := crController.DurabilityDegraded.Load()
+
+	if age < time.Duration(maxAge)*time.Second {
+		if degraded {
+			crController.DurabilityDegraded.Store(false)
+			log.Info().Dur("age", age).Msg("Durability restored: unsnapshotted writes back under the limit")
+			m.emit("resolved", age, maxAge)
+		}
+		return
+	}
+
+	repeat := time.Duration(config.GetAlertRepeatInterval()) * time.Second
+	if degraded && now.Sub(m.lastAlert) < repeat {
+		return
+	}
+	crController.DurabilityDegraded.Store(true)
+	m.lastAlert = now
+	durabilityAlertsTotal.Inc()
+	log.Error().Dur("age", age).Int("max_age_seconds", maxAge).
+		Int64("consecutive_failures", crController.ConsecutiveSnapshotFailures.Load()).
+		Bool("hard_mode", config.GetDurabilityHardMode()).
+		Msg("Durability degraded: buffered writes not covered by a snapshot for too long")
+	m.emit("firing", age, maxAge)
+}
+
+func (m *durabilityMonitor) emit(status string, age time.Duration, maxAge int) {
+	url := config.GetAlertWebhookURL()
+	if url == "" {
+		return
+	}
+	alert := durabilityAlert{
+		Alert:               "unsnapshotted_age",
+		Status:              status,
+		Service:             config.GetServiceName(),
+		Namespace:           config.GetNamespace(),
+		AgeSeconds:          age.Seconds(),
+		MaxAgeSeconds:       maxAge,
+		ConsecutiveFailures: crController.ConsecutiveSnapshotFailures.Load(),
+		HardMode:            config.GetDurabilityHardMode(),
+	}
+	if last := crController.LastSnapshotSuccess.Load(); last != 0 {
+		alert.LastSnapshotSuccess = time.Unix(0, last).UTC().Format(time.RFC3339)
+	}
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return
+	}
+	// Fora do loop do snapshotter: um webhook lento não atrasa a agenda.
+	go func() {
+		resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Err(err).Str("url", url).Msg("Error sending durability alert webhook")
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Warn().Int("status", resp.StatusCode).Str("url", url).Msg("Durability alert webhook rejected")
+		}
+	}()
+}
+
+func init() {
+	metrics.NewGaugeFunc("interceptor_snapshot_consecutive_failures",
+		"Snapshot attempts failed in a row since the last successful Reply",
+		func() float64 { return float64(crController.ConsecutiveSnapshotFailures.Load()) })
+	metrics.NewGaugeFunc("interceptor_unsnapshotted_age_seconds",
+		"Age of the oldest buffered write not yet covered by a snapshot",
+		func() float64 { return config.GetUnsnapshottedAge().Seconds() })
+	metrics.NewGaugeFunc("interceptor_durability_degraded",
+		"1 while buffered writes exceed MAX_UNSNAPSHOTTED_AGE",
+		func() float64 {
+			if crController.DurabilityDegraded.Load() {
+				return 1
+			}
+			return 0
+		})
+}
@@ -0,0 +1,68 @@
+package snapshotter
+
+import (
+	"testing"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	t.Setenv("SNAPSHOT_RETRY_BASE", "5")
+	t.Setenv("SNAPSHOT_RETRY_MAX", "60")
+	want := map[int64]time.Duration{
+		1:  5 * time.Second,
+		2:  10 * time.Second,
+		3:  20 * time.Second,
+		4:  40 * time.Second,
+		5:  60 * time.Second,
+		50: 60 * time.Second,
+	}
+	for failures, backoff := range want {
+		if got := retryBackoff(failures); got != backoff {
+			t.Errorf("retryBackoff(%d) = %v, want %v", failures, got, backoff)
+		}
+	}
+}
+
+func TestRecordSnapshotFailureSchedulesRetry(t *testing.T) {
+	t.Setenv("SNAPSHOT_RETRY_BASE", "5")
+	crController.ConsecutiveSnapshotFailures.Store(0)
+	defer crController.ConsecutiveSnapshotFailures.Store(0)
+
+	now := time.Now()
+	if pending, _ := retryDue(now); pending {
+		t.Fatal("retry pending without a failure")
+	}
+
+	crController.IsDoingSnapshot.Store(true)
+	config.SnapshotLock.Lock()
+	config.IsSnapshotBeingTaken = true
+	config.SnapshotLock.Unlock()
+	before := snapshotFailuresTotal.Value()
+	recordSnapshotFailure("create")
+
+	// Os locks saem: o tráfego não fica preso até o próximo intervalo.
+	config.SnapshotLock.Lock()
+	taken := config.IsSnapshotBeingTaken
+	config.SnapshotLock.Unlock()
+	if crController.IsDoingSnapshot.Load() || taken {
+		t.Error("snapshot locks kept after a failure")
+	}
+	if crController.ConsecutiveSnapshotFailures.Load() != 1 || snapshotFailuresTotal.Value()-before != 1 {
+		t.Errorf("failures %d", crController.ConsecutiveSnapshotFailures.Load())
+	}
+	if pending, due := retryDue(time.Now()); !pending || due {
+		t.Errorf("right after the failure: pending %v due %v, want pending and not due", pending, due)
+	}
+	if pending, due := retryDue(time.Now().Add(6 * time.Second)); !pending || !due {
+		t.Errorf("after the backoff: pending %v due %v", pending, due)
+	}
+
+	// A segunda falha seguida dobra o backoff.
+	recordSnapshotFailure("reply")
+	if due := time.Unix(0, nextRetryAt.Load()); due.Before(time.Now().Add(9 * time.Second)) {
+		t.Errorf("second retry at %v, want about 10s out", time.Until(due))
+	}
+}
@@ -0,0 +1,245 @@
+package snapshotter
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"interceptor-grpc/config"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ScheduleState é o que as políticas de agendamento enxergam a cada
+// avaliação (1s).
+type ScheduleState struct {
+	Now time.Time
+	// LastSnapshot é o início do último snapshot (ou o boot do snapshotter).
+	LastSnapshot time.Time
+	// RequestsSinceSnapshot: números de request atribuídos desde então.
+	RequestsSinceSnapshot uint64
+	// Entradas/bytes do buffer ainda não cobertos por snapshot.
+	UnsnapshottedRequests int
+	UnsnapshottedBytes    int64
+	// WriteRate: requests bufferizados por segundo na janela configurada.
+	WriteRate float64
+	// EarlyRequest é o motivo de um pedido explícito (config.TriggerEarlySnapshot).
+	EarlyRequest string
+}
+
+// Policy decide se um snapshot começa agora. Retorna o motivo quando sim.
+type Policy interface {
+	ShouldSnapshot(s ScheduleState) (bool, string)
+}
+
+var schedulePolicyMutex sync.Mutex
+var schedulePolicy Policy
+
+// SetSchedulePolicy substitui a política montada a partir do ambiente. Deve
+// ser chamada antes de GenerateSnapshots.
+func SetSchedulePolicy(p Policy) {
+	schedulePolicyMutex.Lock()
+	defer schedulePolicyMutex.Unlock()
+	schedulePolicy = p
+}
+
+func currentSchedulePolicy() Policy {
+	schedulePolicyMutex.Lock()
+	defer schedulePolicyMutex.Unlock()
+	if schedulePolicy == nil {
+		schedulePolicy = NewSchedulePolicyFromEnv()
+	}
+	return schedulePolicy
+}
+
+// Trigger dispara um snapshot; Guard veta. Forced triggers (intervalo
+// máximo, pedido antecipado) passam por cima dos guards — exceto
+// MinInterval e SkipIdle, que são propriedades do snapshot em si.
+type Trigger struct {
+	Name string
+	Fire func(s ScheduleState) bool
+}
+
+type Guard struct {
+	Name  string
+	Block func(s ScheduleState) bool
+}
+
+// CompositePolicy combina gatilhos e vetos: snapshot começa se algum forced
+// disparou, ou se algum trigger disparou e nenhum guard vetou. HardGuards
+// vetam sempre.
+type CompositePolicy struct {
+	Triggers   []Trigger
+	Forced     []Trigger
+	Guards     []Guard
+	HardGuards []Guard
+}
+
+func (p *CompositePolicy) ShouldSnapshot(s ScheduleState) (bool, string) {
+	for _, g := range p.HardGuards {
+		if g.Block(s) {
+			return false, ""
+		}
+	}
+	for _, t := range p.Forced {
+		if t.Fire(s) {
+			return true, t.Name
+		}
+	}
+	for _, t := range p.Triggers {
+		if !t.Fire(s) {
+			continue
+		}
+		for _, g := range p.Guards {
+			if g.Block(s) {
+				return false, ""
+			}
+		}
+		return true, t.Name
+	}
+	return false, ""
+}
+
+// NewSchedulePolicyFromEnv monta a política a partir de CHECKPOINT_INTERVAL e
+// das variáveis SNAPSHOT_*. Sem nenhuma SNAPSHOT_* definida, o comportamento é
+// o intervalo fixo de sempre. O gatilho do intervalo existe sempre, inclusive
+// com SNAPSHOT_CRON.
+func NewSchedulePolicyFromEnv() Policy {
+	p := &CompositePolicy{}
+	interval := time.Duration(config.GetCheckpointInterval()) * time.Second
+
+	if minInterval := time.Duration(config.GetSnapshotMinInterval()) * time.Second; minInterval > 0 {
+		p.HardGuards = append(p.HardGuards, Guard{Name: "min-interval", Block: func(s ScheduleState) bool {
+			return s.Now.Sub(s.LastSnapshot) < minInterval
+		}})
+	}
+	if config.GetSnapshotSkipIdle() {
+		p.HardGuards = append(p.HardGuards, Guard{Name: "idle", Block: func(s ScheduleState) bool {
+			return s.RequestsSinceSnapshot == 0
+		}})
+	}
+
+	p.Forced = append(p.Forced, Trigger{Name: "early-request", Fire: func(s ScheduleState) bool {
+		return s.EarlyRequest != ""
+	}})
+	if maxInterval := time.Duration(config.GetSnapshotMaxInterval()) * time.Second; maxInterval > 0 {
+		p.Forced = append(p.Forced, Trigger{Name: "max-interval", Fire: func(s ScheduleState) bool {
+			return s.Now.Sub(s.LastSnapshot) >= maxInterval && s.RequestsSinceSnapshot > 0
+		}})
+	}
+
+	p.Triggers = append(p.Triggers, Trigger{Name: "interval", Fire: func(s ScheduleState) bool {
+		return s.Now.Sub(s.LastSnapshot) >= interval
+	}})
+	if n := config.GetSnapshotMaxBufferedRequests(); n > 0 {
+		p.Triggers = append(p.Triggers, Trigger{Name: "buffered-requests", Fire: func(s ScheduleState) bool {
+			return s.UnsnapshottedRequests >= n
+		}})
+	}
+	if maxBytes := config.GetBufferMaxBytes(); maxBytes > 0 {
+		p.Triggers = append(p.Triggers, Trigger{Name: "buffered-bytes", Fire: func(s ScheduleState) bool {
+			return s.UnsnapshottedBytes >= maxBytes
+		}})
+	}
+	if rate := config.GetSnapshotWriteRateThreshold(); rate > 0 {
+		p.Triggers = append(p.Triggers, Trigger{Name: "write-rate", Fire: func(s ScheduleState) bool {
+			return s.WriteRate >= rate
+		}})
+	}
+	if expr := config.GetSnapshotCron(); expr != "" {
+		schedule, err := parseCron(expr)
+		if err != nil {
+			log.Fatal().Err(err).Str("cron", expr).Msg("Invalid SNAPSHOT_CRON")
+		}
+		var lastFired time.Time
+		p.Triggers = append(p.Triggers, Trigger{Name: "cron", Fire: func(s ScheduleState) bool {
+			minute := s.Now.Truncate(time.Minute)
+			if minute.Equal(lastFired) || !schedule.matches(s.Now) {
+				return false
+			}
+			// Um disparo por minuto casado, mesmo que vetado: o próximo
+			// casamento do cron é que tenta de novo.
+			lastFired = minute
+			return true
+		}})
+	}
+	if spec := config.GetSnapshotQuietHours(); spec != "" {
+		windows, err := parseQuietHours(spec)
+		if err != nil {
+			log.Fatal().Err(err).Str("quiet_hours", spec).Msg("Invalid SNAPSHOT_QUIET_HOURS")
+		}
+		p.Guards = append(p.Guards, Guard{Name: "quiet-hours", Block: func(s ScheduleState) bool {
+			minuteOfDay := s.Now.Hour()*60 + s.Now.Minute()
+			for _, w := range windows {
+				if w.contains(minuteOfDay) {
+					return true
+				}
+			}
+			return false
+		}})
+	}
+	return p
+}
+
+type quietWindow struct {
+	start, end int // minutos desde 00:00
+}
+
+// contains trata janelas que cruzam a meia-noite (22:00-06:00).
+func (w quietWindow) contains(minute int) bool {
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+func parseQuietHours(spec string) ([]quietWindow, error) {
+	var windows []quietWindow
+	for _, part := range strings.Split(spec, ",") {
+		startPart, endPart, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return nil, fmt.Errorf("quiet hours %q: expected HH:MM-HH:MM", part)
+		}
+		start, err := time.Parse("15:04", startPart)
+		if err != nil {
+			return nil, err
+		}
+		end, err := time.Parse("15:04", endPart)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, quietWindow{
+			start: start.Hour()*60 + start.Minute(),
+			end:   end.Hour()*60 + end.Minute(),
+		})
+	}
+	return windows, nil
+}
+
+// writeRateSampler mantém amostras (instante, último número de request) pra
+// calcular a taxa de escrita na janela.
+type writeRateSampler struct {
+	window  time.Duration
+	samples []rateSample
+}
+
+type rateSample struct {
+	at     time.Time
+	latest uint64
+}
+
+func (w *writeRateSampler) observe(now time.Time, latest uint64) float64 {
+	w.samples = append(w.samples, rateSample{at: now, latest: latest})
+	cut := 0
+	for cut < len(w.samples)-1 && now.Sub(w.samples[cut].at) > w.window {
+		cut++
+	}
+	w.samples = w.samples[cut:]
+	oldest := w.samples[0]
+	elapsed := now.Sub(oldest.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(latest-oldest.latest) / elapsed
+}
@@ -0,0 +1,120 @@
+package snapshotter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	windows, err := parseQuietHours("22:00-06:00, 12:00-13:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quiet := func(hour, minute int) bool {
+		for _, w := range windows {
+			if w.contains(hour*60 + minute) {
+				return true
+			}
+		}
+		return false
+	}
+	cases := map[[2]int]bool{
+		{21, 59}: false,
+		{22, 0}:  true,
+		{23, 30}: true,
+		{0, 0}:   true,
+		{5, 59}:  true,
+		{6, 0}:   false,
+		{12, 0}:  true,
+		{13, 29}: true,
+		{13, 30}: false,
+	}
+	for at, want := range cases {
+		if got := quiet(at[0], at[1]); got != want {
+			t.Errorf("%02d:%02d quiet = %v, want %v", at[0], at[1], got, want)
+		}
+	}
+
+	for _, spec := range []string{"22:00", "25:00-01:00", "22:00-6", "22:00-06:00,"} {
+		if _, err := parseQuietHours(spec); err == nil {
+			t.Errorf("parseQuietHours(%q) accepted", spec)
+		}
+	}
+}
+
+func TestCompositePolicy(t *testing.T) {
+	fire := func(name string, on *bool) Trigger {
+		return Trigger{Name: name, Fire: func(ScheduleState) bool { return *on }}
+	}
+	block := func(name string, on *bool) Guard {
+		return Guard{Name: name, Block: func(ScheduleState) bool { return *on }}
+	}
+	var trigger, forced, guard, hardGuard bool
+	p := &CompositePolicy{
+		Triggers:   []Trigger{fire("never", new(bool)), fire("trigger", &trigger)},
+		Forced:     []Trigger{fire("forced", &forced)},
+		Guards:     []Guard{block("guard", &guard)},
+		HardGuards: []Guard{block("hard", &hardGuard)},
+	}
+	cases := []struct {
+		trigger, forced, guard, hardGuard bool
+		want                              string
+	}{
+		{want: ""},
+		{trigger: true, want: "trigger"},
+		{trigger: true, guard: true, want: ""},
+		{forced: true, guard: true, want: "forced"},
+		{trigger: true, forced: true, want: "forced"},
+		{forced: true, hardGuard: true, want: ""},
+		{trigger: true, hardGuard: true, want: ""},
+	}
+	for _, c := range cases {
+		trigger, forced, guard, hardGuard = c.trigger, c.forced, c.guard, c.hardGuard
+		ok, reason := p.ShouldSnapshot(ScheduleState{})
+		if ok != (c.want != "") || reason != c.want {
+			t.Errorf("%+v: (%v, %q)", c, ok, reason)
+		}
+	}
+}
+
+func TestSchedulePolicyCronKeepsInterval(t *testing.T) {
+	t.Setenv("CHECKPOINT_INTERVAL", "60")
+	t.Setenv("SNAPSHOT_CRON", "0 2 * * *")
+	p := NewSchedulePolicyFromEnv()
+	at := func(hour, minute, second int) time.Time {
+		return time.Date(2026, time.March, 2, hour, minute, second, 0, time.Local)
+	}
+	state := func(now time.Time, sinceLast time.Duration) ScheduleState {
+		return ScheduleState{Now: now, LastSnapshot: now.Add(-sinceLast), RequestsSinceSnapshot: 1}
+	}
+
+	// O intervalo continua valendo fora do horário do cron.
+	if ok, reason := p.ShouldSnapshot(state(at(10, 0, 0), 2*time.Minute)); !ok || reason != "interval" {
+		t.Errorf("interval with SNAPSHOT_CRON: (%v, %q)", ok, reason)
+	}
+	if ok, reason := p.ShouldSnapshot(state(at(2, 0, 0), 30*time.Second)); !ok || reason != "cron" {
+		t.Errorf("cron minute: (%v, %q)", ok, reason)
+	}
+	// Um disparo por minuto casado.
+	if ok, reason := p.ShouldSnapshot(state(at(2, 0, 20), 20*time.Second)); ok {
+		t.Errorf("cron fired twice in the same minute (%q)", reason)
+	}
+}
+
+func TestSchedulePolicyQuietHoursVetoesCron(t *testing.T) {
+	t.Setenv("CHECKPOINT_INTERVAL", "60")
+	t.Setenv("SNAPSHOT_CRON", "0 2 * * *")
+	t.Setenv("SNAPSHOT_QUIET_HOURS", "01:00-03:00")
+	t.Setenv("SNAPSHOT_MAX_INTERVAL", "3600")
+	p := NewSchedulePolicyFromEnv()
+	night := time.Date(2026, time.March, 2, 2, 0, 0, 0, time.Local)
+
+	if ok, reason := p.ShouldSnapshot(ScheduleState{Now: night, LastSnapshot: night.Add(-30 * time.Second), RequestsSinceSnapshot: 1}); ok {
+		t.Errorf("snapshot inside quiet hours (%q)", reason)
+	}
+	// SNAPSHOT_MAX_INTERVAL vence a janela.
+	later := night.Add(time.Minute)
+	if ok, reason := p.ShouldSnapshot(ScheduleState{Now: later, LastSnapshot: later.Add(-2 * time.Hour), RequestsSinceSnapshot: 1}); !ok || reason != "max-interval" {
+		t.Errorf("max interval inside quiet hours: (%v, %q)", ok, reason)
+	}
+}
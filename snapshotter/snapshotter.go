@@ -6,12 +6,9 @@ import (
 	"interceptor-grpc/crController"
 	"interceptor-grpc/interceptor"
 	"interceptor-grpc/protos"
-	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog/log"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 // Espera máxima pela drenagem da fila de recuperação antes de um snapshot.
@@ -23,30 +20,80 @@ var maxQueueWait = 2 * time.Minute
 var snapshotStartTime time.Time
 var replyTimeout = 4 * time.Minute
 
-// snapshotGeneration identifies which snapshot a safety-net goroutine was armed for.
-// Without it, the goroutine from snapshot N (sleeping replyTimeout, which can coincide
-// with the checkpoint interval) wakes up exactly when snapshot N+1 starts, sees the
-// global IsDoingSnapshot flag set and releases N+1's locks, unblocking traffic while
-// the daemon is still dumping/pushing.
-var snapshotGeneration atomic.Uint64
+// Os snapshots pulados por gate fechado/veredito pendente são reavaliados a
+// cada segundo; o log de skip sai no máximo uma vez por este intervalo.
+const skipLogInterval = 30 * time.Second
 
 func GenerateSnapshots(ctx context.Context) {
-	tick := time.Tick(time.Duration(config.GetCheckpointInterval()) * time.Second)
+	// Avalia a política a cada segundo; quando dispara é ela que decide (o
+	// intervalo fixo de CHECKPOINT_INTERVAL é só um dos gatilhos).
+	tick := time.Tick(time.Second)
 	maxSnapshotDuration := 5 * time.Minute
-	for range tick {
+	policy := currentSchedulePolicy()
+	sampler := &writeRateSampler{window: time.Duration(config.GetSnapshotWriteRateWindow()) * time.Second}
+	lastSnapshot := time.Now()
+	lastSnapshotRequest := config.GetLatestRequestNumber()
+	earlyRequest := ""
+	var lastSkipLog time.Time
+	monitor := &durabilityMonitor{}
+	if crController.LastSnapshotSuccess.Load() == 0 {
+		crController.LastSnapshotSuccess.Store(lastSnapshot.UnixNano())
+	}
+
+	for {
+		select {
+		case <-tick:
+		case reason := <-config.SnapshotTrigger:
+			log.Info().Str("reason", reason).Msg("Early snapshot requested")
+			earlyRequest = reason
+		}
+
+		now := time.Now()
+		monitor.check(now)
+		latest := config.GetLatestRequestNumber()
+		stats := config.GetRequestStats()
+		state := ScheduleState{
+			Now:                   now,
+			LastSnapshot:          lastSnapshot,
+			RequestsSinceSnapshot: latest - lastSnapshotRequest,
+			UnsnapshottedRequests: stats.Pending + stats.Processed,
+			UnsnapshottedBytes:    stats.UnsnapshottedBytes,
+			WriteRate:             sampler.observe(now, latest),
+			EarlyRequest:          earlyRequest,
+		}
+		// Depois de uma falha quem manda é o backoff, não a política: o retry
+		// sai assim que o backoff vence e nada começa antes disso.
+		var start bool
+		var reason string
+		if pending, due := retryDue(now); pending {
+			start, reason = due, "retry"
+		} else {
+			start, reason = policy.ShouldSnapshot(state)
+		}
+		if !start {
+			continue
+		}
+		skipLog := time.Since(lastSkipLog) >= skipLogInterval
+
 		// NUNCA snapshotar com o gate fechado (outage/recuperação em curso):
 		// um checkpoint entre o restore e o replay captura o estado REVERTIDO
 		// e marca o buffer como Snapshoted sem que os writes estejam nele —
 		// perda permanente (medido no v5, quando a detecção do canário atrasou).
 		if crController.IsContainerUnavailable.Load() {
-			log.Warn().Msg("Snapshot skipped: container unavailable (outage/recovery in progress)")
+			if skipLog {
+				log.Warn().Msg("Snapshot skipped: container unavailable (outage/recovery in progress)")
+				lastSkipLog = now
+			}
 			continue
 		}
 		if crController.CanaryVerdictPending.Load() {
 			// Houve fechamento de gate (possível restore) e o canário ainda não
 			// deu veredito: snapshotar agora poderia capturar estado revertido
 			// e lavar o buffer (writes perdidos). Espera o veredito.
-			log.Warn().Msg("Snapshot skipped: canary verdict pending after gate closure")
+			if skipLog {
+				log.Warn().Msg("Snapshot skipped: canary verdict pending after gate closure")
+				lastSkipLog = now
+			}
 			continue
 		}
 
@@ -68,9 +115,16 @@ func GenerateSnapshots(ctx context.Context) {
 		}
 		config.IsSnapshotBeingTaken = true
 		snapshotStartTime = time.Now()
-		snapshotGeneration.Add(1)
+		crController.SnapshotGeneration.Add(1)
 		config.SnapshotLock.Unlock()
 
+		lastSnapshot = snapshotStartTime
+		lastSnapshotRequest = latest
+		earlyRequest = ""
+		log.Info().Str("trigger", reason).Uint64("requests_since_last", state.RequestsSinceSnapshot).
+			Int64("consecutive_failures", crController.ConsecutiveSnapshotFailures.Load()).
+			Float64("write_rate", state.WriteRate).Msg("Snapshot scheduled")
+
 		if waited := waitRecoveryQueueDrain(); waited > 0 {
 			log.Info().Dur("waited", waited).Msg("Snapshot deferred until recovery queue drained")
 		}
@@ -106,6 +160,10 @@ func generateSnapshot(ctx context.Context) {
 	crController.IsDoingSnapshot.Store(true)
 	log.Info().Msg("Snapshot started: blocking new requests")
 
+	// Upgrade/WebSocket não entram em InFlightRequests: a política
+	// LONG_LIVED_CONN_POLICY decide se espera ou fecha antes do dump.
+	interceptor.CloseLongLivedConnections()
+
 	// Wait for all in-flight HTTP requests to complete
 	waitDone := make(chan struct{})
 	go func() {
@@ -134,30 +192,23 @@ func generateSnapshot(ctx context.Context) {
 		Uint64("latestRequest", snapshotRequest.LatestRequest).
 		Msg("Sending snapshot request to daemon")
 
-	// Create connection with timeout
-	connCtx, connCancel := context.WithTimeout(ctx, 10*time.Second)
-	defer connCancel()
-
-	conn, err := grpc.NewClient(config.GetDaemonGrpcUrl(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	// Conexão persistente; o deadline da chamada vem de DAEMON_RPC_TIMEOUT.
+	c, err := daemonClient()
 	if err != nil {
 		log.Err(err).Str("url", config.GetDaemonGrpcUrl()).Msg("Failed to connect to daemon gRPC server")
-		releaseSnapshotLocks()
+		recordSnapshotFailure("connect")
 		return
 	}
-	defer conn.Close()
-
-	c := protos.NewSnapshotRPCServiceClient(conn)
 
-	// Use timeout context for the Create call
-	response, err := c.Create(connCtx, snapshotRequest)
+	response, err := c.Create(ctx, snapshotRequest)
 	if err != nil {
 		log.Err(err).Msg("Failed to send snapshot request")
-		releaseSnapshotLocks()
+		recordSnapshotFailure("create")
 		return
 	}
 	if response.GetResponse() != true {
 		log.Error().Str("error", response.GetError()).Msg("Daemon rejected snapshot request")
-		releaseSnapshotLocks()
+		recordSnapshotFailure("rejected")
 		return
 	}
 
@@ -166,15 +217,15 @@ func generateSnapshot(ctx context.Context) {
 	// Safety net: release locks if Reply() is not received in time.
 	// Without this, a daemon failure after Create() leaves the system blocked indefinitely.
 	// Generation-guarded: only releases the snapshot it was armed for, never a later one.
-	gen := snapshotGeneration.Load()
+	gen := crController.SnapshotGeneration.Load()
 	go func() {
 		time.Sleep(replyTimeout)
-		if crController.IsDoingSnapshot.Load() && snapshotGeneration.Load() == gen {
+		if crController.IsDoingSnapshot.Load() && crController.SnapshotGeneration.Load() == gen {
 			log.Warn().
 				Dur("timeout", replyTimeout).
 				Uint64("generation", gen).
 				Msg("Reply() not received in time, forcing lock release")
-			releaseSnapshotLocks()
+			recordSnapshotFailure("reply-timeout")
 		}
 	}()
 }
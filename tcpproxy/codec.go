@@ -0,0 +1,129 @@
+package tcpproxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrFrameTooLarge = errors.New("tcpproxy: frame exceeds TCP_PROXY_MAX_FRAME")
+
+// Codec separa o stream cliente->upstream em registros. ReadFrame devolve o
+// frame COM o framing (prefixo, delimitador): é exatamente o que vai pro
+// buffer e é reescrito no upstream no replay, sem re-codificação.
+type Codec interface {
+	ReadFrame(r *bufio.Reader, maxFrame int) ([]byte, error)
+}
+
+// CodecFactory cria um codec a partir dos argumentos da especificação
+// (o que vem depois de "nome:" em TCP_PROXY_CODEC).
+type CodecFactory func(args string) (Codec, error)
+
+var codecsMutex sync.RWMutex
+var codecs = map[string]CodecFactory{
+	"newline": func(string) (Codec, error) { return DelimiterCodec{Delimiter: '\n'}, nil },
+	"delimiter": func(args string) (Codec, error) {
+		if len(args) != 1 {
+			return nil, errors.New("delimiter codec expects a single byte, e.g. delimiter:;")
+		}
+		return DelimiterCodec{Delimiter: args[0]}, nil
+	},
+	"length": func(args string) (Codec, error) {
+		size := 4
+		if args != "" {
+			n, err := strconv.Atoi(args)
+			if err != nil {
+				return nil, err
+			}
+			size = n
+		}
+		if size != 1 && size != 2 && size != 4 && size != 8 {
+			return nil, fmt.Errorf("length codec prefix must be 1, 2, 4 or 8 bytes, got %d", size)
+		}
+		return LengthPrefixedCodec{PrefixSize: size}, nil
+	},
+}
+
+// RegisterCodec registra um codec customizado, selecionável via
+// TCP_PROXY_CODEC=<nome>[:<args>]. Deve ser chamada antes de Serve.
+func RegisterCodec(name string, factory CodecFactory) {
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+	codecs[name] = factory
+}
+
+// NewCodec resolve uma especificação "nome[:args]" no codec registrado.
+func NewCodec(spec string) (Codec, error) {
+	name, args, _ := strings.Cut(spec, ":")
+	codecsMutex.RLock()
+	factory, ok := codecs[name]
+	codecsMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+	return factory(args)
+}
+
+// DelimiterCodec: frames terminados por um byte (newline para protocolos de
+// texto estilo memcached/SMTP).
+type DelimiterCodec struct {
+	Delimiter byte
+}
+
+func (c DelimiterCodec) ReadFrame(r *bufio.Reader, maxFrame int) ([]byte, error) {
+	var frame []byte
+	for {
+		chunk, err := r.ReadSlice(c.Delimiter)
+		frame = append(frame, chunk...)
+		if len(frame) > maxFrame {
+			return nil, ErrFrameTooLarge
+		}
+		if err == nil {
+			return frame, nil
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			if errors.Is(err, io.EOF) && len(frame) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+}
+
+// LengthPrefixedCodec: frames com prefixo big-endian de PrefixSize bytes
+// contendo o tamanho do payload (sem contar o próprio prefixo).
+type LengthPrefixedCodec struct {
+	PrefixSize int
+}
+
+func (c LengthPrefixedCodec) ReadFrame(r *bufio.Reader, maxFrame int) ([]byte, error) {
+	prefix := make([]byte, c.PrefixSize)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+	var size uint64
+	switch c.PrefixSize {
+	case 1:
+		size = uint64(prefix[0])
+	case 2:
+		size = uint64(binary.BigEndian.Uint16(prefix))
+	case 4:
+		size = uint64(binary.BigEndian.Uint32(prefix))
+	case 8:
+		size = binary.BigEndian.Uint64(prefix)
+	}
+	if size > uint64(maxFrame) {
+		return nil, ErrFrameTooLarge
+	}
+	frame := make([]byte, c.PrefixSize+int(size))
+	copy(frame, prefix)
+	if _, err := io.ReadFull(r, frame[c.PrefixSize:]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
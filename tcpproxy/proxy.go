@@ -0,0 +1,134 @@
+package tcpproxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+	"interceptor-grpc/interceptor"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Protocol é o valor de config.RequestData.Protocol das entradas TCP cruas.
+const Protocol = "tcp"
+
+// Mesmo teto do spin-gate do Handler HTTP.
+const gateTimeout = 5 * time.Minute
+
+// Serve abre o listener TCP cru. Deve ser chamada numa goroutine, como o
+// listener HTTP. Um codec inválido é erro de configuração: derruba o
+// processo, como as demais validações de env.
+func Serve() {
+	codec, err := NewCodec(config.GetTcpProxyCodec())
+	if err != nil {
+		log.Fatal().Err(err).Str("codec", config.GetTcpProxyCodec()).Msg("Invalid TCP_PROXY_CODEC")
+	}
+	lis, err := net.Listen("tcp", config.GetTcpProxyPort())
+	if err != nil {
+		log.Fatal().Err(err).Str("port", config.GetTcpProxyPort()).Msg("Failed to start TCP listener")
+	}
+	log.Info().Str("port", config.GetTcpProxyPort()).Str("codec", config.GetTcpProxyCodec()).
+		Str("upstream", config.GetTcpProxyUpstreamAddr()).Msg("TCP listener started")
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			log.Err(err).Msg("Error accepting TCP connection")
+			continue
+		}
+		go handleConn(conn, codec)
+	}
+}
+
+// handleConn aplica o gate no estabelecimento e entre frames. Sem conhecer o
+// protocolo não dá pra separar leitura de escrita nem casar resposta com
+// pedido: todo frame cliente->upstream entra no buffer, e o sentido
+// upstream->cliente é copiado cru.
+func handleConn(client net.Conn, codec Codec) {
+	defer client.Close()
+
+	if !waitGate() {
+		return
+	}
+	// Mesmo ciclo do RESP: conta em InFlightRequests até entrar no registro
+	// de longa duração, que fecha a conexão antes do checkpoint.
+	crController.InFlightRequests.Add(1)
+	upstream, err := net.DialTimeout("tcp", config.GetTcpProxyUpstreamAddr(), 10*time.Second)
+	if err != nil {
+		crController.InFlightRequests.Done()
+		log.Err(err).Msg("Error connecting to TCP upstream")
+		return
+	}
+	defer upstream.Close()
+	untrack := interceptor.TrackLongLived("tcp", func() {
+		_ = client.Close()
+		_ = upstream.Close()
+	})
+	defer untrack()
+	closing := crController.IsDoingSnapshot.Load() || crController.IsRestoringSnapshot.Load()
+	crController.InFlightRequests.Done()
+	if closing {
+		return
+	}
+
+	go func() {
+		_, _ = io.Copy(client, upstream)
+		_ = client.Close()
+	}()
+
+	r := bufio.NewReader(client)
+	maxFrame := config.GetTcpProxyMaxFrame()
+	for {
+		frame, err := codec.ReadFrame(r, maxFrame)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Warn().Err(err).Msg("TCP connection closed: framing error")
+			}
+			return
+		}
+		if !waitGate() {
+			log.Warn().Msg("TCP connection closed: timed out waiting for container to be available")
+			return
+		}
+		// Sem protocolo não há como responder erro: com DURABILITY_HARD_MODE a
+		// recusa é fechar a conexão antes do frame chegar no upstream.
+		if crController.WritesRejected() {
+			log.Warn().Msg("TCP connection closed: writes rejected, snapshot durability degraded")
+			return
+		}
+
+		crController.InFlightRequests.Add(1)
+		requestNumber := config.SaveRequestToBuffer(config.RequestData{
+			Protocol: Protocol,
+			Method:   config.GetTcpProxyCodec(),
+			Body:     frame,
+		})
+		_, err = upstream.Write(frame)
+		config.UpdateRequestToProcessed(requestNumber)
+		crController.InFlightRequests.Done()
+		if err != nil {
+			log.Err(err).Msg("Error forwarding TCP frame")
+			return
+		}
+	}
+}
+
+// waitGate segura enquanto há snapshot, restore, indisponibilidade ou replay
+// TCP pendente. Retorna false se estourou o teto.
+func waitGate() bool {
+	start := time.Now()
+	for crController.IsDoingSnapshot.Load() ||
+		crController.IsRestoringSnapshot.Load() ||
+		crController.IsContainerUnavailable.Load() ||
+		ReplayQueueLength.Load() > 0 {
+		if time.Since(start) > gateTimeout {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
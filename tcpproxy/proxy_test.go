@@ -0,0 +1,115 @@
+package tcpproxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+)
+
+func TestHandleConnBuffersEveryFrame(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("hello\n"))
+		data, _ := io.ReadAll(conn)
+		received <- data
+	}()
+	t.Setenv("TCP_PROXY_UPSTREAM_ADDR", lis.Addr().String())
+	t.Setenv("TCP_PROXY_CODEC", "newline")
+	before := config.GetLatestRequestNumber()
+
+	client, proxySide := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		handleConn(proxySide, DelimiterCodec{Delimiter: '\n'})
+		close(done)
+	}()
+	// O sentido upstream->cliente é copiado cru.
+	greeting := make([]byte, 6)
+	if _, err := io.ReadFull(client, greeting); err != nil || string(greeting) != "hello\n" {
+		t.Fatalf("greeting %q, error %v", greeting, err)
+	}
+	stream := []byte("set a 1\nget a\n")
+	if _, err := client.Write(stream); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.Close()
+	<-done
+
+	if got := <-received; !bytes.Equal(got, stream) {
+		t.Errorf("upstream received %q, want %q", got, stream)
+	}
+	var frames []string
+	for _, entry := range config.GetReprocessableRequests() {
+		if entry.RequestNumber <= before {
+			continue
+		}
+		if entry.Data.Protocol != Protocol || entry.Data.Method != "newline" || entry.State != config.Processed {
+			t.Errorf("entry %d: protocol %q method %q state %d", entry.RequestNumber,
+				entry.Data.Protocol, entry.Data.Method, entry.State)
+		}
+		frames = append(frames, string(entry.Data.Body))
+		config.RemoveRequestFromBuffer(entry.RequestNumber)
+	}
+	// Sem conhecer o protocolo, leitura também entra no buffer.
+	if len(frames) != 2 || frames[0] != "set a 1\n" || frames[1] != "get a\n" {
+		t.Errorf("buffered frames %q", frames)
+	}
+}
+
+// Com DURABILITY_HARD_MODE e a durabilidade degradada não há como responder
+// erro num protocolo desconhecido: a conexão fecha antes do frame seguir.
+func TestHandleConnClosesWhenDurabilityDegraded(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- data
+	}()
+	t.Setenv("TCP_PROXY_UPSTREAM_ADDR", lis.Addr().String())
+	t.Setenv("DURABILITY_HARD_MODE", "true")
+	crController.DurabilityDegraded.Store(true)
+	defer crController.DurabilityDegraded.Store(false)
+	before := config.GetLatestRequestNumber()
+
+	client, proxySide := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		handleConn(proxySide, DelimiterCodec{Delimiter: '\n'})
+		close(done)
+	}()
+	if _, err := client.Write([]byte("set a 1\n")); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	_ = client.Close()
+
+	if got := <-received; len(got) != 0 {
+		t.Errorf("upstream received %q", got)
+	}
+	if n := config.GetLatestRequestNumber(); n != before {
+		t.Errorf("rejected frame buffered (%d entries)", n-before)
+	}
+}
@@ -0,0 +1,94 @@
+package tcpproxy
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"interceptor-grpc/config"
+	"interceptor-grpc/crController"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Depois do último frame do lote, quanto tempo a conexão de replay fica
+// aberta consumindo respostas antes de fechar (fechar na hora pode fazer o
+// upstream abortar o processamento do que ainda está no socket).
+const replayLinger = 2 * time.Second
+
+var ReplayQueueLength = atomic.Uint32{}
+var replayQueue = make([]config.RequestData, 0)
+var replayQueueMutex sync.Mutex
+
+// AddToReplayQueue enfileira um frame bufferizado pra replay após uma
+// regressão detectada. Registrada via
+// crController.RegisterProtocolReprocessCallback.
+func AddToReplayQueue(data config.RequestData) {
+	replayQueueMutex.Lock()
+	defer replayQueueMutex.Unlock()
+
+	replayQueue = append(replayQueue, data)
+	ReplayQueueLength.Add(1)
+}
+
+func takeReplayQueue() []config.RequestData {
+	replayQueueMutex.Lock()
+	defer replayQueueMutex.Unlock()
+
+	batch := replayQueue
+	replayQueue = make([]config.RequestData, 0)
+	return batch
+}
+
+func requeueFront(batch []config.RequestData) {
+	replayQueueMutex.Lock()
+	defer replayQueueMutex.Unlock()
+
+	replayQueue = append(batch, replayQueue...)
+	ReplayQueueLength.Store(uint32(len(replayQueue)))
+}
+
+// ProcessReplayQueue reenvia os frames bufferizados, na ordem original, numa
+// conexão nova. As respostas são descartadas: sem parser do protocolo não há
+// como interpretá-las (o cliente original já foi atendido).
+func ProcessReplayQueue() {
+	for {
+		time.Sleep(50 * time.Millisecond)
+
+		if ReplayQueueLength.Load() == 0 {
+			continue
+		}
+		if crController.IsDoingSnapshot.Load() || crController.IsRestoringSnapshot.Load() ||
+			crController.IsContainerUnavailable.Load() {
+			continue
+		}
+
+		conn, err := net.DialTimeout("tcp", config.GetTcpProxyUpstreamAddr(), 10*time.Second)
+		if err != nil {
+			log.Err(err).Msg("TCP replay: error connecting to upstream")
+			continue
+		}
+		go func() { _, _ = io.Copy(io.Discard, conn) }()
+
+		batch := takeReplayQueue()
+		sent := 0
+		crController.InFlightRequests.Add(1)
+		for _, data := range batch {
+			requestNumber := config.SaveRequestToBuffer(data)
+			if _, err := conn.Write(data.Body); err != nil {
+				config.RemoveRequestFromBuffer(requestNumber)
+				log.Err(err).Msg("TCP replay: upstream error, will retry")
+				break
+			}
+			config.UpdateRequestToProcessed(requestNumber)
+			sent++
+		}
+		requeueFront(batch[sent:])
+		time.Sleep(replayLinger)
+		_ = conn.Close()
+		crController.InFlightRequests.Done()
+		log.Info().Int("replayed", sent).Msg("TCP replay: buffered frames re-sent")
+	}
+}
@@ -0,0 +1,224 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Intervalo mínimo entre stats dos arquivos: o reload é checado no handshake,
+// que no listener HTTP pode ser muito frequente.
+const reloadCheckInterval = time.Second
+
+// fileWatch guarda o mtime dos arquivos pra saber quando recarregar. Rotação
+// por cert-manager/secret montado troca o arquivo (symlink) e o mtime muda.
+type fileWatch struct {
+	files     []string
+	modTimes  []time.Time
+	lastCheck time.Time
+}
+
+// changed diz se algum arquivo mudou desde o último load; o primeiro uso
+// sempre conta como mudança.
+func (w *fileWatch) changed() bool {
+	now := time.Now()
+	if !w.lastCheck.IsZero() && now.Sub(w.lastCheck) < reloadCheckInterval {
+		return false
+	}
+	w.lastCheck = now
+	changed := w.modTimes == nil
+	modTimes := make([]time.Time, len(w.files))
+	for i, file := range w.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			// Arquivo sumiu no meio da rotação: segue com o que está carregado.
+			return false
+		}
+		modTimes[i] = info.ModTime()
+		if w.modTimes != nil && !modTimes[i].Equal(w.modTimes[i]) {
+			changed = true
+		}
+	}
+	if changed {
+		w.modTimes = modTimes
+	}
+	return changed
+}
+
+// CertReloader serve um par certificado/chave do disco e recarrega quando
+// qualquer um dos arquivos muda: certificado rotacionado vale sem restart.
+type CertReloader struct {
+	mutex sync.Mutex
+	watch fileWatch
+	cert  *tls.Certificate
+}
+
+// NewCertReloader carrega o par uma vez e falha se ele não serve.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{watch: fileWatch{files: []string{certFile, keyFile}}}
+	if _, err := r.current(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) current() (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.watch.changed() && r.cert != nil {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.watch.files[0], r.watch.files[1])
+	if err != nil {
+		if r.cert != nil {
+			log.Err(err).Str("cert", r.watch.files[0]).Msg("Error reloading TLS certificate, keeping the previous one")
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	if r.cert != nil {
+		log.Info().Str("cert", r.watch.files[0]).Msg("TLS certificate reloaded")
+	}
+	r.cert = &cert
+	return r.cert, nil
+}
+
+// GetCertificate é pra tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.current()
+}
+
+// GetClientCertificate é pra tls.Config.GetClientCertificate.
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.current()
+}
+
+// CAReloader serve um bundle de CA do disco e recarrega quando o arquivo muda.
+type CAReloader struct {
+	mutex sync.Mutex
+	watch fileWatch
+	pool  *x509.CertPool
+}
+
+// NewCAReloader carrega o bundle uma vez e falha se não há certificado nele.
+func NewCAReloader(caFile string) (*CAReloader, error) {
+	r := &CAReloader{watch: fileWatch{files: []string{caFile}}}
+	if _, err := r.current(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CAReloader) current() (*x509.CertPool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.watch.changed() && r.pool != nil {
+		return r.pool, nil
+	}
+	pem, err := os.ReadFile(r.watch.files[0])
+	if err == nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			err = fmt.Errorf("no certificate found in %s", r.watch.files[0])
+		} else {
+			if r.pool != nil {
+				log.Info().Str("ca", r.watch.files[0]).Msg("TLS CA bundle reloaded")
+			}
+			r.pool = pool
+		}
+	}
+	if err != nil {
+		if r.pool != nil {
+			log.Err(err).Str("ca", r.watch.files[0]).Msg("Error reloading TLS CA bundle, keeping the previous one")
+			return r.pool, nil
+		}
+		return nil, err
+	}
+	return r.pool, nil
+}
+
+// Pool retorna o pool de CAs atual.
+func (r *CAReloader) Pool() *x509.CertPool {
+	pool, _ := r.current()
+	return pool
+}
+
+// ServerConfig monta o tls.Config de servidor sobre os reloaders. Com CA de
+// cliente, os peers são verificados contra ela conforme clientAuth.
+func ServerConfig(cert *CertReloader, clientCA *CAReloader, clientAuth tls.ClientAuthType) *tls.Config {
+	base := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cert.GetCertificate,
+	}
+	if clientCA == nil {
+		return base
+	}
+	base.ClientAuth = clientAuth
+	// ClientCAs é lido no handshake: um Config por conexão pega o bundle atual.
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.ClientCAs = clientCA.Pool()
+		return cfg, nil
+	}
+	return base
+}
+
+// ClientConfig monta o tls.Config de cliente sobre os reloaders. cert é o
+// certificado de cliente opcional (mTLS); ca, se dado, substitui os roots do
+// sistema e é relido a cada handshake. O nome verificado é serverName ou, sem
+// ele, o SNI da conexão — que não existe pra IP: aí serverName é obrigatório.
+func ClientConfig(cert *CertReloader, ca *CAReloader, serverName string) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+	if cert != nil {
+		cfg.GetClientCertificate = cert.GetClientCertificate
+	}
+	if ca == nil {
+		return cfg
+	}
+	// RootCAs é fixo no Config; pra o bundle recarregado valer sem recriar a
+	// conexão, a verificação da cadeia é feita aqui com o pool atual.
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("tls: server presented no certificate")
+		}
+		name := serverName
+		if name == "" {
+			name = cs.ServerName
+		}
+		if name == "" {
+			return errors.New("tls: no server name to verify the certificate against")
+		}
+		intermediates := x509.NewCertPool()
+		for _, c := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(c)
+		}
+		_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+			Roots:         ca.Pool(),
+			Intermediates: intermediates,
+			DNSName:       name,
+		})
+		return err
+	}
+	return cfg
+}
+
+// SpiffeID retorna o URI SAN spiffe:// do certificado, ou "".
+func SpiffeID(cert *x509.Certificate) string {
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			return uri.String()
+		}
+	}
+	return ""
+}
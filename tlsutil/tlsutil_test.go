@@ -0,0 +1,107 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCertPEM(t *testing.T, der []byte) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// otherCA gera uma CA que não assinou o certificado do servidor de teste.
+func otherCA(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "other CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		DNSNames:              []string{"example.com"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeCertPEM(t, der)
+}
+
+func TestClientConfigVerifiesServer(t *testing.T) {
+	// O certificado do httptest vale pra example.com e 127.0.0.1.
+	server := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+	serverCA, err := NewCAReloader(writeCertPEM(t, server.Certificate().Raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongCA, err := NewCAReloader(otherCA(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name       string
+		ca         *CAReloader
+		serverName string
+		// sni é o ServerName que o chamador põe na cópia (como http.Transport).
+		sni string
+		ok  bool
+	}{
+		{"name from SNI", serverCA, "", "example.com", true},
+		{"explicit name", serverCA, "example.com", "", true},
+		{"explicit IP", serverCA, "127.0.0.1", "", true},
+		{"wrong SNI", serverCA, "", "wrong.example", false},
+		{"wrong explicit name", serverCA, "wrong.example", "", false},
+		{"IP without name", serverCA, "", "127.0.0.1", false},
+		{"wrong CA", wrongCA, "example.com", "", false},
+	}
+	for _, c := range cases {
+		cfg := ClientConfig(nil, c.ca, c.serverName)
+		if c.sni != "" {
+			cfg = cfg.Clone()
+			cfg.ServerName = c.sni
+		}
+		conn, err := tls.Dial("tcp", addr, cfg)
+		if err == nil {
+			conn.Close()
+		}
+		if (err == nil) != c.ok {
+			t.Errorf("%s: handshake error %v, want ok=%v", c.name, err, c.ok)
+		}
+	}
+}
+
+func TestNewCAReloaderRejectsEmptyBundle(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(file, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCAReloader(file); err == nil {
+		t.Error("bundle without certificates accepted")
+	}
+}